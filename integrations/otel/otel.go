@@ -0,0 +1,153 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package otel integrates glogger with OpenTelemetry tracing: the request
+// logs of the middlewares get the ids of the span started by otelhttp or
+// otelfiber, and the log entries can be recorded as span events, with any
+// core.Logger. The otellogrus package records the entries of a logrus
+// logger too.
+package otel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanEventName is the name of the events recorded by RecordSpanEvent.
+const SpanEventName = "log"
+
+// WithSpanContext is a middleware option adding the core.TraceIDKey and
+// core.SpanIDKey fields of the active span to the request logger, and so to
+// the incoming request and request completed entries. The tracing
+// middleware must run before the logging one, e.g. wrapping the mux router
+// with otelhttp or registering otelfiber first.
+func WithSpanContext() utils.Option {
+	return utils.WithContextFields(SpanContextFields)
+}
+
+// SpanContextFields returns the core.TraceIDKey and core.SpanIDKey fields of
+// the span in ctx, or nil when ctx has no valid span context.
+func SpanContextFields(ctx context.Context) map[string]any {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return map[string]any{
+		core.TraceIDKey: spanContext.TraceID().String(),
+		core.SpanIDKey:  spanContext.SpanID().String(),
+	}
+}
+
+// WithSpanEvents wraps logger, recording the Info entries it writes as
+// events of the span in their context, set with WithContext: pass it to the
+// middlewares to record the request completed entries. The Trace entries
+// are not recorded.
+func WithSpanEvents[T any](logger core.Logger[T]) core.Logger[T] {
+	return &spanEventLogger[T]{logger: logger}
+}
+
+type spanEventLogger[T any] struct {
+	logger core.Logger[T]
+	ctx    context.Context
+	fields map[string]any
+}
+
+func (l *spanEventLogger[T]) WithFields(fields map[string]any) core.Logger[T] {
+	merged := make(map[string]any, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &spanEventLogger[T]{logger: l.logger.WithFields(fields), ctx: l.ctx, fields: merged}
+}
+
+func (l *spanEventLogger[T]) WithContext(ctx context.Context) core.Logger[T] {
+	return &spanEventLogger[T]{logger: l.logger.WithContext(ctx), ctx: ctx, fields: l.fields}
+}
+
+func (l *spanEventLogger[T]) Trace(msg string) {
+	l.logger.Trace(msg)
+}
+
+func (l *spanEventLogger[T]) Info(msg string) {
+	RecordSpanEvent(l.ctx, time.Now(), "info", msg, l.fields)
+	l.logger.Info(msg)
+}
+
+func (l *spanEventLogger[T]) OriginalLogger() T {
+	return l.logger.OriginalLogger()
+}
+
+// RecordSpanEvent records a log entry as an event of the span in ctx, when
+// it is recording. The event has the log.severity and log.message
+// attributes, and an attribute for each field but the span ids.
+func RecordSpanEvent(ctx context.Context, timestamp time.Time, severity, message string, fields map[string]any) {
+	if ctx == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	attributes := make([]attribute.KeyValue, 0, len(fields)+2)
+	attributes = append(attributes,
+		attribute.String("log.severity", severity),
+		attribute.String("log.message", message),
+	)
+	for key, value := range fields {
+		if key == core.TraceIDKey || key == core.SpanIDKey {
+			continue
+		}
+		attributes = append(attributes, fieldAttribute(key, value))
+	}
+	span.AddEvent(SpanEventName, trace.WithTimestamp(timestamp), trace.WithAttributes(attributes...))
+}
+
+// fieldAttribute converts a field, encoding to JSON the values that are not
+// a primitive type.
+func fieldAttribute(key string, value any) attribute.KeyValue {
+	switch value := value.(type) {
+	case string:
+		return attribute.String(key, value)
+	case bool:
+		return attribute.Bool(key, value)
+	case int:
+		return attribute.Int(key, value)
+	case int64:
+		return attribute.Int64(key, value)
+	case float64:
+		return attribute.Float64(key, value)
+	case error:
+		return attribute.String(key, value.Error())
+	case fmt.Stringer:
+		return attribute.String(key, value.String())
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return attribute.String(key, fmt.Sprint(value))
+	}
+	return attribute.String(key, string(encoded))
+}
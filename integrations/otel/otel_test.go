@@ -0,0 +1,139 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/mia-platform/glogger/v4/loggers/fake"
+	"github.com/mia-platform/glogger/v4/middleware/mux"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var spanContext = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+// recordingSpan records the events added to the span.
+type recordingSpan struct {
+	noop.Span
+
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+type recordedEvent struct {
+	name       string
+	time       time.Time
+	attributes []attribute.KeyValue
+}
+
+func (s *recordingSpan) IsRecording() bool { return true }
+
+func (s *recordingSpan) SpanContext() trace.SpanContext { return spanContext }
+
+func (s *recordingSpan) AddEvent(name string, options ...trace.EventOption) {
+	config := trace.NewEventConfig(options...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, recordedEvent{name: name, time: config.Timestamp(), attributes: config.Attributes()})
+}
+
+func TestSpanContextFields(t *testing.T) {
+	require.Nil(t, SpanContextFields(context.Background()))
+
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	require.Equal(t, map[string]any{
+		core.TraceIDKey: "4bf92f3577b34da6a3ce929d0e0e4736",
+		core.SpanIDKey:  "00f067aa0ba902b7",
+	}, SpanContextFields(ctx))
+}
+
+func TestWithSpanContext(t *testing.T) {
+	glog := fake.GetLogger()
+	handler := mux.RequestMiddlewareLogger(glog, nil, WithSpanContext())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// the span context set by the tracing middleware
+	req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+	req = req.WithContext(trace.ContextWithSpanContext(req.Context(), spanContext))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := glog.OriginalLogger().AllRecords()
+	require.Len(t, records, 2)
+	for _, record := range records {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record.Fields[core.TraceIDKey])
+		require.Equal(t, "00f067aa0ba902b7", record.Fields[core.SpanIDKey])
+	}
+}
+
+func TestRecordSpanEvent(t *testing.T) {
+	span := &recordingSpan{}
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	eventTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	RecordSpanEvent(context.Background(), eventTime, "warning", "without a span", nil)
+	RecordSpanEvent(ctx, eventTime, "warning", "retrying", map[string]any{
+		core.TraceIDKey: "4bf92f3577b34da6a3ce929d0e0e4736",
+		"count":         3,
+		"err":           errors.New("connection refused"),
+		"item":          map[string]any{"id": 1},
+	})
+
+	require.Len(t, span.events, 1)
+	event := span.events[0]
+	require.Equal(t, SpanEventName, event.name)
+	require.Equal(t, eventTime, event.time)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("log.severity", "warning"),
+		attribute.String("log.message", "retrying"),
+		attribute.Int("count", 3),
+		attribute.String("err", "connection refused"),
+		attribute.String("item", `{"id":1}`),
+	}, event.attributes)
+}
+
+func TestWithSpanEvents(t *testing.T) {
+	glog := fake.GetLogger()
+	handler := mux.RequestMiddlewareLogger(WithSpanEvents[*fake.Entry](glog), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	span := &recordingSpan{}
+	req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+	req = req.WithContext(trace.ContextWithSpan(req.Context(), span))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, glog.OriginalLogger().AllRecords(), 2)
+	require.Len(t, span.events, 1, "only the request completed entry is recorded")
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.events[0].attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	require.Equal(t, "info", attributes["log.severity"].AsString())
+	require.Equal(t, "request completed", attributes["log.message"].AsString())
+	require.Contains(t, attributes["url"].AsString(), `"path":"/my-req"`)
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package otellogrus records the entries of a logrus logger as
+// OpenTelemetry span events, like the otel package does for the entries of
+// the middlewares.
+package otellogrus
+
+import (
+	"github.com/mia-platform/glogger/v4/integrations/otel"
+	"github.com/sirupsen/logrus"
+)
+
+// SpanEventHook is a logrus.Hook recording the entries as events of the
+// span in their context, set with WithContext: the logger injected by the
+// middlewares has the request context. The events are recorded with
+// otel.RecordSpanEvent.
+type SpanEventHook struct {
+	levels []logrus.Level
+}
+
+// NewSpanEventHook returns a SpanEventHook for the entries with minLevel or
+// a more severe one, e.g. logrus.WarnLevel.
+func NewSpanEventHook(minLevel logrus.Level) *SpanEventHook {
+	var levels []logrus.Level
+	for _, level := range logrus.AllLevels {
+		if level <= minLevel {
+			levels = append(levels, level)
+		}
+	}
+	return &SpanEventHook{levels: levels}
+}
+
+func (h *SpanEventHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *SpanEventHook) Fire(entry *logrus.Entry) error {
+	otel.RecordSpanEvent(entry.Context, entry.Time, entry.Level.String(), entry.Message, entry.Data)
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otellogrus
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/integrations/otel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingSpan records the events added to the span.
+type recordingSpan struct {
+	noop.Span
+
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+type recordedEvent struct {
+	name       string
+	time       time.Time
+	attributes []attribute.KeyValue
+}
+
+func (s *recordingSpan) IsRecording() bool { return true }
+
+func (s *recordingSpan) AddEvent(name string, options ...trace.EventOption) {
+	config := trace.NewEventConfig(options...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, recordedEvent{name: name, time: config.Timestamp(), attributes: config.Attributes()})
+}
+
+func TestSpanEventHook(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.SetLevel(logrus.TraceLevel)
+	logger.AddHook(NewSpanEventHook(logrus.WarnLevel))
+
+	span := &recordingSpan{}
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	entryTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	entry := logger.WithContext(ctx).WithTime(entryTime).WithField("count", 3)
+
+	entry.Info("not recorded")
+	entry.Warn("retrying")
+	logger.Error("without a span")
+
+	require.Len(t, span.events, 1)
+	event := span.events[0]
+	require.Equal(t, otel.SpanEventName, event.name)
+	require.Equal(t, entryTime, event.time)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("log.severity", "warning"),
+		attribute.String("log.message", "retrying"),
+		attribute.Int("count", 3),
+	}, event.attributes)
+}
+
+func TestNewSpanEventHook(t *testing.T) {
+	require.Equal(t, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}, NewSpanEventHook(logrus.ErrorLevel).Levels())
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+// Keys of the fields added to the logs by the middlewares, part of the log
+// schema shared by the middlewares and the loggers.
+const (
+	RequestIDKey    = "reqId"
+	HTTPKey         = "http"
+	URLKey          = "url"
+	HostKey         = "host"
+	ResponseTimeKey = "responseTime"
+	TraceKey        = "trace"
+	SpanKey         = "span"
+
+	// TraceIDKey and SpanIDKey are the keys of the ids of the OpenTelemetry
+	// span, moved by the OTLP sinks to the trace context of the records.
+	TraceIDKey = "traceId"
+	SpanIDKey  = "spanId"
+)
+
+// DefaultRedactionMask replaces the sensitive values, in the fields of the
+// middlewares and in the entries redacted by the loggers.
+const DefaultRedactionMask = "[REDACTED]"
+
+// ObjectEncoder writes the fields of a JSON object.
+type ObjectEncoder interface {
+	AddString(key, value string)
+	AddInt(key string, value int)
+	AddBool(key string, value bool)
+	AddStrings(key string, values []string)
+	AddObject(key string, value ObjectMarshaler) error
+	// AddValue writes any other value, e.g. a map.
+	AddValue(key string, value any) error
+}
+
+// ObjectMarshaler is implemented by the values the loggers encode without
+// reflection, e.g. the fields of the middlewares. MarshalLogObject must
+// write the same fields encoding/json writes for the value.
+type ObjectMarshaler interface {
+	MarshalLogObject(encoder ObjectEncoder) error
+}
+
+// Redactor masks sensitive strings.
+type Redactor interface {
+	// RedactString masks the sensitive data inside s.
+	RedactString(s string) string
+	// RedactStringMap masks the values of m by their key and content,
+	// returning a copy and true when something is masked.
+	RedactStringMap(m map[string]string) (map[string]string, bool)
+}
+
+// Redactable is implemented by the values holding sensitive strings the
+// loggers redact, e.g. the headers and bodies logged by the middlewares.
+type Redactable interface {
+	// Redact returns a copy of the value with its strings masked and true,
+	// or the value and false when nothing is masked.
+	Redact(redactor Redactor) (any, bool)
+}
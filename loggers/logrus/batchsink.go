@@ -0,0 +1,458 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchOptions configures how a sink groups the entries before sending them.
+type BatchOptions struct {
+	// MaxBatchSize is the maximum number of entries sent together. Defaults
+	// to 512.
+	MaxBatchSize int
+	// MaxBatchBytes, if set, also limits the size of a batch: it is sent once
+	// its entries reach this size.
+	MaxBatchBytes int
+	// MaxQueueSize bounds the entries waiting to be sent: when the queue is
+	// full, new entries are dropped. Defaults to 2048.
+	MaxQueueSize int
+	// FlushInterval is the maximum time an entry waits before being sent.
+	// Defaults to 1s.
+	FlushInterval time.Duration
+}
+
+// RetryOptions configures the retries of failed requests, with an
+// exponential backoff.
+type RetryOptions struct {
+	// Disabled disables the retries.
+	Disabled bool
+	// InitialInterval is the wait before the first retry. Defaults to 1s.
+	InitialInterval time.Duration
+	// MaxInterval caps the wait between retries. Defaults to 30s.
+	MaxInterval time.Duration
+	// MaxElapsedTime is the time after which a batch is given up. Defaults
+	// to 1m.
+	MaxElapsedTime time.Duration
+}
+
+// BatchStats counts the entries handled by a batching sink.
+type BatchStats struct {
+	// Exported entries have been accepted by the backend.
+	Exported uint64
+	// Dropped entries have been discarded because the queue was full.
+	Dropped uint64
+	// Failed entries have been rejected by the backend, or could not be
+	// sent within the retry time.
+	Failed uint64
+}
+
+// batchSink queues the lines written to it and exports them in batches from
+// a background goroutine, so that writes never wait for the backend.
+type batchSink struct {
+	export        func(ctx context.Context, lines [][]byte) error
+	maxBatchSize  int
+	maxBatchBytes int
+	flushInterval time.Duration
+
+	// slots bounds the batches exported concurrently, when more than one.
+	slots    chan struct{}
+	inflight sync.WaitGroup
+	// asyncErr joins the errors of the concurrent exports, until a flush
+	// returns them.
+	mu       sync.Mutex
+	asyncErr error
+
+	queue         chan []byte
+	flushRequests chan chan error
+	done          chan struct{}
+	stopped       chan struct{}
+	ctx           context.Context
+	cancel        context.CancelFunc
+
+	// state guards closed, so that no line is queued after the final drain
+	state  sync.RWMutex
+	closed bool
+	// shutdownErr is the error of the final export, set before stopped is
+	// closed.
+	shutdownErr error
+
+	exported atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+func newBatchSink(options BatchOptions, export func(ctx context.Context, lines [][]byte) error) *batchSink {
+	return newConcurrentBatchSink(options, 1, export)
+}
+
+// newConcurrentBatchSink returns a batchSink exporting up to concurrency
+// batches at the same time: export must be safe for concurrent use.
+func newConcurrentBatchSink(options BatchOptions, concurrency int, export func(ctx context.Context, lines [][]byte) error) *batchSink {
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = 512
+	}
+	if options.MaxQueueSize <= 0 {
+		options.MaxQueueSize = 2048
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &batchSink{
+		export:        export,
+		maxBatchSize:  options.MaxBatchSize,
+		maxBatchBytes: options.MaxBatchBytes,
+		flushInterval: options.FlushInterval,
+		queue:         make(chan []byte, options.MaxQueueSize),
+		flushRequests: make(chan chan error),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	if concurrency > 1 {
+		b.slots = make(chan struct{}, concurrency)
+	}
+	go b.run()
+	return b
+}
+
+// Write queues a copy of the line. When the queue is full the line is
+// dropped, and counted in the stats.
+func (b *batchSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b.state.RLock()
+	defer b.state.RUnlock()
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+
+	select {
+	case b.queue <- append([]byte(nil), p...):
+	default:
+		b.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Flush sends the queued entries, waiting for the backend response.
+func (b *batchSink) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case b.flushRequests <- reply:
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown sends the queued entries and stops the sink, returning the error
+// of the last export. If ctx expires first, the pending requests are
+// aborted.
+func (b *batchSink) Shutdown(ctx context.Context) error {
+	b.state.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	b.state.Unlock()
+
+	select {
+	case <-b.stopped:
+		return b.shutdownErr
+	case <-ctx.Done():
+		b.cancel()
+		<-b.stopped
+		return errors.Join(ctx.Err(), b.shutdownErr)
+	}
+}
+
+// Close is Shutdown without a deadline.
+func (b *batchSink) Close() error {
+	return b.Shutdown(context.Background())
+}
+
+// Stats returns the counters of the sink.
+func (b *batchSink) Stats() BatchStats {
+	return BatchStats{
+		Exported: b.exported.Load(),
+		Dropped:  b.dropped.Load(),
+		Failed:   b.failed.Load(),
+	}
+}
+
+func (b *batchSink) run() {
+	defer close(b.stopped)
+	defer b.cancel()
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, b.maxBatchSize)
+	var batchBytes int
+	var err error
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		pending := batch
+		batch = make([][]byte, 0, b.maxBatchSize)
+		batchBytes = 0
+		if b.slots == nil {
+			err = errors.Join(err, b.send(pending))
+			return
+		}
+
+		b.slots <- struct{}{}
+		b.inflight.Add(1)
+		go func() {
+			defer b.inflight.Done()
+			defer func() { <-b.slots }()
+			if sendErr := b.send(pending); sendErr != nil {
+				b.mu.Lock()
+				b.asyncErr = errors.Join(b.asyncErr, sendErr)
+				b.mu.Unlock()
+			}
+		}()
+	}
+	add := func(line []byte) {
+		batch = append(batch, line)
+		batchBytes += len(line)
+		if len(batch) >= b.maxBatchSize || (b.maxBatchBytes > 0 && batchBytes >= b.maxBatchBytes) {
+			send()
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case line := <-b.queue:
+				add(line)
+			default:
+				send()
+				// wait for the batches exported concurrently
+				b.inflight.Wait()
+				b.mu.Lock()
+				err = errors.Join(err, b.asyncErr)
+				b.asyncErr = nil
+				b.mu.Unlock()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case line := <-b.queue:
+			add(line)
+		case <-ticker.C:
+			send()
+		case reply := <-b.flushRequests:
+			err = nil
+			drain()
+			reply <- err
+		case <-b.done:
+			drain()
+			b.shutdownErr = err
+			return
+		}
+		err = nil
+	}
+}
+
+func (b *batchSink) send(batch [][]byte) error {
+	if err := b.export(b.ctx, batch); err != nil {
+		failed := len(batch)
+		var partial *partialExportError
+		if errors.As(err, &partial) {
+			failed = partial.failed
+		}
+		b.failed.Add(uint64(failed))
+		b.exported.Add(uint64(len(batch) - failed))
+		reportSinkError(err)
+		return err
+	}
+	b.exported.Add(uint64(len(batch)))
+	return nil
+}
+
+// partialExportError is returned by the exports when only some entries of
+// the batch could not be delivered, counted by failed.
+type partialExportError struct {
+	failed int
+	err    error
+}
+
+func (e *partialExportError) Error() string { return e.err.Error() }
+func (e *partialExportError) Unwrap() error { return e.err }
+
+// retryableError marks the errors worth a retry, e.g. a 503 response.
+type retryableError struct {
+	err error
+	// retryAfter is the wait requested by the server, if any.
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// withRetry calls fn until it succeeds, it returns an error that is not a
+// retryableError or the retry time is over.
+func withRetry(ctx context.Context, options RetryOptions, fn func(ctx context.Context) error) error {
+	initial, maxInterval, maxElapsed := options.InitialInterval, options.MaxInterval, options.MaxElapsedTime
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+	if maxElapsed <= 0 {
+		maxElapsed = time.Minute
+	}
+
+	deadline := time.Now().Add(maxElapsed)
+	interval := initial
+	for {
+		err := fn(ctx)
+		var retryable *retryableError
+		if err == nil || options.Disabled || !errors.As(err, &retryable) {
+			return err
+		}
+
+		// wait interval +/- 20%, unless the server asked for something else
+		wait := retryable.retryAfter
+		if wait <= 0 {
+			wait = interval + time.Duration((rand.Float64()*0.4-0.2)*float64(interval))
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("giving up after %s: %w", maxElapsed, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// maxCheckedResponseSize limits the response bodies read by checkResponse.
+const maxCheckedResponseSize = 16 << 20
+
+// httpRequest is a POST of a batch to an HTTP backend.
+type httpRequest struct {
+	client      *http.Client
+	url         string
+	contentType string
+	headers     map[string]string
+	gzip        bool
+	// checkResponse, if set, checks the body of the successful responses,
+	// for the backends reporting failures there.
+	checkResponse func(body []byte) error
+}
+
+// post sends the body, returning a retryableError for network errors and
+// for the 429, 502, 503 and 504 responses.
+func (r httpRequest) post(ctx context.Context, body []byte) error {
+	if r.gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(body)
+		writer.Close()
+		body = compressed.Bytes()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", r.contentType)
+	if r.gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	for name, value := range r.headers {
+		request.Header.Set(name, value)
+	}
+
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return &retryableError{err: err}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		if r.checkResponse == nil {
+			io.Copy(io.Discard, io.LimitReader(response.Body, 1024))
+			return nil
+		}
+		responseBody, err := io.ReadAll(io.LimitReader(response.Body, maxCheckedResponseSize))
+		if err != nil {
+			return &retryableError{err: err}
+		}
+		return r.checkResponse(responseBody)
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	err = fmt.Errorf("%s responded %s: %s", r.url, response.Status, bytes.TrimSpace(responseBody))
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &retryableError{err: err, retryAfter: parseRetryAfter(response.Header.Get("Retry-After"))}
+	default:
+		return err
+	}
+}
+
+// parseRetryAfter parses the delay seconds form of the Retry-After header.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRetry(t *testing.T) {
+	fastRetry := RetryOptions{InitialInterval: time.Millisecond, MaxInterval: 2 * time.Millisecond, MaxElapsedTime: 50 * time.Millisecond}
+
+	t.Run("retries retryable errors only", func(t *testing.T) {
+		var calls int
+		err := withRetry(context.Background(), fastRetry, func(context.Context) error {
+			if calls++; calls < 3 {
+				return &retryableError{err: errors.New("unavailable")}
+			}
+			return errors.New("rejected")
+		})
+		require.EqualError(t, err, "rejected")
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up after the max elapsed time", func(t *testing.T) {
+		var calls int
+		err := withRetry(context.Background(), fastRetry, func(context.Context) error {
+			calls++
+			return &retryableError{err: errors.New("unavailable")}
+		})
+		require.EqualError(t, err, "giving up after 50ms: unavailable")
+		require.Greater(t, calls, 5)
+	})
+
+	t.Run("honors the requested wait", func(t *testing.T) {
+		err := withRetry(context.Background(), fastRetry, func(context.Context) error {
+			return &retryableError{err: errors.New("slow down"), retryAfter: time.Minute}
+		})
+		require.EqualError(t, err, "giving up after 50ms: slow down")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var calls int
+		err := withRetry(context.Background(), RetryOptions{Disabled: true}, func(context.Context) error {
+			calls++
+			return &retryableError{err: errors.New("unavailable")}
+		})
+		require.EqualError(t, err, "unavailable")
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := withRetry(ctx, RetryOptions{InitialInterval: time.Hour, MaxElapsedTime: 2 * time.Hour}, func(context.Context) error {
+			return &retryableError{err: errors.New("unavailable")}
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestHTTPRequest(t *testing.T) {
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(status)
+		w.Write([]byte("details\n"))
+	}))
+	defer server.Close()
+	request := httpRequest{url: server.URL, contentType: "text/plain"}
+
+	for _, testCase := range []struct {
+		status     int
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{status: http.StatusNoContent},
+		{status: http.StatusBadRequest},
+		{status: http.StatusInternalServerError},
+		{status: http.StatusTooManyRequests, retryable: true, retryAfter: 7 * time.Second},
+		{status: http.StatusBadGateway, retryable: true, retryAfter: 7 * time.Second},
+		{status: http.StatusServiceUnavailable, retryable: true, retryAfter: 7 * time.Second},
+		{status: http.StatusGatewayTimeout, retryable: true, retryAfter: 7 * time.Second},
+	} {
+		status = testCase.status
+		err := request.post(context.Background(), []byte("body"))
+		if testCase.status < 300 {
+			require.NoError(t, err)
+			continue
+		}
+		require.ErrorContains(t, err, http.StatusText(testCase.status)+": details")
+		var retryable *retryableError
+		require.Equal(t, testCase.retryable, errors.As(err, &retryable), testCase.status)
+		if testCase.retryable {
+			require.Equal(t, testCase.retryAfter, retryable.retryAfter)
+		}
+	}
+
+	t.Run("checks the successful responses", func(t *testing.T) {
+		status = http.StatusOK
+		checked := request
+		checked.checkResponse = func(body []byte) error {
+			require.Equal(t, "details\n", string(body))
+			return errors.New("rejected")
+		}
+		require.EqualError(t, checked.post(context.Background(), nil), "rejected")
+	})
+
+	t.Run("network errors are retryable", func(t *testing.T) {
+		err := httpRequest{url: "http://127.0.0.1:1"}.post(context.Background(), nil)
+		var retryable *retryableError
+		require.ErrorAs(t, err, &retryable)
+	})
+}
+
+func TestBatchSink(t *testing.T) {
+	t.Run("limits the batch bytes", func(t *testing.T) {
+		var mu sync.Mutex
+		var batches [][]string
+		sink := newBatchSink(BatchOptions{MaxBatchBytes: 10}, func(ctx context.Context, lines [][]byte) error {
+			batch := make([]string, 0, len(lines))
+			for _, line := range lines {
+				batch = append(batch, string(line))
+			}
+			mu.Lock()
+			batches = append(batches, batch)
+			mu.Unlock()
+			return nil
+		})
+		for _, line := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+			sink.Write([]byte(line))
+		}
+		require.NoError(t, sink.Close())
+		require.Equal(t, [][]string{{"aaaa", "bbbb", "cccc"}, {"dddd"}}, batches)
+	})
+
+	t.Run("exports concurrently up to the limit", func(t *testing.T) {
+		var current, peak atomic.Int32
+		release := make(chan struct{})
+		sink := newConcurrentBatchSink(BatchOptions{MaxBatchSize: 1}, 3, func(ctx context.Context, lines [][]byte) error {
+			n := current.Add(1)
+			defer current.Add(-1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			<-release
+			if string(lines[0]) == "fail" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		for _, line := range []string{"a", "b", "fail", "d", "e"} {
+			sink.Write([]byte(line))
+		}
+		require.Eventually(t, func() bool { return current.Load() == 3 }, time.Second, time.Millisecond)
+		close(release)
+
+		require.EqualError(t, sink.Flush(context.Background()), "failed")
+		require.Equal(t, int32(3), peak.Load())
+		require.Equal(t, BatchStats{Exported: 4, Failed: 1}, sink.Stats())
+		require.NoError(t, sink.Close())
+	})
+
+	t.Run("returns the error of the final export", func(t *testing.T) {
+		sink := newBatchSink(BatchOptions{FlushInterval: time.Hour}, func(ctx context.Context, lines [][]byte) error {
+			return errors.New("backend down")
+		})
+		sink.Write([]byte("last"))
+		require.EqualError(t, sink.Close(), "backend down")
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+	})
+
+	t.Run("does not lose lines written while shutting down", func(t *testing.T) {
+		var exported atomic.Uint64
+		sink := newBatchSink(BatchOptions{MaxQueueSize: 1 << 16}, func(ctx context.Context, lines [][]byte) error {
+			exported.Add(uint64(len(lines)))
+			return nil
+		})
+
+		var accepted atomic.Uint64
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, err := sink.Write([]byte("line")); err != nil {
+						require.ErrorIs(t, err, os.ErrClosed)
+						return
+					}
+					accepted.Add(1)
+				}
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, sink.Close())
+		wg.Wait()
+
+		stats := sink.Stats()
+		require.Equal(t, accepted.Load(), stats.Exported+stats.Dropped)
+		require.Equal(t, exported.Load(), stats.Exported)
+	})
+}
@@ -0,0 +1,448 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Allowed values of Config.Format.
+const (
+	FormatJSON   = "json"
+	FormatPretty = "pretty"
+	FormatText   = "text"
+)
+
+// Allowed values of Config.Output.
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+)
+
+// Environment variables read by LoadConfigFromEnv.
+const (
+	EnvLevel              = "LOG_LEVEL"
+	EnvFormat             = "LOG_FORMAT"
+	EnvOutput             = "LOG_OUTPUT"
+	EnvDisableHTMLEscape  = "LOG_DISABLE_HTML_ESCAPE"
+	EnvRedactKeys         = "LOG_REDACT_KEYS"
+	EnvRedactPatterns     = "LOG_REDACT_PATTERNS"
+	EnvRedactMask         = "LOG_REDACT_MASK"
+	EnvSamplingInitial    = "LOG_SAMPLING_INITIAL"
+	EnvSamplingThereafter = "LOG_SAMPLING_THEREAFTER"
+	EnvSamplingTick       = "LOG_SAMPLING_TICK"
+	EnvComponentKey       = "LOG_COMPONENT_KEY"
+	EnvComponentLevels    = "LOG_COMPONENT_LEVELS"
+	EnvFilePath           = "LOG_FILE_PATH"
+	EnvFileMaxSize        = "LOG_FILE_MAX_SIZE"
+	EnvFileRotation       = "LOG_FILE_ROTATION_INTERVAL"
+	EnvFileMaxBackups     = "LOG_FILE_MAX_BACKUPS"
+	EnvFileMaxAge         = "LOG_FILE_MAX_AGE"
+	EnvFileCompress       = "LOG_FILE_COMPRESS"
+)
+
+// Config is the logger configuration, as read from the environment or from a
+// YAML or JSON file. Each field documents its environment variable.
+type Config struct {
+	// Level is the logrus level (LOG_LEVEL). Defaults to info.
+	Level string `json:"level,omitempty" yaml:"level,omitempty"`
+	// Format is one of json, pretty or text (LOG_FORMAT). Defaults to json.
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// Output is one of stdout, stderr or file (LOG_OUTPUT). Defaults to
+	// stderr.
+	Output string `json:"output,omitempty" yaml:"output,omitempty"`
+	// File configures the rotating file used when Output is file
+	// (LOG_FILE_PATH, LOG_FILE_MAX_SIZE, LOG_FILE_ROTATION_INTERVAL,
+	// LOG_FILE_MAX_BACKUPS, LOG_FILE_MAX_AGE, LOG_FILE_COMPRESS).
+	File *FileSinkOptions `json:"file,omitempty" yaml:"file,omitempty"`
+	// DisableHTMLEscape disables the html escaping of the JSON formatter
+	// (LOG_DISABLE_HTML_ESCAPE).
+	DisableHTMLEscape bool `json:"disableHTMLEscape,omitempty" yaml:"disableHTMLEscape,omitempty"`
+	// Redaction masks the sensitive data: keys are a comma separated list
+	// (LOG_REDACT_KEYS), patterns a JSON array of regular expressions
+	// (LOG_REDACT_PATTERNS) and the mask a string (LOG_REDACT_MASK).
+	Redaction RedactionRules `json:"redaction,omitempty" yaml:"redaction,omitempty"`
+	// Sampling limits the entries with the same level and message
+	// (LOG_SAMPLING_INITIAL, LOG_SAMPLING_THEREAFTER, LOG_SAMPLING_TICK).
+	Sampling *SamplingOptions `json:"sampling,omitempty" yaml:"sampling,omitempty"`
+	// ComponentKey is the field identifying the component of an entry
+	// (LOG_COMPONENT_KEY). Defaults to component.
+	ComponentKey string `json:"componentKey,omitempty" yaml:"componentKey,omitempty"`
+	// ComponentLevels sets a level for each component, as a comma separated
+	// list of component=level pairs (LOG_COMPONENT_LEVELS).
+	ComponentLevels map[string]string `json:"componentLevels,omitempty" yaml:"componentLevels,omitempty"`
+}
+
+// Duration is a time.Duration written as a string, e.g. 1s or 500ms, in
+// configuration files.
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. 1s: %w", err)
+	}
+	return d.parse(s)
+}
+
+// MarshalYAML writes the duration as a string.
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML reads the duration from a string.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. 1s: %w", err)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadConfigFromEnv reads the configuration from the environment variables.
+func LoadConfigFromEnv() (Config, error) {
+	return loadConfigFromLookup(os.LookupEnv)
+}
+
+func loadConfigFromLookup(lookup func(string) (string, bool)) (Config, error) {
+	var config Config
+	var errs []error
+	get := func(name string) string {
+		value, _ := lookup(name)
+		return strings.TrimSpace(value)
+	}
+
+	parseBool := func(name string, target *bool) {
+		if value := get(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", name, value))
+			}
+			*target = parsed
+		}
+	}
+	parseInt := func(name string, target *int) {
+		if value := get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", name, value))
+			}
+			*target = parsed
+		}
+	}
+	parseDuration := func(name string, target *Duration) {
+		if value := get(name); value != "" {
+			if err := target.parse(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", name, value))
+			}
+		}
+	}
+	anySet := func(names ...string) bool {
+		for _, name := range names {
+			if get(name) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	config.Level = get(EnvLevel)
+	config.Format = get(EnvFormat)
+	config.Output = get(EnvOutput)
+	parseBool(EnvDisableHTMLEscape, &config.DisableHTMLEscape)
+
+	if anySet(EnvFilePath, EnvFileMaxSize, EnvFileRotation, EnvFileMaxBackups, EnvFileMaxAge, EnvFileCompress) {
+		config.File = &FileSinkOptions{Path: get(EnvFilePath)}
+		if value := get(EnvFileMaxSize); value != "" {
+			maxSize, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", EnvFileMaxSize, value))
+			}
+			config.File.MaxSize = maxSize
+		}
+		parseDuration(EnvFileRotation, &config.File.RotationInterval)
+		parseInt(EnvFileMaxBackups, &config.File.MaxBackups)
+		parseDuration(EnvFileMaxAge, &config.File.MaxAge)
+		parseBool(EnvFileCompress, &config.File.Compress)
+	}
+
+	config.Redaction.Keys = splitList(get(EnvRedactKeys))
+	if value := get(EnvRedactPatterns); value != "" {
+		if err := json.Unmarshal([]byte(value), &config.Redaction.Patterns); err != nil {
+			errs = append(errs, fmt.Errorf("%s: must be a JSON array of strings: %w", EnvRedactPatterns, err))
+		}
+	}
+	config.Redaction.Mask = get(EnvRedactMask)
+
+	if anySet(EnvSamplingInitial, EnvSamplingThereafter, EnvSamplingTick) {
+		config.Sampling = &SamplingOptions{}
+		parseInt(EnvSamplingInitial, &config.Sampling.Initial)
+		parseInt(EnvSamplingThereafter, &config.Sampling.Thereafter)
+		parseDuration(EnvSamplingTick, &config.Sampling.Tick)
+	}
+
+	config.ComponentKey = get(EnvComponentKey)
+	for _, pair := range splitList(get(EnvComponentLevels)) {
+		component, level, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(component) == "" {
+			errs = append(errs, fmt.Errorf("%s: %q must be in the form component=level", EnvComponentLevels, pair))
+			continue
+		}
+		if config.ComponentLevels == nil {
+			config.ComponentLevels = map[string]string{}
+		}
+		config.ComponentLevels[strings.TrimSpace(component)] = strings.TrimSpace(level)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, fmt.Errorf("invalid logger configuration: %w", err)
+	}
+	return config, config.Validate()
+}
+
+// LoadConfigFromFile reads the configuration from a YAML (.yaml or .yml) or
+// JSON (.json) file. Unknown keys are reported as errors.
+func LoadConfigFromFile(path string) (Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read logger configuration: %w", err)
+	}
+	return parseConfigFile(path, content)
+}
+
+func parseConfigFile(path string, content []byte) (Config, error) {
+	var config Config
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+	default:
+		return Config{}, fmt.Errorf("unsupported logger configuration file %s: extension must be .json, .yaml or .yml", path)
+	}
+	// An empty file is a valid, empty, configuration
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("invalid logger configuration file %s: %w", path, err)
+	}
+	return config, config.Validate()
+}
+
+// LoadConfigFromEnvFile reads the configuration from a file of KEY=value
+// lines, with the environment variables read by LoadConfigFromEnv. Empty
+// lines and lines starting with # are ignored, and values can be quoted.
+func LoadConfigFromEnvFile(path string) (Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read logger configuration: %w", err)
+	}
+	return parseConfigEnvFile(path, content)
+}
+
+func parseConfigEnvFile(path string, content []byte) (Config, error) {
+	variables := map[string]string{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !found {
+			return Config{}, fmt.Errorf("invalid logger configuration file %s: line %d is not a KEY=value pair", path, i+1)
+		}
+		variables[strings.TrimSpace(name)] = unquote(strings.TrimSpace(value))
+	}
+	return loadConfigFromLookup(func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	})
+}
+
+// unquote removes the quotes around a value, if any.
+func unquote(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Validate checks the configuration, reporting all the invalid values.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Level != "" {
+		if _, err := logrus.ParseLevel(c.Level); err != nil {
+			errs = append(errs, fmt.Errorf("level (%s): %q is not a valid level, use one of panic, fatal, error, warn, info, debug or trace", EnvLevel, c.Level))
+		}
+	}
+	switch c.Format {
+	case "", FormatJSON, FormatPretty, FormatText:
+	default:
+		errs = append(errs, fmt.Errorf("format (%s): %q is not a valid format, use one of %s, %s or %s", EnvFormat, c.Format, FormatJSON, FormatPretty, FormatText))
+	}
+	switch c.Output {
+	case "", OutputStdout, OutputStderr:
+		if c.File != nil {
+			errs = append(errs, fmt.Errorf("file (%s): set output to %s to write to a file", EnvFilePath, OutputFile))
+		}
+	case OutputFile:
+		errs = append(errs, c.File.validate()...)
+	default:
+		errs = append(errs, fmt.Errorf("output (%s): %q is not a valid output, use one of %s, %s or %s", EnvOutput, c.Output, OutputStdout, OutputStderr, OutputFile))
+	}
+	for _, pattern := range c.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("redaction.patterns (%s): %q is not a valid regular expression: %w", EnvRedactPatterns, pattern, err))
+		}
+	}
+	if c.Sampling != nil {
+		if c.Sampling.Initial < 0 {
+			errs = append(errs, fmt.Errorf("sampling.initial (%s): must not be negative", EnvSamplingInitial))
+		}
+		if c.Sampling.Thereafter < 0 {
+			errs = append(errs, fmt.Errorf("sampling.thereafter (%s): must not be negative", EnvSamplingThereafter))
+		}
+		if c.Sampling.Tick < 0 {
+			errs = append(errs, fmt.Errorf("sampling.tick (%s): must not be negative", EnvSamplingTick))
+		}
+		if c.Sampling.Initial == 0 && c.Sampling.Thereafter == 0 {
+			errs = append(errs, fmt.Errorf("sampling (%s, %s): initial or thereafter must be positive", EnvSamplingInitial, EnvSamplingThereafter))
+		}
+	}
+	for component, level := range c.ComponentLevels {
+		if _, err := logrus.ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("componentLevels (%s): %q is not a valid level for component %q", EnvComponentLevels, level, component))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid logger configuration: %w", err)
+	}
+	return nil
+}
+
+// InitOptions converts the configuration to the options of InitHelper.
+func (c Config) InitOptions() InitOptions {
+	options := InitOptions{
+		Level:             c.Level,
+		Format:            c.Format,
+		DisableHTMLEscape: c.DisableHTMLEscape,
+		Redaction:         c.Redaction,
+		Sampling:          c.Sampling,
+		ComponentKey:      c.ComponentKey,
+		ComponentLevels:   c.ComponentLevels,
+	}
+	switch c.Output {
+	case OutputStdout:
+		options.Output = os.Stdout
+	case OutputStderr:
+		options.Output = os.Stderr
+	case OutputFile:
+		options.File = c.File
+	}
+	return options
+}
+
+func (o *FileSinkOptions) validate() []error {
+	if o == nil || o.Path == "" {
+		return []error{fmt.Errorf("file.path (%s): is required when output is %s", EnvFilePath, OutputFile)}
+	}
+	var errs []error
+	if o.MaxSize < 0 {
+		errs = append(errs, fmt.Errorf("file.maxSize (%s): must not be negative", EnvFileMaxSize))
+	}
+	if o.RotationInterval < 0 {
+		errs = append(errs, fmt.Errorf("file.rotationInterval (%s): must not be negative", EnvFileRotation))
+	}
+	if o.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("file.maxBackups (%s): must not be negative", EnvFileMaxBackups))
+	}
+	if o.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("file.maxAge (%s): must not be negative", EnvFileMaxAge))
+	}
+	return errs
+}
+
+// InitFromEnv initializes the logger reading the configuration from the
+// environment variables.
+func InitFromEnv() (*logrus.Logger, error) {
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return InitHelper(config.InitOptions())
+}
+
+// InitFromFile initializes the logger reading the configuration from a YAML
+// or JSON file.
+func InitFromFile(path string) (*logrus.Logger, error) {
+	config, err := LoadConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return InitHelper(config.InitOptions())
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
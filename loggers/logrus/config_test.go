@@ -0,0 +1,329 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Run("empty environment", func(t *testing.T) {
+		config, err := LoadConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, Config{}, config)
+	})
+
+	t.Run("reads all the variables", func(t *testing.T) {
+		t.Setenv(EnvLevel, "debug")
+		t.Setenv(EnvFormat, "text")
+		t.Setenv(EnvOutput, "stdout")
+		t.Setenv(EnvDisableHTMLEscape, "true")
+		t.Setenv(EnvRedactKeys, "password, token,")
+		t.Setenv(EnvRedactPatterns, `["\\d{16}"]`)
+		t.Setenv(EnvRedactMask, "***")
+		t.Setenv(EnvSamplingInitial, "10")
+		t.Setenv(EnvSamplingThereafter, "100")
+		t.Setenv(EnvSamplingTick, "2s")
+		t.Setenv(EnvComponentKey, "module")
+		t.Setenv(EnvComponentLevels, "db=trace, http=warn")
+
+		config, err := LoadConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, Config{
+			Level:             "debug",
+			Format:            FormatText,
+			Output:            OutputStdout,
+			DisableHTMLEscape: true,
+			Redaction: RedactionRules{
+				Keys:     []string{"password", "token"},
+				Patterns: []string{`\d{16}`},
+				Mask:     "***",
+			},
+			Sampling:        &SamplingOptions{Initial: 10, Thereafter: 100, Tick: Duration(2 * time.Second)},
+			ComponentKey:    "module",
+			ComponentLevels: map[string]string{"db": "trace", "http": "warn"},
+		}, config)
+	})
+
+	t.Run("reads the file variables", func(t *testing.T) {
+		t.Setenv(EnvOutput, "file")
+		t.Setenv(EnvFilePath, "/var/log/app.log")
+		t.Setenv(EnvFileMaxSize, "1048576")
+		t.Setenv(EnvFileRotation, "24h")
+		t.Setenv(EnvFileMaxBackups, "7")
+		t.Setenv(EnvFileMaxAge, "168h")
+		t.Setenv(EnvFileCompress, "true")
+
+		config, err := LoadConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, Config{
+			Output: OutputFile,
+			File: &FileSinkOptions{
+				Path:             "/var/log/app.log",
+				MaxSize:          1048576,
+				RotationInterval: Duration(24 * time.Hour),
+				MaxBackups:       7,
+				MaxAge:           Duration(168 * time.Hour),
+				Compress:         true,
+			},
+		}, config)
+		require.Equal(t, config.File, config.InitOptions().File)
+	})
+
+	t.Run("reports inconsistent file variables", func(t *testing.T) {
+		t.Setenv(EnvOutput, "file")
+		t.Setenv(EnvFileMaxBackups, "-1")
+
+		_, err := LoadConfigFromEnv()
+		require.ErrorContains(t, err, "file.path (LOG_FILE_PATH): is required when output is file")
+
+		t.Setenv(EnvFilePath, "/var/log/app.log")
+		_, err = LoadConfigFromEnv()
+		require.ErrorContains(t, err, "file.maxBackups (LOG_FILE_MAX_BACKUPS): must not be negative")
+
+		t.Setenv(EnvOutput, "stdout")
+		t.Setenv(EnvFileMaxBackups, "")
+		_, err = LoadConfigFromEnv()
+		require.ErrorContains(t, err, "file (LOG_FILE_PATH): set output to file to write to a file")
+	})
+
+	t.Run("reports malformed variables", func(t *testing.T) {
+		t.Setenv(EnvDisableHTMLEscape, "maybe")
+		t.Setenv(EnvRedactPatterns, `\d`)
+		t.Setenv(EnvSamplingInitial, "ten")
+		t.Setenv(EnvSamplingTick, "soon")
+		t.Setenv(EnvComponentLevels, "db")
+
+		_, err := LoadConfigFromEnv()
+		require.Error(t, err)
+		for _, message := range []string{
+			`LOG_DISABLE_HTML_ESCAPE: "maybe" is not a boolean`,
+			`LOG_REDACT_PATTERNS: must be a JSON array of strings`,
+			`LOG_SAMPLING_INITIAL: "ten" is not an integer`,
+			`LOG_SAMPLING_TICK: "soon" is not a duration`,
+			`LOG_COMPONENT_LEVELS: "db" must be in the form component=level`,
+		} {
+			require.ErrorContains(t, err, message)
+		}
+	})
+
+	t.Run("reports invalid values", func(t *testing.T) {
+		t.Setenv(EnvLevel, "verbose")
+		t.Setenv(EnvFormat, "xml")
+		t.Setenv(EnvOutput, "printer")
+		t.Setenv(EnvRedactPatterns, `["("]`)
+		t.Setenv(EnvSamplingThereafter, "-1")
+		t.Setenv(EnvComponentLevels, "db=loud")
+
+		_, err := LoadConfigFromEnv()
+		require.Error(t, err)
+		for _, message := range []string{
+			`level (LOG_LEVEL): "verbose" is not a valid level`,
+			`format (LOG_FORMAT): "xml" is not a valid format`,
+			`output (LOG_OUTPUT): "printer" is not a valid output`,
+			`redaction.patterns (LOG_REDACT_PATTERNS): "(" is not a valid regular expression`,
+			`sampling.thereafter (LOG_SAMPLING_THEREAFTER): must not be negative`,
+			`componentLevels (LOG_COMPONENT_LEVELS): "loud" is not a valid level for component "db"`,
+		} {
+			require.ErrorContains(t, err, message)
+		}
+	})
+
+	t.Run("reports sampling dropping all the entries", func(t *testing.T) {
+		t.Setenv(EnvSamplingTick, "1s")
+
+		_, err := LoadConfigFromEnv()
+		require.ErrorContains(t, err, `sampling (LOG_SAMPLING_INITIAL, LOG_SAMPLING_THEREAFTER): initial or thereafter must be positive`)
+	})
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	expected := Config{
+		Level:           "warn",
+		Format:          FormatPretty,
+		Redaction:       RedactionRules{Keys: []string{"password"}},
+		Sampling:        &SamplingOptions{Initial: 5, Tick: Duration(500 * time.Millisecond)},
+		ComponentLevels: map[string]string{"db": "debug"},
+	}
+
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "config.yaml",
+			content: `level: warn
+format: pretty
+redaction:
+  keys: [password]
+sampling:
+  initial: 5
+  tick: 500ms
+componentLevels:
+  db: debug
+`,
+		},
+		{
+			name:    "config.json",
+			content: `{"level":"warn","format":"pretty","redaction":{"keys":["password"]},"sampling":{"initial":5,"tick":"500ms"},"componentLevels":{"db":"debug"}}`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := LoadConfigFromFile(writeConfigFile(t, testCase.name, testCase.content))
+			require.NoError(t, err)
+			require.Equal(t, expected, config)
+		})
+	}
+
+	t.Run("empty file", func(t *testing.T) {
+		config, err := LoadConfigFromFile(writeConfigFile(t, "config.yml", ""))
+		require.NoError(t, err)
+		require.Equal(t, Config{}, config)
+	})
+
+	t.Run("unknown keys", func(t *testing.T) {
+		_, err := LoadConfigFromFile(writeConfigFile(t, "config.yaml", "levle: info\n"))
+		require.ErrorContains(t, err, "field levle not found")
+
+		_, err = LoadConfigFromFile(writeConfigFile(t, "config.json", `{"levle":"info"}`))
+		require.ErrorContains(t, err, `unknown field "levle"`)
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		_, err := LoadConfigFromFile(writeConfigFile(t, "config.yaml", "sampling:\n  tick: 1\n"))
+		require.ErrorContains(t, err, "invalid logger configuration file")
+
+		_, err = LoadConfigFromFile(writeConfigFile(t, "config.json", `{"sampling":{"tick":1}}`))
+		require.ErrorContains(t, err, "duration must be a string")
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		_, err := LoadConfigFromFile(writeConfigFile(t, "config.yaml", "level: verbose\n"))
+		require.ErrorContains(t, err, `level (LOG_LEVEL): "verbose" is not a valid level`)
+
+		_, err = LoadConfigFromFile(writeConfigFile(t, "config.yaml", "sampling: {}\n"))
+		require.ErrorContains(t, err, `sampling (LOG_SAMPLING_INITIAL, LOG_SAMPLING_THEREAFTER): initial or thereafter must be positive`)
+	})
+
+	t.Run("unsupported extension", func(t *testing.T) {
+		_, err := LoadConfigFromFile(writeConfigFile(t, "config.toml", ""))
+		require.ErrorContains(t, err, "extension must be .json, .yaml or .yml")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadConfigFromFile(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestLoadConfigFromEnvFile(t *testing.T) {
+	config, err := LoadConfigFromEnvFile(writeConfigFile(t, "logging.env", `# logger settings
+LOG_LEVEL=warn
+export LOG_FORMAT="pretty"
+
+LOG_REDACT_KEYS = password, token
+LOG_REDACT_PATTERNS='["\\d{16}"]'
+`))
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Level:     "warn",
+		Format:    FormatPretty,
+		Redaction: RedactionRules{Keys: []string{"password", "token"}, Patterns: []string{`\d{16}`}},
+	}, config)
+
+	t.Run("invalid lines", func(t *testing.T) {
+		_, err := LoadConfigFromEnvFile(writeConfigFile(t, "logging.env", "LOG_LEVEL=warn\nLOG_FORMAT\n"))
+		require.ErrorContains(t, err, "line 2 is not a KEY=value pair")
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		_, err := LoadConfigFromEnvFile(writeConfigFile(t, "logging.env", "LOG_LEVEL=verbose\n"))
+		require.ErrorContains(t, err, `level (LOG_LEVEL): "verbose" is not a valid level`)
+	})
+}
+
+func TestInitFromConfig(t *testing.T) {
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv(EnvLevel, "debug")
+		t.Setenv(EnvFormat, "text")
+
+		logger, err := InitFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, logrus.DebugLevel, logger.GetLevel())
+		require.IsType(t, &logrus.TextFormatter{}, logger.Formatter)
+	})
+
+	t.Run("from env with invalid values", func(t *testing.T) {
+		t.Setenv(EnvLevel, "verbose")
+
+		logger, err := InitFromEnv()
+		require.Nil(t, logger)
+		require.Error(t, err)
+	})
+
+	t.Run("from file", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `level: info
+redaction:
+  keys: [password]
+componentKey: module
+componentLevels:
+  db: debug
+sampling:
+  initial: 1
+`)
+		logger, err := InitFromFile(path)
+		require.NoError(t, err)
+		require.Equal(t, logrus.DebugLevel, logger.GetLevel(), "the logger level is the most verbose one")
+
+		var buffer bytes.Buffer
+		logger.Out = &buffer
+		logger.WithFields(logrus.Fields{"module": "db", "password": "pwd"}).Debug("query")
+		logger.WithFields(logrus.Fields{"module": "db"}).Debug("query")
+		logger.WithFields(logrus.Fields{"module": "http"}).Debug("request")
+
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		require.Len(t, lines, 1)
+
+		var output map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &output))
+		require.Equal(t, "query", output["msg"])
+		require.Equal(t, DefaultRedactionMask, output["password"])
+	})
+
+	t.Run("output", func(t *testing.T) {
+		require.Equal(t, os.Stdout, Config{Output: OutputStdout}.InitOptions().Output)
+		require.Equal(t, os.Stderr, Config{Output: OutputStderr}.InitOptions().Output)
+		require.Nil(t, Config{}.InitOptions().Output)
+	})
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
@@ -0,0 +1,387 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+	fileSinkMode     = 0o644
+)
+
+// FileSinkOptions configures a FileSink.
+type FileSinkOptions struct {
+	// Path is the file the entries are written to. Its directory is created
+	// if missing.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// MaxSize is the size in bytes after which the file is rotated. Zero
+	// disables the rotation by size.
+	MaxSize int64 `json:"maxSize,omitempty" yaml:"maxSize,omitempty"`
+	// RotationInterval rotates the file at each multiple of the interval,
+	// aligned to UTC (e.g. 24h rotates at midnight UTC). Zero disables the
+	// rotation by time.
+	RotationInterval Duration `json:"rotationInterval,omitempty" yaml:"rotationInterval,omitempty"`
+	// MaxBackups is the number of rotated files to keep. Zero keeps them all.
+	MaxBackups int `json:"maxBackups,omitempty" yaml:"maxBackups,omitempty"`
+	// MaxAge is the age after which rotated files are removed. Zero keeps
+	// them all.
+	MaxAge Duration `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+	// Compress gzips the rotated files.
+	Compress bool `json:"compress,omitempty" yaml:"compress,omitempty"`
+}
+
+// FileSink is an io.Writer appending to a file, rotating it by size, by time
+// or on demand. Rotated files are renamed adding the rotation time to their
+// name, e.g. app-2024-01-02T15-04-05.000.log; compression and retention run
+// in background, so they never block the writes.
+//
+// A FileSink is safe for concurrent use.
+type FileSink struct {
+	options FileSinkOptions
+	now     func() time.Time
+
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+	closed       bool
+
+	millMu sync.Mutex
+	mill   sync.WaitGroup
+}
+
+// NewFileSink opens, or creates, the file at options.Path.
+func NewFileSink(options FileSinkOptions) (*FileSink, error) {
+	return newFileSink(options, time.Now)
+}
+
+func newFileSink(options FileSinkOptions, now func() time.Time) (*FileSink, error) {
+	if options.Path == "" {
+		return nil, errors.New("file sink path is required")
+	}
+	if options.MaxSize < 0 || options.RotationInterval < 0 || options.MaxBackups < 0 || options.MaxAge < 0 {
+		return nil, errors.New("file sink options must not be negative")
+	}
+
+	sink := &FileSink{options: options, now: now}
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Write appends p to the file, rotating it first if it is due. Empty writes
+// are ignored.
+func (s *FileSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if s.file == nil {
+		// a previous rotation could not reopen the file
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	if s.rotationDue(len(p)) {
+		if err := s.rotate(); err != nil {
+			reportSinkError(fmt.Errorf("failed to rotate %s: %w", s.options.Path, err))
+			if s.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately, e.g. when an external tool has moved
+// it away.
+func (s *FileSink) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+	return s.rotate()
+}
+
+// RotateOnSignal rotates the file each time one of the signals is received,
+// e.g. syscall.SIGHUP. The returned function stops listening.
+func (s *FileSink) RotateOnSignal(signals ...os.Signal) (stop func()) {
+	received := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(received, signals...)
+
+	go func() {
+		for {
+			select {
+			case <-received:
+				if err := s.Rotate(); err != nil && !errors.Is(err, os.ErrClosed) {
+					reportSinkError(fmt.Errorf("failed to rotate %s: %w", s.options.Path, err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(received)
+			close(done)
+		})
+	}
+}
+
+// Close closes the file, waiting for the pending compressions.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+	}
+	s.mu.Unlock()
+
+	s.mill.Wait()
+	return err
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.options.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileSinkMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	if interval := time.Duration(s.options.RotationInterval); interval > 0 {
+		// an existing file is rotated at the end of the interval it was
+		// last written in
+		start := s.now()
+		if s.size > 0 && info.ModTime().Before(start) {
+			start = info.ModTime()
+		}
+		s.nextRotation = start.Truncate(interval).Add(interval)
+	}
+	return nil
+}
+
+func (s *FileSink) rotationDue(writeSize int) bool {
+	if s.options.MaxSize > 0 && s.size > 0 && s.size+int64(writeSize) > s.options.MaxSize {
+		return true
+	}
+	return s.options.RotationInterval > 0 && !s.now().Before(s.nextRotation)
+}
+
+// rotate renames the file and opens a new one. If the new file cannot be
+// opened, the file is left nil and opened again by the next Write.
+func (s *FileSink) rotate() error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			reportSinkError(fmt.Errorf("failed to close %s: %w", s.options.Path, err))
+		}
+		s.file = nil
+	}
+
+	backup := s.backupName(s.now())
+	renameErr := os.Rename(s.options.Path, backup)
+	if errors.Is(renameErr, fs.ErrNotExist) {
+		// the file has been moved away by someone else
+		renameErr = nil
+	}
+	// the file is reopened even if the rename failed, to keep on writing
+	if err := s.open(); err != nil {
+		return errors.Join(renameErr, err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	s.mill.Add(1)
+	go s.runMill()
+	return nil
+}
+
+// backupName returns a name for the rotated file not used by other backups.
+func (s *FileSink) backupName(t time.Time) string {
+	dir, prefix, ext := s.nameParts()
+	t = t.UTC()
+	for {
+		name := filepath.Join(dir, prefix+t.Format(backupTimeFormat)+ext)
+		if !fileExists(name) && !fileExists(name+compressSuffix) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func (s *FileSink) nameParts() (dir, prefix, ext string) {
+	base := filepath.Base(s.options.Path)
+	ext = filepath.Ext(base)
+	return filepath.Dir(s.options.Path), strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// runMill compresses the rotated files and removes the ones exceeding the
+// retention. Runs are serialized, since they work on the same files.
+func (s *FileSink) runMill() {
+	defer s.mill.Done()
+	s.millMu.Lock()
+	defer s.millMu.Unlock()
+
+	backups, err := s.listBackups()
+	if err != nil {
+		reportSinkError(err)
+		return
+	}
+
+	cutoff := time.Time{}
+	if s.options.MaxAge > 0 {
+		cutoff = s.now().Add(-time.Duration(s.options.MaxAge))
+	}
+	for i, b := range backups {
+		expired := (s.options.MaxBackups > 0 && i >= s.options.MaxBackups) || b.time.Before(cutoff)
+		switch {
+		case expired:
+			if err := os.Remove(b.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				reportSinkError(fmt.Errorf("failed to remove %s: %w", b.path, err))
+			}
+		case s.options.Compress && !strings.HasSuffix(b.path, compressSuffix):
+			if err := compressFile(b.path); err != nil {
+				reportSinkError(fmt.Errorf("failed to compress %s: %w", b.path, err))
+			}
+		}
+	}
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// listBackups returns the rotated files, newest first.
+func (s *FileSink) listBackups() ([]backupFile, error) {
+	dir, prefix, ext := s.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rotated files: %w", err)
+	}
+
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), compressSuffix), ext)
+		t, err := time.Parse(backupTimeFormat, timestamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, name), time: t})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstPath := path + compressSuffix
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	writer := gzip.NewWriter(dst)
+	if _, err := io.Copy(writer, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// reportSinkError writes the errors that cannot be returned to the caller to
+// stderr, as logrus does when it fails to write an entry.
+func reportSinkError(err error) {
+	fmt.Fprintf(os.Stderr, "glogger: %v\n", err)
+}
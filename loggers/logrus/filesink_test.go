@@ -0,0 +1,298 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	start := time.Date(2024, 1, 2, 10, 0, 30, 0, time.UTC)
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewFileSink(FileSinkOptions{})
+		require.EqualError(t, err, "file sink path is required")
+
+		_, err = NewFileSink(FileSinkOptions{Path: filepath.Join(t.TempDir(), "app.log"), MaxBackups: -1})
+		require.EqualError(t, err, "file sink options must not be negative")
+	})
+
+	t.Run("appends to the file, creating its directory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "logs", "app.log")
+		sink, err := NewFileSink(FileSinkOptions{Path: path})
+		require.NoError(t, err)
+		writeLines(t, sink, "first")
+		require.NoError(t, sink.Close())
+
+		sink, err = NewFileSink(FileSinkOptions{Path: path})
+		require.NoError(t, err)
+		writeLines(t, sink, "second")
+		n, err := sink.Write(nil)
+		require.NoError(t, err)
+		require.Zero(t, n)
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, "first\nsecond\n", readFile(t, path))
+		require.Equal(t, []string{"app.log"}, listDir(t, filepath.Dir(path)))
+	})
+
+	t.Run("rotates by size", func(t *testing.T) {
+		dir := t.TempDir()
+		sink, err := newFileSink(FileSinkOptions{Path: filepath.Join(dir, "app.log"), MaxSize: 12}, fixedNow(start))
+		require.NoError(t, err)
+		writeLines(t, sink, "one", "two", "three", "a line longer than the max size", "four")
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, []string{
+			"app-2024-01-02T10-00-30.000.log",
+			"app-2024-01-02T10-00-30.001.log",
+			"app-2024-01-02T10-00-30.002.log",
+			"app.log",
+		}, listDir(t, dir))
+		require.Equal(t, "one\ntwo\n", readFile(t, filepath.Join(dir, "app-2024-01-02T10-00-30.000.log")))
+		require.Equal(t, "three\n", readFile(t, filepath.Join(dir, "app-2024-01-02T10-00-30.001.log")))
+		require.Equal(t, "a line longer than the max size\n", readFile(t, filepath.Join(dir, "app-2024-01-02T10-00-30.002.log")))
+		require.Equal(t, "four\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("rotates by time", func(t *testing.T) {
+		dir := t.TempDir()
+		now := start
+		sink, err := newFileSink(FileSinkOptions{
+			Path:             filepath.Join(dir, "app.log"),
+			RotationInterval: Duration(time.Hour),
+		}, func() time.Time { return now })
+		require.NoError(t, err)
+
+		writeLines(t, sink, "one")
+		now = start.Add(59 * time.Minute)
+		writeLines(t, sink, "two")
+		now = time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC)
+		writeLines(t, sink, "three")
+		now = now.Add(time.Hour)
+		writeLines(t, sink, "four")
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, []string{
+			"app-2024-01-02T11-00-00.000.log",
+			"app-2024-01-02T12-00-00.000.log",
+			"app.log",
+		}, listDir(t, dir))
+		require.Equal(t, "one\ntwo\n", readFile(t, filepath.Join(dir, "app-2024-01-02T11-00-00.000.log")))
+		require.Equal(t, "four\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("rotates an existing file written in a past interval", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+		require.NoError(t, os.Chtimes(path, start.Add(-24*time.Hour), start.Add(-24*time.Hour)))
+
+		sink, err := newFileSink(FileSinkOptions{Path: path, RotationInterval: Duration(time.Hour)}, fixedNow(start))
+		require.NoError(t, err)
+		writeLines(t, sink, "new")
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, []string{"app-2024-01-02T10-00-30.000.log", "app.log"}, listDir(t, dir))
+		require.Equal(t, "new\n", readFile(t, path))
+	})
+
+	t.Run("rotates on demand and on signal", func(t *testing.T) {
+		dir := t.TempDir()
+		sink, err := NewFileSink(FileSinkOptions{Path: filepath.Join(dir, "app.log")})
+		require.NoError(t, err)
+
+		writeLines(t, sink, "one")
+		require.NoError(t, sink.Rotate())
+		writeLines(t, sink, "two")
+		require.Len(t, listDir(t, dir), 2)
+
+		if runtime.GOOS != "windows" {
+			stop := sink.RotateOnSignal(syscall.SIGHUP)
+			defer stop()
+			require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+			require.Eventually(t, func() bool {
+				return len(listDir(t, dir)) == 3
+			}, time.Second, 10*time.Millisecond)
+			stop()
+		}
+
+		require.NoError(t, sink.Close())
+		require.ErrorIs(t, sink.Rotate(), os.ErrClosed)
+		_, err = sink.Write([]byte("closed\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+		require.NoError(t, sink.Close(), "close is idempotent")
+	})
+
+	t.Run("reopens the file after a failed rotation", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "logs")
+		sink, err := NewFileSink(FileSinkOptions{Path: filepath.Join(dir, "app.log")})
+		require.NoError(t, err)
+		writeLines(t, sink, "one")
+
+		require.NoError(t, os.RemoveAll(dir))
+		require.ErrorContains(t, sink.Rotate(), "failed to open log file")
+		_, err = sink.Write([]byte("lost\n"))
+		require.ErrorContains(t, err, "failed to open log file")
+
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		writeLines(t, sink, "two")
+		require.NoError(t, sink.Close())
+		require.Equal(t, "two\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("compresses rotated files", func(t *testing.T) {
+		dir := t.TempDir()
+		sink, err := newFileSink(FileSinkOptions{Path: filepath.Join(dir, "app.log"), Compress: true}, fixedNow(start))
+		require.NoError(t, err)
+		writeLines(t, sink, "one", "two")
+		require.NoError(t, sink.Rotate())
+		writeLines(t, sink, "three")
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, []string{"app-2024-01-02T10-00-30.000.log.gz", "app.log"}, listDir(t, dir))
+		file, err := os.Open(filepath.Join(dir, "app-2024-01-02T10-00-30.000.log.gz"))
+		require.NoError(t, err)
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, "one\ntwo\n", string(content))
+	})
+
+	t.Run("removes the backups exceeding the retention", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{
+			"app-2023-12-01T00-00-00.000.log.gz",
+			"app-2024-01-01T00-00-00.000.log",
+			"app-other.log",
+			"other-2023-12-01T00-00-00.000.log",
+		} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("old\n"), 0o600))
+		}
+
+		sink, err := newFileSink(FileSinkOptions{
+			Path:       filepath.Join(dir, "app.log"),
+			MaxBackups: 2,
+			MaxAge:     Duration(7 * 24 * time.Hour),
+		}, fixedNow(start))
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			writeLines(t, sink, fmt.Sprint(i))
+			require.NoError(t, sink.Rotate())
+		}
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, []string{
+			"app-2024-01-02T10-00-30.001.log",
+			"app-2024-01-02T10-00-30.002.log",
+			"app-other.log",
+			"app.log",
+			"other-2023-12-01T00-00-00.000.log",
+		}, listDir(t, dir))
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		dir := t.TempDir()
+		sink, err := NewFileSink(FileSinkOptions{Path: filepath.Join(dir, "app.log"), MaxSize: 1024})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_, err := fmt.Fprintf(sink, "writer %d line %d\n", i, j)
+					require.NoError(t, err)
+					if j%50 == 0 {
+						require.NoError(t, sink.Rotate())
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, sink.Close())
+
+		var lines []string
+		for _, name := range listDir(t, dir) {
+			content := readFile(t, filepath.Join(dir, name))
+			require.LessOrEqual(t, len(content), 1024)
+			lines = append(lines, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+		}
+		require.Len(t, lines, 1000)
+		for _, line := range lines {
+			require.Regexp(t, `^writer \d line \d+$`, line)
+		}
+	})
+
+	t.Run("InitHelper writes to the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		logger, err := InitHelper(InitOptions{File: &FileSinkOptions{Path: path}})
+		require.NoError(t, err)
+
+		logger.Info("hello")
+		require.NoError(t, Shutdown(context.Background(), logger))
+		require.Contains(t, readFile(t, path), `"msg":"hello"`)
+	})
+}
+
+func fixedNow(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func writeLines(t *testing.T, w io.Writer, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		_, err := w.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
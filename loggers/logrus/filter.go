@@ -0,0 +1,178 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultComponentKey is the field identifying the component that wrote an
+// entry, used when ComponentLevels.Key is empty.
+const DefaultComponentKey = "component"
+
+// EntryFilter decides whether an entry has to be written.
+type EntryFilter interface {
+	Allow(entry *logrus.Entry) bool
+}
+
+// FilterFormatter wraps a formatter, dropping the entries not allowed by all
+// its filters. Dropped entries are formatted to no bytes at all, so logrus
+// writes nothing for them.
+type FilterFormatter struct {
+	Formatter logrus.Formatter
+	Filters   []EntryFilter
+}
+
+// Format formats the entry with the wrapped formatter, if it is allowed.
+func (f *FilterFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if !allowed(f.Filters, entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
+
+// ComponentLevels filters the entries with a level for each component. The
+// component is read from the Key field of the entry, and entries of
+// components without a configured level are filtered with Default.
+//
+// Since logrus drops the entries above its level before formatting them, the
+// logger level must be the most verbose of all: see MostVerbose.
+type ComponentLevels struct {
+	Key     string
+	Default logrus.Level
+	Levels  map[string]logrus.Level
+}
+
+// NewComponentLevels parses the level of each component.
+func NewComponentLevels(key string, defaultLevel logrus.Level, levels map[string]string) (*ComponentLevels, error) {
+	if key == "" {
+		key = DefaultComponentKey
+	}
+	componentLevels := &ComponentLevels{
+		Key:     key,
+		Default: defaultLevel,
+		Levels:  make(map[string]logrus.Level, len(levels)),
+	}
+	for component, level := range levels {
+		parsed, err := logrus.ParseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("component %q: %w", component, err)
+		}
+		componentLevels.Levels[component] = parsed
+	}
+	return componentLevels, nil
+}
+
+// Allow reports whether the entry level is enabled for its component.
+func (c *ComponentLevels) Allow(entry *logrus.Entry) bool {
+	level := c.Default
+	if component, ok := entry.Data[c.Key].(string); ok {
+		if componentLevel, ok := c.Levels[component]; ok {
+			level = componentLevel
+		}
+	}
+	return entry.Level <= level
+}
+
+// MostVerbose returns the most verbose level among the default and the
+// component ones.
+func (c *ComponentLevels) MostVerbose() logrus.Level {
+	level := c.Default
+	for _, componentLevel := range c.Levels {
+		if componentLevel > level {
+			level = componentLevel
+		}
+	}
+	return level
+}
+
+// SamplingOptions configures a Sampler.
+type SamplingOptions struct {
+	// Initial is the number of entries with the same level and message
+	// written in each tick.
+	Initial int `json:"initial,omitempty" yaml:"initial,omitempty"`
+	// Thereafter is the sampling rate after the first Initial entries:
+	// one entry every Thereafter is written. Zero drops them all, unless
+	// Initial is zero too: then sampling is disabled.
+	Thereafter int `json:"thereafter,omitempty" yaml:"thereafter,omitempty"`
+	// Tick is the interval after which the counters are reset. Defaults
+	// to one second.
+	Tick Duration `json:"tick,omitempty" yaml:"tick,omitempty"`
+}
+
+// Sampler limits the entries with the same level and message written in
+// each tick, to cap the cost of hot log statements. Entries at error level
+// and above are never sampled.
+type Sampler struct {
+	initial    int
+	thereafter int
+	tick       time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	tickStart time.Time
+	counts    map[samplingKey]int
+}
+
+type samplingKey struct {
+	level   logrus.Level
+	message string
+}
+
+// NewSampler returns a Sampler with the given options.
+func NewSampler(options SamplingOptions) *Sampler {
+	tick := time.Duration(options.Tick)
+	if tick <= 0 {
+		tick = time.Second
+	}
+	return &Sampler{
+		initial:    options.Initial,
+		thereafter: options.Thereafter,
+		tick:       tick,
+		now:        time.Now,
+		counts:     map[samplingKey]int{},
+	}
+}
+
+// Allow reports whether the entry is sampled.
+func (s *Sampler) Allow(entry *logrus.Entry) bool {
+	if entry.Level <= logrus.ErrorLevel || (s.initial == 0 && s.thereafter == 0) {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.now(); now.Sub(s.tickStart) >= s.tick {
+		s.tickStart = now
+		for key := range s.counts {
+			delete(s.counts, key)
+		}
+	}
+
+	key := samplingKey{level: entry.Level, message: entry.Message}
+	s.counts[key]++
+	count := s.counts[key]
+	if count <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (count-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentLevels(t *testing.T) {
+	t.Run("rejects invalid levels", func(t *testing.T) {
+		componentLevels, err := NewComponentLevels("", logrus.InfoLevel, map[string]string{"db": "verbose"})
+		require.Nil(t, componentLevels)
+		require.ErrorContains(t, err, `component "db"`)
+	})
+
+	t.Run("filters entries by component", func(t *testing.T) {
+		componentLevels, err := NewComponentLevels("", logrus.InfoLevel, map[string]string{"db": "debug", "http": "warn"})
+		require.NoError(t, err)
+		require.Equal(t, DefaultComponentKey, componentLevels.Key)
+		require.Equal(t, logrus.DebugLevel, componentLevels.MostVerbose())
+
+		entry := func(level logrus.Level, component any) *logrus.Entry {
+			return &logrus.Entry{Level: level, Data: logrus.Fields{DefaultComponentKey: component}}
+		}
+		require.True(t, componentLevels.Allow(entry(logrus.DebugLevel, "db")))
+		require.False(t, componentLevels.Allow(entry(logrus.TraceLevel, "db")))
+		require.False(t, componentLevels.Allow(entry(logrus.InfoLevel, "http")))
+		require.True(t, componentLevels.Allow(entry(logrus.WarnLevel, "http")))
+		require.True(t, componentLevels.Allow(entry(logrus.InfoLevel, "other")))
+		require.False(t, componentLevels.Allow(entry(logrus.DebugLevel, "other")))
+		require.False(t, componentLevels.Allow(entry(logrus.DebugLevel, 42)))
+	})
+}
+
+func TestSampler(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sampler := NewSampler(SamplingOptions{Initial: 2, Thereafter: 3})
+	sampler.now = func() time.Time { return now }
+
+	allowed := func(level logrus.Level, message string, count int) int {
+		var result int
+		for i := 0; i < count; i++ {
+			if sampler.Allow(&logrus.Entry{Level: level, Message: message}) {
+				result++
+			}
+		}
+		return result
+	}
+
+	require.Equal(t, 4, allowed(logrus.InfoLevel, "hot", 8), "2 initial entries, then 1 every 3")
+	require.Equal(t, 2, allowed(logrus.InfoLevel, "other", 2), "messages are counted separately")
+	require.Equal(t, 2, allowed(logrus.DebugLevel, "hot", 2), "levels are counted separately")
+	require.Equal(t, 10, allowed(logrus.ErrorLevel, "hot", 10), "errors are never sampled")
+
+	now = now.Add(time.Second)
+	require.Equal(t, 2, allowed(logrus.InfoLevel, "hot", 3), "counters are reset each tick")
+
+	t.Run("zero thereafter drops all the entries after the initial ones", func(t *testing.T) {
+		sampler := NewSampler(SamplingOptions{Initial: 1})
+		require.True(t, sampler.Allow(&logrus.Entry{Level: logrus.InfoLevel, Message: "msg"}))
+		require.False(t, sampler.Allow(&logrus.Entry{Level: logrus.InfoLevel, Message: "msg"}))
+	})
+
+	t.Run("zero options disable sampling", func(t *testing.T) {
+		sampler := NewSampler(SamplingOptions{Tick: Duration(time.Minute)})
+		for i := 0; i < 10; i++ {
+			require.True(t, sampler.Allow(&logrus.Entry{Level: logrus.InfoLevel, Message: "msg"}))
+		}
+	})
+}
+
+func TestFilterFormatter(t *testing.T) {
+	componentLevels, err := NewComponentLevels("module", logrus.WarnLevel, map[string]string{"db": "debug"})
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buffer
+	logger.SetLevel(componentLevels.MostVerbose())
+	logger.SetFormatter(&FilterFormatter{Formatter: &JSONFormatter{}, Filters: []EntryFilter{componentLevels}})
+
+	logger.WithField("module", "db").Debug("query")
+	logger.WithField("module", "http").Info("request")
+	logger.Warn("warning")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"msg":"query"`)
+	require.Contains(t, lines[1], `"msg":"warning"`)
+}
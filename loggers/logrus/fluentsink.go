@@ -0,0 +1,157 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FluentSinkOptions configures a FluentSink.
+type FluentSinkOptions struct {
+	// Network is tcp (the default) or unix.
+	Network string
+	// Address defaults to 127.0.0.1:24224.
+	Address string
+	// Tag is the tag of the events, used by Fluent Bit and Fluentd to route
+	// them. Defaults to the name of the executable.
+	Tag string
+	// RequireAck waits for the server to acknowledge each batch, resending
+	// it otherwise: no entry is lost, but some may be duplicated.
+	RequireAck bool
+	// Timeout limits the connection, the writes and the wait for the ack.
+	// Defaults to 10s.
+	Timeout time.Duration
+	Batch   BatchOptions
+	Retry   RetryOptions
+}
+
+// FluentSink is an io.Writer sending the entries to Fluent Bit or Fluentd
+// with the Fluent Forward protocol. Each entry becomes an event with the
+// fields written by JSONFormatter and the entry time as EventTime.
+//
+// Entries are sent in batches from a background goroutine. When the server
+// is not reachable, the connection is opened again with backoff while the
+// entries are buffered, up to Batch.MaxQueueSize.
+type FluentSink struct {
+	*batchSink
+
+	conn       sinkConn
+	tag        string
+	requireAck bool
+	timeout    time.Duration
+	retry      RetryOptions
+}
+
+// NewFluentSink returns a FluentSink. The connection is opened when the
+// first batch is sent.
+func NewFluentSink(options FluentSinkOptions) (*FluentSink, error) {
+	network, address := options.Network, options.Address
+	switch network {
+	case "":
+		network = "tcp"
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, fmt.Errorf("unsupported fluent network %q", network)
+	}
+	if address == "" {
+		if network == "unix" {
+			return nil, errors.New("fluent socket address is required")
+		}
+		address = "127.0.0.1:24224"
+	}
+	tag := options.Tag
+	if tag == "" {
+		tag = programName()
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	sink := &FluentSink{
+		conn:       sinkConn{network: network, address: address, timeout: timeout},
+		tag:        tag,
+		requireAck: options.RequireAck,
+		timeout:    timeout,
+		retry:      options.Retry,
+	}
+	sink.batchSink = newBatchSink(options.Batch, sink.export)
+	return sink, nil
+}
+
+// Shutdown sends the queued entries and closes the connection.
+func (s *FluentSink) Shutdown(ctx context.Context) error {
+	err := s.batchSink.Shutdown(ctx)
+	s.conn.close()
+	return err
+}
+
+// Close is Shutdown without a deadline.
+func (s *FluentSink) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// export sends the lines as a Forward mode message:
+// [tag, [[time, record], ...], {"size": n, "chunk": id}]
+func (s *FluentSink) export(ctx context.Context, lines [][]byte) error {
+	entries := make([]any, 0, len(lines))
+	for _, line := range lines {
+		record := decodeRecord(line)
+		entries = append(entries, []any{record.time, record.entryFields()})
+	}
+	option := map[string]any{"size": len(entries)}
+	var chunk string
+	if s.requireAck {
+		chunk = newChunkID()
+		option["chunk"] = chunk
+	}
+	message := appendMsgpack(nil, []any{s.tag, entries, option})
+
+	err := withRetry(ctx, s.retry, func(ctx context.Context) error {
+		if err := s.conn.write(ctx, message); err != nil {
+			return err
+		}
+		if !s.requireAck {
+			return nil
+		}
+
+		s.conn.conn.SetReadDeadline(time.Now().Add(s.timeout))
+		response, err := decodeMsgpack(s.conn.reader)
+		if err != nil {
+			return s.conn.fail(fmt.Errorf("failed to read ack: %w", err))
+		}
+		if ack, ok := response.(map[string]any); !ok || ack["ack"] != chunk {
+			return s.conn.fail(fmt.Errorf("unexpected ack %v", response))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send %d entries to fluent: %w", len(lines), err)
+	}
+	return nil
+}
+
+func newChunkID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return base64.StdEncoding.EncodeToString(id[:])
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fluentServer is a stand-in for Fluent Bit, decoding the Forward messages.
+type fluentServer struct {
+	listener net.Listener
+	ack      bool
+	// skipAcks is the number of messages not acknowledged.
+	skipAcks int
+
+	mu       sync.Mutex
+	messages [][]any
+}
+
+func newFluentServer(t *testing.T, network, address string, ack bool) *fluentServer {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	require.NoError(t, err)
+	server := &fluentServer{listener: listener, ack: ack}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fluentServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		value, err := decodeMsgpack(reader)
+		if err != nil {
+			return
+		}
+		message := value.([]any)
+
+		s.mu.Lock()
+		skip := s.skipAcks > 0
+		if skip {
+			s.skipAcks--
+		} else {
+			s.messages = append(s.messages, message)
+		}
+		s.mu.Unlock()
+
+		if s.ack && !skip {
+			chunk := message[2].(map[string]any)["chunk"]
+			conn.Write(appendMsgpack(nil, map[string]any{"ack": chunk}))
+		}
+	}
+}
+
+func (s *fluentServer) received() [][]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]any(nil), s.messages...)
+}
+
+func TestFluentSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	fastRetry := RetryOptions{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond, MaxElapsedTime: 5 * time.Second}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewFluentSink(FluentSinkOptions{Network: "udp"})
+		require.EqualError(t, err, `unsupported fluent network "udp"`)
+
+		_, err = NewFluentSink(FluentSinkOptions{Network: "unix"})
+		require.EqualError(t, err, "fluent socket address is required")
+	})
+
+	t.Run("sends forward mode messages", func(t *testing.T) {
+		server := newFluentServer(t, "tcp", "127.0.0.1:0", false)
+		sink, err := NewFluentSink(FluentSinkOptions{Address: server.listener.Addr().String(), Tag: "app.logs"})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).WithFields(logrus.Fields{
+			"reqId": "abc",
+			"http":  map[string]any{"status": 200},
+		}).Warn("first")
+		logger.WithTime(entryTime).Info("second")
+		require.NoError(t, sink.Close())
+
+		require.Eventually(t, func() bool { return len(server.received()) == 1 }, time.Second, 10*time.Millisecond)
+		messages := server.received()
+		require.Equal(t, "app.logs", messages[0][0])
+		require.Equal(t, map[string]any{"size": int64(2)}, messages[0][2])
+
+		entries := messages[0][1].([]any)
+		require.Len(t, entries, 2)
+		require.Equal(t, []any{
+			msgpackExt{Type: msgpackEventTime, Data: []byte{0x65, 0x93, 0xde, 0xbe, 0x07, 0x54, 0xd4, 0xc0}},
+			map[string]any{
+				"level": int64(40),
+				"msg":   "first",
+				"time":  int64(1704189630123),
+				"reqId": "abc",
+				"http":  map[string]any{"status": int64(200)},
+			},
+		}, entries[0])
+		require.Equal(t, "second", entries[1].([]any)[1].(map[string]any)["msg"])
+	})
+
+	t.Run("waits for the ack and resends the unacknowledged chunks", func(t *testing.T) {
+		server := newFluentServer(t, "unix", shortTempDir(t)+"/fluent.sock", true)
+		server.skipAcks = 1
+		sink, err := NewFluentSink(FluentSinkOptions{
+			Network:    "unix",
+			Address:    server.listener.Addr().String(),
+			RequireAck: true,
+			Timeout:    100 * time.Millisecond,
+			Retry:      fastRetry,
+		})
+		require.NoError(t, err)
+
+		newSinkLogger(sink).Info("hello")
+		require.NoError(t, sink.Flush(context.Background()))
+
+		messages := server.received()
+		require.Len(t, messages, 1)
+		option := messages[0][2].(map[string]any)
+		require.NotEmpty(t, option["chunk"])
+		require.NoError(t, sink.Close())
+		require.Equal(t, BatchStats{Exported: 1}, sink.Stats())
+	})
+
+	t.Run("buffers during outages and reconnects", func(t *testing.T) {
+		server := newFluentServer(t, "tcp", "127.0.0.1:0", true)
+		address := server.listener.Addr().String()
+		sink, err := NewFluentSink(FluentSinkOptions{Address: address, RequireAck: true, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.Info("before")
+		require.NoError(t, sink.Flush(context.Background()))
+
+		// the server restarts, closing the connections
+		server.listener.Close()
+		sink.conn.conn.Close()
+		logger.Info("during")
+		time.Sleep(50 * time.Millisecond)
+		restarted := newFluentServer(t, "tcp", address, true)
+
+		require.NoError(t, sink.Flush(context.Background()))
+		require.Len(t, server.received(), 1)
+		messages := restarted.received()
+		require.Len(t, messages, 1)
+		require.Equal(t, "during", messages[0][1].([]any)[0].([]any)[1].(map[string]any)["msg"])
+	})
+}
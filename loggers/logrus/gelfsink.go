@@ -0,0 +1,267 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGELFChunkSize is the maximum size of the UDP datagrams, suited
+	// for networks with the usual 1500 bytes MTU.
+	DefaultGELFChunkSize = 1420
+
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// GELFSinkOptions configures a GELFSink.
+type GELFSinkOptions struct {
+	// Network is udp (the default) or tcp.
+	Network string
+	// Address defaults to 127.0.0.1:12201.
+	Address string
+	// Host is the host field of the messages. Defaults to the host name
+	// reported by the kernel.
+	Host string
+	// Compress gzips the UDP messages.
+	Compress bool
+	// ChunkSize is the maximum size of the UDP datagrams: larger messages
+	// are chunked. Defaults to DefaultGELFChunkSize.
+	ChunkSize int
+	// Timeout limits the connection and the writes. Defaults to 10s.
+	Timeout time.Duration
+	Batch   BatchOptions
+	Retry   RetryOptions
+}
+
+// GELFSink is an io.Writer sending the entries to Graylog as GELF 1.1
+// messages, over UDP (chunked when needed) or TCP. The message is sent as
+// short_message and the level is mapped to the syslog severity; the other
+// fields written by JSONFormatter become additional fields, with nested
+// objects flattened joining the keys with an underscore, e.g. _http_request_method.
+//
+// Entries are sent in batches from a background goroutine. When the server
+// is not reachable, the connection is opened again with backoff while the
+// entries are buffered, up to Batch.MaxQueueSize.
+type GELFSink struct {
+	*batchSink
+
+	conn      sinkConn
+	udp       bool
+	host      string
+	compress  bool
+	chunkSize int
+	retry     RetryOptions
+}
+
+// NewGELFSink returns a GELFSink. The connection is opened when the first
+// batch is sent.
+func NewGELFSink(options GELFSinkOptions) (*GELFSink, error) {
+	network := options.Network
+	switch network {
+	case "":
+		network = "udp"
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported GELF network %q", network)
+	}
+	address := options.Address
+	if address == "" {
+		address = "127.0.0.1:12201"
+	}
+	host := options.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultGELFChunkSize
+	}
+	if chunkSize <= gelfChunkHeaderSize {
+		return nil, fmt.Errorf("GELF chunk size must be greater than %d", gelfChunkHeaderSize)
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	sink := &GELFSink{
+		conn:      sinkConn{network: network, address: address, timeout: timeout},
+		udp:       strings.HasPrefix(network, "udp"),
+		host:      host,
+		compress:  options.Compress,
+		chunkSize: chunkSize,
+		retry:     options.Retry,
+	}
+	sink.batchSink = newBatchSink(options.Batch, sink.export)
+	return sink, nil
+}
+
+// Shutdown sends the queued entries and closes the connection.
+func (s *GELFSink) Shutdown(ctx context.Context) error {
+	err := s.batchSink.Shutdown(ctx)
+	s.conn.close()
+	return err
+}
+
+// Close is Shutdown without a deadline.
+func (s *GELFSink) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+func (s *GELFSink) export(ctx context.Context, lines [][]byte) error {
+	var packets [][]byte
+	var errs []error
+	for _, line := range lines {
+		message, err := s.encode(decodeRecord(line))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		packets = append(packets, message...)
+	}
+
+	// a retry resumes from the first packet not sent
+	var sent int
+	err := withRetry(ctx, s.retry, func(ctx context.Context) error {
+		for ; sent < len(packets); sent++ {
+			if err := s.conn.write(ctx, packets[sent]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send entries to GELF server: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// encode returns the packets of a message: the null terminated JSON over
+// TCP, the JSON, optionally compressed and chunked, over UDP.
+func (s *GELFSink) encode(record sinkRecord) ([][]byte, error) {
+	message := map[string]any{
+		"version":       "1.1",
+		"host":          s.host,
+		"short_message": record.message,
+		"timestamp":     json.Number(fmt.Sprintf("%d.%03d", record.time.Unix(), record.time.Nanosecond()/int(time.Millisecond))),
+		"level":         syslogSeverity(record.level),
+	}
+	if record.message == "" {
+		// short_message is required to be not empty
+		message["short_message"] = "-"
+	}
+	for _, key := range record.sortedKeys() {
+		name := "_" + gelfFieldName(key)
+		if name == "_id" {
+			// _id is reserved
+			name = "_id_"
+		}
+		addGELFField(message, name, record.fields[key])
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if !s.udp {
+		return [][]byte{append(encoded, 0)}, nil
+	}
+
+	if s.compress {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(encoded)
+		writer.Close()
+		encoded = compressed.Bytes()
+	}
+	if len(encoded) <= s.chunkSize {
+		return [][]byte{encoded}, nil
+	}
+	return gelfChunks(encoded, s.chunkSize)
+}
+
+// gelfChunks splits a message in chunks, each one with the header: magic
+// bytes 0x1e 0x0f, message id, sequence number and sequence count.
+func gelfChunks(message []byte, chunkSize int) ([][]byte, error) {
+	dataSize := chunkSize - gelfChunkHeaderSize
+	count := (len(message) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes exceeds %d chunks", len(message), gelfMaxChunks)
+	}
+
+	var id [8]byte
+	rand.Read(id[:])
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(message) {
+			end = len(message)
+		}
+		data := message[i*dataSize : end]
+		chunk := make([]byte, 0, gelfChunkHeaderSize+len(data))
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id[:]...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, data...))
+	}
+	return chunks, nil
+}
+
+// addGELFField adds a field, flattening the objects: GELF additional fields
+// can be strings or numbers only.
+func addGELFField(message map[string]any, name string, value any) {
+	switch value := value.(type) {
+	case nil:
+	case string, json.Number:
+		message[name] = value
+	case bool:
+		message[name] = strconv.FormatBool(value)
+	case map[string]any:
+		for k, v := range value {
+			addGELFField(message, name+"_"+gelfFieldName(k), v)
+		}
+	default:
+		message[name] = fieldString(value)
+	}
+}
+
+// gelfFieldName replaces the characters not allowed in field names.
+func gelfFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
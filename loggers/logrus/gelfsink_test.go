@@ -0,0 +1,179 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGELFSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+
+	decode := func(t *testing.T, message string) map[string]any {
+		t.Helper()
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal([]byte(message), &decoded))
+		return decoded
+	}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewGELFSink(GELFSinkOptions{Network: "unix"})
+		require.EqualError(t, err, `unsupported GELF network "unix"`)
+
+		_, err = NewGELFSink(GELFSinkOptions{ChunkSize: 12})
+		require.EqualError(t, err, "GELF chunk size must be greater than 12")
+	})
+
+	t.Run("sends UDP messages", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := NewGELFSink(GELFSinkOptions{Address: conn.LocalAddr().String(), Host: "host-1"})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).WithFields(logrus.Fields{
+			"id":      "abc",
+			"ok":      true,
+			"count":   3,
+			"user id": "u1",
+			"http":    map[string]any{"request": map[string]any{"method": "GET"}},
+		}).Error("failed")
+		logger.WithTime(entryTime).Info("")
+		require.NoError(t, sink.Flush(context.Background()))
+
+		require.Equal(t, map[string]any{
+			"version":              "1.1",
+			"host":                 "host-1",
+			"short_message":        "failed",
+			"timestamp":            1704189630.123,
+			"level":                float64(3),
+			"_id_":                 "abc",
+			"_ok":                  "true",
+			"_count":               float64(3),
+			"_user_id":             "u1",
+			"_http_request_method": "GET",
+		}, decode(t, readPacket(t, conn)))
+
+		message := decode(t, readPacket(t, conn))
+		require.Equal(t, "-", message["short_message"])
+		require.Equal(t, float64(6), message["level"])
+	})
+
+	t.Run("chunks and compresses large UDP messages", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := NewGELFSink(GELFSinkOptions{Address: conn.LocalAddr().String(), Compress: true, ChunkSize: 100})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		// random-looking text, so that it is still large once compressed
+		var long strings.Builder
+		for i := 0; long.Len() < 2000; i++ {
+			long.WriteString(time.Duration(i * 7919).String())
+		}
+		newSinkLogger(sink).Info(long.String())
+		require.NoError(t, sink.Flush(context.Background()))
+
+		var id string
+		var chunks [][]byte
+		for {
+			packet := []byte(readPacket(t, conn))
+			require.LessOrEqual(t, len(packet), 100)
+			require.Equal(t, []byte{0x1e, 0x0f}, packet[:2])
+			if id == "" {
+				id = string(packet[2:10])
+				chunks = make([][]byte, packet[11])
+			}
+			require.Equal(t, id, string(packet[2:10]))
+			chunks[packet[10]] = packet[12:]
+			if len(chunks) == int(packet[10])+1 {
+				break
+			}
+		}
+		require.Greater(t, len(chunks), 1)
+
+		reader, err := gzip.NewReader(bytes.NewReader(bytes.Join(chunks, nil)))
+		require.NoError(t, err)
+		message, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, long.String(), decode(t, string(message))["short_message"])
+	})
+
+	t.Run("fails messages exceeding the chunks", func(t *testing.T) {
+		_, err := gelfChunks(make([]byte, 129*10), 22)
+		require.EqualError(t, err, "GELF message of 1290 bytes exceeds 128 chunks")
+	})
+
+	t.Run("sends null terminated TCP messages and reconnects", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewGELFSink(GELFSinkOptions{
+			Network: "tcp",
+			Address: listener.Addr().String(),
+			Retry:   RetryOptions{InitialInterval: 10 * time.Millisecond, MaxElapsedTime: 5 * time.Second},
+		})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.Info("first")
+		require.NoError(t, sink.Flush(context.Background()))
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		reader := bufio.NewReader(conn)
+		message, err := reader.ReadString(0)
+		require.NoError(t, err)
+		require.Equal(t, "first", decode(t, strings.TrimSuffix(message, "\x00"))["short_message"])
+
+		// the server drops the connection: the entry is sent again on a new one
+		conn.Close()
+		time.Sleep(2 * idleCheckInterval)
+		logger.Info("second")
+		logger.Info("third")
+		go sink.Flush(context.Background())
+
+		require.NoError(t, listener.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+		conn, err = listener.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		reader = bufio.NewReader(conn)
+		for _, expected := range []string{"second", "third"} {
+			message, err := reader.ReadString(0)
+			require.NoError(t, err)
+			require.Equal(t, expected, decode(t, strings.TrimSuffix(message, "\x00"))["short_message"])
+		}
+	})
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handle is a logger created by Init, with the lifecycle of its sinks.
+// Entries buffered by the sinks are sent by Flush, and by Close before the
+// sinks are closed: call it before the application exits.
+//
+// The sinks are also closed when the logger exits after a fatal entry, and
+// flushed right after writing a panic entry, since the panic may terminate
+// the process.
+type Handle struct {
+	*logrus.Logger
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// openHandles are the handles writing to sinks not closed yet, closed when a
+// logger exits after a fatal entry. The exit handler is registered once,
+// since logrus never removes them.
+var (
+	openHandlesMu   sync.Mutex
+	openHandles     = map[*logrus.Logger]*Handle{}
+	exitHandlerOnce sync.Once
+)
+
+// Init configures a logger like InitHelper, returning its Handle.
+func Init(options InitOptions) (*Handle, error) {
+	logger, err := newLogger(options)
+	if err != nil {
+		return nil, err
+	}
+	return newHandle(logger), nil
+}
+
+func newHandle(logger *logrus.Logger) *Handle {
+	handle := &Handle{Logger: logger}
+	switch logger.Out.(type) {
+	case *panicFlusher, *reloader:
+		openHandlesMu.Lock()
+		openHandles[logger] = handle
+		openHandlesMu.Unlock()
+		exitHandlerOnce.Do(func() {
+			logrus.RegisterExitHandler(closeOpenHandles)
+		})
+	}
+	return handle
+}
+
+// closeOpenHandles closes the handles still open, waiting at most
+// ExitFlushTimeout.
+func closeOpenHandles() {
+	openHandlesMu.Lock()
+	handles := make([]*Handle, 0, len(openHandles))
+	for _, handle := range openHandles {
+		handles = append(handles, handle)
+	}
+	openHandlesMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExitFlushTimeout)
+	defer cancel()
+	for _, handle := range handles {
+		if err := handle.Close(ctx); err != nil {
+			reportSinkError(err)
+		}
+	}
+}
+
+// openHandle returns the open handle of logger, if any.
+func openHandle(logger *logrus.Logger) *Handle {
+	openHandlesMu.Lock()
+	defer openHandlesMu.Unlock()
+	return openHandles[logger]
+}
+
+// Flush sends the entries buffered by the sinks, waiting for them to be
+// delivered or for ctx to expire.
+func (h *Handle) Flush(ctx context.Context) error {
+	return flushWriters(ctx, outputWriters(h.Out))
+}
+
+// Close sends the entries buffered by the sinks and closes them. Entries
+// written after Close are lost. Calls after the first return its result.
+func (h *Handle) Close(ctx context.Context) error {
+	h.closeOnce.Do(func() {
+		openHandlesMu.Lock()
+		delete(openHandles, h.Logger)
+		openHandlesMu.Unlock()
+		h.closeErr = shutdownWriters(ctx, outputWriters(h.Out))
+	})
+	return h.closeErr
+}
+
+// CloseAfter calls shutdown, e.g. the Shutdown method of an http.Server or
+// the ShutdownWithContext method of a fiber.App, then closes the handle, so
+// that the entries of the requests served during the shutdown are sent.
+//
+//	if err := handle.CloseAfter(ctx, server.Shutdown); err != nil {
+//		...
+//	}
+func (h *Handle) CloseAfter(ctx context.Context, shutdown func(context.Context) error) error {
+	return errors.Join(shutdown(ctx), h.Close(ctx))
+}
+
+// panicFlusher is both the formatter and the output of a logger writing to
+// sinks: it flushes the sinks after writing a panic entry. logrus formats
+// and writes each entry holding the logger lock, so the entry formatted is
+// the one written next.
+type panicFlusher struct {
+	formatter logrus.Formatter
+	out       io.Writer
+	panicking bool
+}
+
+func (p *panicFlusher) Format(entry *logrus.Entry) ([]byte, error) {
+	p.panicking = entry.Level == logrus.PanicLevel
+	return p.formatter.Format(entry)
+}
+
+func (p *panicFlusher) Write(b []byte) (int, error) {
+	n, err := p.out.Write(b)
+	if p.panicking {
+		p.panicking = false
+		ctx, cancel := context.WithTimeout(context.Background(), ExitFlushTimeout)
+		defer cancel()
+		if flushErr := flushWriters(ctx, outputWriters(p.out)); flushErr != nil {
+			reportSinkError(flushErr)
+		}
+	}
+	return n, err
+}
+
+// outputWriters returns the writers a logger output is made of.
+func outputWriters(out io.Writer) []io.Writer {
+	switch out := out.(type) {
+	case *panicFlusher:
+		return outputWriters(out.out)
+	case multiSink:
+		return out
+	default:
+		return []io.Writer{out}
+	}
+}
+
+// flushWriters flushes the writers buffering the entries.
+func flushWriters(ctx context.Context, writers []io.Writer) error {
+	var errs []error
+	for _, w := range writers {
+		if flusher, ok := w.(interface{ Flush(context.Context) error }); ok {
+			errs = append(errs, flusher.Flush(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	// entries are sent only when flushed
+	slowBatch := BatchOptions{FlushInterval: time.Hour}
+	newHandle := func(t *testing.T, collector *collector) *Handle {
+		t.Helper()
+		handle, err := Init(InitOptions{
+			HTTP: &HTTPSinkOptions{URL: collector.URL, Encoder: JSONArrayEncoder{}, Batch: slowBatch},
+		})
+		require.NoError(t, err)
+		handle.ExitFunc = func(int) {}
+		return handle
+	}
+	receivedCount := func(collector *collector) int {
+		requests, _ := collector.received()
+		return len(requests)
+	}
+
+	t.Run("flushes and closes the sinks", func(t *testing.T) {
+		collector := newCollector(t)
+		handle := newHandle(t, collector)
+
+		handle.Info("first")
+		require.NoError(t, handle.Flush(context.Background()))
+		require.Equal(t, 1, receivedCount(collector))
+
+		handle.Info("second")
+		require.NoError(t, handle.Close(context.Background()))
+		require.NoError(t, handle.Close(context.Background()))
+		require.Equal(t, 2, receivedCount(collector))
+	})
+
+	t.Run("flushes after a panic entry", func(t *testing.T) {
+		collector := newCollector(t)
+		handle := newHandle(t, collector)
+		defer handle.Close(context.Background())
+
+		handle.Info("before")
+		require.Panics(t, func() { handle.Panic("unexpected") })
+
+		_, bodies := collector.received()
+		require.Len(t, bodies, 1)
+		require.Contains(t, string(bodies[0]), `"msg":"before"`)
+		require.Contains(t, string(bodies[0]), `"msg":"unexpected"`)
+	})
+
+	t.Run("closes the sinks on fatal entries", func(t *testing.T) {
+		collector := newCollector(t)
+		handle := newHandle(t, collector)
+
+		handle.Fatal("failed")
+		require.Equal(t, 1, receivedCount(collector))
+		_, err := handle.Out.Write([]byte("{}\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+	})
+
+	t.Run("closes after the server shutdown", func(t *testing.T) {
+		collector := newCollector(t)
+		handle := newHandle(t, collector)
+
+		err := handle.CloseAfter(context.Background(), func(ctx context.Context) error {
+			handle.Info("last request")
+			return errors.New("shutdown timeout")
+		})
+		require.EqualError(t, err, "shutdown timeout")
+		require.Equal(t, 1, receivedCount(collector))
+	})
+
+	t.Run("releases the closed handles", func(t *testing.T) {
+		collector := newCollector(t)
+		handles := []*Handle{newHandle(t, collector), newHandle(t, collector)}
+		for _, handle := range handles {
+			require.Same(t, handle, openHandle(handle.Logger))
+		}
+		for _, handle := range handles {
+			require.NoError(t, handle.Close(context.Background()))
+			require.Nil(t, openHandle(handle.Logger))
+		}
+	})
+
+	t.Run("flushes and shuts down the loggers of InitHelper", func(t *testing.T) {
+		collector := newCollector(t)
+		logger, err := InitHelper(InitOptions{
+			HTTP: &HTTPSinkOptions{URL: collector.URL, Encoder: JSONArrayEncoder{}, Batch: slowBatch},
+		})
+		require.NoError(t, err)
+
+		logger.Info("first")
+		require.NoError(t, Flush(context.Background(), logger))
+		require.Equal(t, 1, receivedCount(collector))
+
+		logger.Info("second")
+		require.NoError(t, Shutdown(context.Background(), logger))
+		require.Equal(t, 2, receivedCount(collector))
+		require.Nil(t, openHandle(logger))
+	})
+
+	t.Run("without sinks", func(t *testing.T) {
+		var buffer bytes.Buffer
+		handle, err := Init(InitOptions{Output: &buffer})
+		require.NoError(t, err)
+
+		handle.Info("hello")
+		require.NoError(t, handle.Flush(context.Background()))
+		require.NoError(t, handle.Close(context.Background()))
+		require.Contains(t, buffer.String(), `"msg":"hello"`)
+	})
+}
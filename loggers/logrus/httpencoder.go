@@ -0,0 +1,192 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ElasticsearchBulkEncoder encodes the batches for the Elasticsearch _bulk
+// API, creating a document for each entry. Send them to the /_bulk endpoint.
+type ElasticsearchBulkEncoder struct {
+	// Index is the index or data stream of the documents. If empty, it must
+	// be part of the URL, e.g. /my-index/_bulk.
+	Index string
+	// TimestampField, if set, adds the entry time in RFC 3339 format to the
+	// documents, e.g. @timestamp, required by the data streams.
+	TimestampField string
+}
+
+// ContentType implements HTTPEncoder.
+func (e ElasticsearchBulkEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Encode implements HTTPEncoder.
+func (e ElasticsearchBulkEncoder) Encode(lines [][]byte) ([]byte, error) {
+	action := []byte(`{"create":{}}`)
+	if e.Index != "" {
+		encoded, err := json.Marshal(map[string]any{"create": map[string]string{"_index": e.Index}})
+		if err != nil {
+			return nil, err
+		}
+		action = encoded
+	}
+
+	var body bytes.Buffer
+	for _, line := range lines {
+		record := decodeRecord(line)
+		document := recordJSON(record)
+		if e.TimestampField != "" {
+			fields := record.entryFields()
+			fields[e.TimestampField] = record.time.UTC().Format(time.RFC3339Nano)
+			document = encodeFields(fields)
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(document)
+		body.WriteByte('\n')
+	}
+	return body.Bytes(), nil
+}
+
+// CheckResponse implements HTTPResponseChecker: the bulk API responds 200
+// also when some documents are rejected. The documents rejected with 429 or
+// 5xx are retried, the others fail.
+func (e ElasticsearchBulkEncoder) CheckResponse(body []byte) error {
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !response.Errors {
+		return nil
+	}
+
+	rejected := &RejectedEntriesError{}
+	var first string
+	for index, item := range response.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			if first == "" {
+				first = fmt.Sprintf("%s: %s", result.Error.Type, result.Error.Reason)
+			}
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 {
+				rejected.Retryable = append(rejected.Retryable, index)
+			} else {
+				rejected.Failed = append(rejected.Failed, index)
+			}
+		}
+	}
+	rejected.Err = fmt.Errorf("%d of %d documents rejected, first error %s", len(rejected.Retryable)+len(rejected.Failed), len(response.Items), first)
+	return rejected
+}
+
+// SplunkHECEncoder encodes the batches for the Splunk HTTP Event Collector.
+// Send them to the /services/collector/event endpoint, with the header
+// Authorization: Splunk <token>.
+type SplunkHECEncoder struct {
+	// Host, Source, SourceType and Index, if set, are the metadata of the
+	// events.
+	Host       string
+	Source     string
+	SourceType string
+	Index      string
+}
+
+// ContentType implements HTTPEncoder.
+func (e SplunkHECEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements HTTPEncoder. The event of each entry is the object
+// written by JSONFormatter.
+func (e SplunkHECEncoder) Encode(lines [][]byte) ([]byte, error) {
+	var body bytes.Buffer
+	for _, line := range lines {
+		record := decodeRecord(line)
+		event := map[string]any{
+			"time":  json.Number(fmt.Sprintf("%d.%03d", record.time.Unix(), record.time.Nanosecond()/int(time.Millisecond))),
+			"event": json.RawMessage(recordJSON(record)),
+		}
+		for key, value := range map[string]string{"host": e.Host, "source": e.Source, "sourcetype": e.SourceType, "index": e.Index} {
+			if value != "" {
+				event[key] = value
+			}
+		}
+		body.Write(encodeFields(event))
+	}
+	return body.Bytes(), nil
+}
+
+// JSONArrayEncoder encodes the batches as a JSON array of the objects
+// written by JSONFormatter, e.g. for webhooks.
+type JSONArrayEncoder struct{}
+
+// ContentType implements HTTPEncoder.
+func (JSONArrayEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements HTTPEncoder.
+func (JSONArrayEncoder) Encode(lines [][]byte) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, line := range lines {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(recordJSON(decodeRecord(line)))
+	}
+	body.WriteByte(']')
+	return body.Bytes(), nil
+}
+
+// recordJSON returns the record as a JSON object: the line itself, unless it
+// was not written by JSONFormatter.
+func recordJSON(record sinkRecord) []byte {
+	if record.fields != nil {
+		return record.line
+	}
+	return encodeFields(record.entryFields())
+}
+
+// encodeFields encodes the fields of a record, without escaping the HTML
+// characters again.
+func encodeFields(fields map[string]any) []byte {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fields); err != nil {
+		return []byte(fmt.Sprintf(`{"msg":%q}`, UnserializableValue))
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n")
+}
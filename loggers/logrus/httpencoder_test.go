@@ -0,0 +1,82 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPEncoders(t *testing.T) {
+	lines := [][]byte{
+		[]byte(`{"level":30,"msg":"first <b>","reqId":"abc","time":1704189630123}`),
+		[]byte(`plain text`),
+	}
+
+	t.Run("Elasticsearch bulk", func(t *testing.T) {
+		encoder := ElasticsearchBulkEncoder{Index: "logs-app"}
+		require.Equal(t, "application/x-ndjson", encoder.ContentType())
+		body, err := encoder.Encode(lines[:1])
+		require.NoError(t, err)
+		require.Equal(t, `{"create":{"_index":"logs-app"}}
+{"level":30,"msg":"first <b>","reqId":"abc","time":1704189630123}
+`, string(body))
+
+		encoder = ElasticsearchBulkEncoder{TimestampField: "@timestamp"}
+		body, err = encoder.Encode(lines[:1])
+		require.NoError(t, err)
+		require.Equal(t, `{"create":{}}
+{"@timestamp":"2024-01-02T10:00:30.123Z","level":30,"msg":"first <b>","reqId":"abc","time":1704189630123}
+`, string(body))
+	})
+
+	t.Run("Elasticsearch bulk response", func(t *testing.T) {
+		encoder := ElasticsearchBulkEncoder{}
+		require.NoError(t, encoder.CheckResponse([]byte(`{"errors":false,"items":[{"create":{"status":201}}]}`)))
+		require.EqualError(t, encoder.CheckResponse([]byte(`{"errors":true,"items":[{"create":{"status":409,"error":{"type":"version_conflict_engine_exception","reason":"exists"}}}]}`)),
+			"1 of 1 documents rejected, first error version_conflict_engine_exception: exists")
+
+		err := encoder.CheckResponse([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429}},{"create":{"status":400}},{"create":{"status":500}}]}`))
+		var rejected *RejectedEntriesError
+		require.ErrorAs(t, err, &rejected)
+		require.Equal(t, []int{1, 3}, rejected.Retryable)
+		require.Equal(t, []int{2}, rejected.Failed)
+		require.ErrorContains(t, encoder.CheckResponse([]byte(`<html>`)), "invalid bulk response")
+	})
+
+	t.Run("Splunk HEC", func(t *testing.T) {
+		encoder := SplunkHECEncoder{Host: "host-1", SourceType: "_json", Index: "main"}
+		require.Equal(t, "application/json", encoder.ContentType())
+		body, err := encoder.Encode(lines)
+		require.NoError(t, err)
+		require.Regexp(t, `^\{"event":\{"level":30,"msg":"first <b>","reqId":"abc","time":1704189630123\},"host":"host-1","index":"main","sourcetype":"_json","time":1704189630.123\}`+
+			`\{"event":\{"level":30,"msg":"plain text","time":\d+\},"host":"host-1","index":"main","sourcetype":"_json","time":\d+\.\d{3}\}$`, string(body))
+	})
+
+	t.Run("JSON array", func(t *testing.T) {
+		encoder := JSONArrayEncoder{}
+		require.Equal(t, "application/json", encoder.ContentType())
+		body, err := encoder.Encode(lines)
+		require.NoError(t, err)
+		require.Regexp(t, `^\[\{"level":30,"msg":"first <b>","reqId":"abc","time":1704189630123\},\{"level":30,"msg":"plain text","time":\d+\}\]$`, string(body))
+
+		body, err = encoder.Encode(nil)
+		require.NoError(t, err)
+		require.Equal(t, "[]", string(body))
+	})
+}
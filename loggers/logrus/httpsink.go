@@ -0,0 +1,251 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPEncoder encodes a batch of entries in the body of a request.
+type HTTPEncoder interface {
+	// ContentType is the Content-Type of the requests.
+	ContentType() string
+	// Encode returns the body of a request for the lines, each one an entry
+	// encoded by JSONFormatter, without the trailing newline.
+	Encode(lines [][]byte) ([]byte, error)
+}
+
+// HTTPResponseChecker is implemented by the HTTPEncoders of the backends
+// reporting failures in the body of successful responses, like the
+// Elasticsearch bulk API. A RejectedEntriesError fails the rejected entries
+// only, any other error fails the whole batch, without retries.
+type HTTPResponseChecker interface {
+	CheckResponse(body []byte) error
+}
+
+// RejectedEntriesError is returned by an HTTPResponseChecker when the backend
+// rejects some entries of a batch. The indexes are the positions of the
+// entries in the batch.
+type RejectedEntriesError struct {
+	// Retryable entries are rejected temporarily, e.g. with 429 or 5xx, and
+	// are sent again with backoff.
+	Retryable []int
+	// Failed entries are rejected for good, e.g. with 400, and are written
+	// to the dead letter file.
+	Failed []int
+	Err    error
+}
+
+func (e *RejectedEntriesError) Error() string { return e.Err.Error() }
+func (e *RejectedEntriesError) Unwrap() error { return e.Err }
+
+// HTTPSinkOptions configures an HTTPSink.
+type HTTPSinkOptions struct {
+	// URL is the endpoint receiving the batches.
+	URL string
+	// Encoder encodes the batches, e.g. ElasticsearchBulkEncoder,
+	// SplunkHECEncoder or JSONArrayEncoder.
+	Encoder HTTPEncoder
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string
+	// Compress gzips the requests.
+	Compress bool
+	// MaxConcurrentRequests is the number of batches sent at the same time.
+	// Defaults to 1.
+	MaxConcurrentRequests int
+	// DeadLetterPath, if set, is the file where the entries that could not
+	// be delivered are appended, one per line.
+	DeadLetterPath string
+	// Timeout limits each request. Defaults to 10s.
+	Timeout time.Duration
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	Batch      BatchOptions
+	Retry      RetryOptions
+}
+
+// HTTPSink is an io.Writer sending the entries in batches to an HTTP
+// endpoint, encoded by a pluggable HTTPEncoder. Requests are retried with
+// backoff on network errors and on 429 and 5xx responses; the batches still
+// failing are appended to the dead letter file, if configured, to be sent
+// again later. Stats counts the entries sent, failed and dropped.
+type HTTPSink struct {
+	*batchSink
+
+	request    httpRequest
+	encoder    HTTPEncoder
+	timeout    time.Duration
+	retry      RetryOptions
+	deadLetter *deadLetterFile
+}
+
+// NewHTTPSink returns an HTTPSink sending to options.URL.
+func NewHTTPSink(options HTTPSinkOptions) (*HTTPSink, error) {
+	endpoint, err := url.Parse(options.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid HTTP sink URL %q: must be an http or https URL", options.URL)
+	}
+	if options.Encoder == nil {
+		return nil, errors.New("HTTP sink encoder is required")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	sink := &HTTPSink{
+		request: httpRequest{
+			client:      options.HTTPClient,
+			url:         options.URL,
+			contentType: options.Encoder.ContentType(),
+			headers:     options.Headers,
+			gzip:        options.Compress,
+		},
+		encoder: options.Encoder,
+		timeout: timeout,
+		retry:   options.Retry,
+	}
+	if checker, ok := options.Encoder.(HTTPResponseChecker); ok {
+		sink.request.checkResponse = checker.CheckResponse
+	}
+	if options.DeadLetterPath != "" {
+		sink.deadLetter = &deadLetterFile{path: options.DeadLetterPath}
+	}
+	sink.batchSink = newConcurrentBatchSink(options.Batch, options.MaxConcurrentRequests, sink.export)
+	return sink, nil
+}
+
+// Shutdown sends the queued entries and closes the dead letter file.
+func (s *HTTPSink) Shutdown(ctx context.Context) error {
+	err := s.batchSink.Shutdown(ctx)
+	if s.deadLetter != nil {
+		err = errors.Join(err, s.deadLetter.close())
+	}
+	return err
+}
+
+// Close is Shutdown without a deadline.
+func (s *HTTPSink) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+func (s *HTTPSink) export(ctx context.Context, lines [][]byte) error {
+	// pending are the entries still to send, failed the ones rejected for
+	// good by the response checker
+	pending := lines
+	var failed [][]byte
+	var rejectedErr error
+	body, err := s.encoder.Encode(pending)
+	if err == nil {
+		err = withRetry(ctx, s.retry, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			err := s.request.post(ctx, body)
+			var rejected *RejectedEntriesError
+			if !errors.As(err, &rejected) {
+				return err
+			}
+
+			rejectedErr = rejected
+			failed = append(failed, selectLines(pending, rejected.Failed)...)
+			if pending = selectLines(pending, rejected.Retryable); len(pending) == 0 {
+				return nil
+			}
+			if body, err = s.encoder.Encode(pending); err != nil {
+				return err
+			}
+			return &retryableError{err: rejected}
+		})
+	}
+	if err != nil {
+		failed = append(failed, pending...)
+	} else if len(failed) > 0 {
+		err = rejectedErr
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err = fmt.Errorf("failed to send %d of %d entries to %s: %w", len(failed), len(lines), s.request.url, err)
+	if s.deadLetter != nil {
+		if dlErr := s.deadLetter.write(failed); dlErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write dead letter file: %w", dlErr))
+		}
+	}
+	return &partialExportError{failed: len(failed), err: err}
+}
+
+// selectLines returns the lines at the indexes, skipping the ones out of
+// range.
+func selectLines(lines [][]byte, indexes []int) [][]byte {
+	selected := make([][]byte, 0, len(indexes))
+	for _, index := range indexes {
+		if index >= 0 && index < len(lines) {
+			selected = append(selected, lines[index])
+		}
+	}
+	return selected
+}
+
+// deadLetterFile appends the undelivered entries to a file, opened when the
+// first batch fails.
+type deadLetterFile struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (d *deadLetterFile) write(lines [][]byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		file, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileSinkMode)
+		if err != nil {
+			return err
+		}
+		d.file = file
+	}
+
+	var buffer []byte
+	for _, line := range lines {
+		buffer = append(buffer, line...)
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			buffer = append(buffer, '\n')
+		}
+	}
+	_, err := d.file.Write(buffer)
+	return err
+}
+
+func (d *deadLetterFile) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	fastRetry := RetryOptions{InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond, MaxElapsedTime: 50 * time.Millisecond}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewHTTPSink(HTTPSinkOptions{URL: "example.com/hook", Encoder: JSONArrayEncoder{}})
+		require.EqualError(t, err, `invalid HTTP sink URL "example.com/hook": must be an http or https URL`)
+
+		_, err = NewHTTPSink(HTTPSinkOptions{URL: "http://example.com/hook"})
+		require.EqualError(t, err, "HTTP sink encoder is required")
+	})
+
+	t.Run("sends the batches encoded", func(t *testing.T) {
+		collector := newCollector(t)
+		sink, err := NewHTTPSink(HTTPSinkOptions{
+			URL:      collector.URL + "/hook",
+			Encoder:  JSONArrayEncoder{},
+			Headers:  map[string]string{"Authorization": "Bearer token"},
+			Compress: true,
+			Batch:    BatchOptions{MaxBatchSize: 2},
+		})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		for _, msg := range []string{"first", "second", "third"} {
+			logger.WithTime(entryTime).Info(msg)
+		}
+		require.NoError(t, sink.Close())
+
+		requests, bodies := collector.received()
+		require.Len(t, requests, 2)
+		require.Equal(t, "/hook", requests[0].URL.Path)
+		require.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+		require.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+		require.JSONEq(t, `[
+			{"level":30,"msg":"first","time":1704189630123},
+			{"level":30,"msg":"second","time":1704189630123}
+		]`, string(bodies[0]))
+		require.JSONEq(t, `[{"level":30,"msg":"third","time":1704189630123}]`, string(bodies[1]))
+		require.Equal(t, BatchStats{Exported: 3}, sink.Stats())
+	})
+
+	t.Run("writes the undelivered entries to the dead letter file", func(t *testing.T) {
+		collector := newCollector(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+			http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		path := filepath.Join(t.TempDir(), "dead-letter.log")
+		sink, err := NewHTTPSink(HTTPSinkOptions{
+			URL:            collector.URL,
+			Encoder:        JSONArrayEncoder{},
+			DeadLetterPath: path,
+			Retry:          RetryOptions{Disabled: true},
+		})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).Info("first")
+		logger.WithTime(entryTime).Info("second")
+		require.ErrorContains(t, sink.Flush(context.Background()), "failed to send 2 of 2 entries to "+collector.URL)
+		logger.WithTime(entryTime).Info("third")
+		require.Error(t, sink.Flush(context.Background()))
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, `{"level":30,"msg":"first","time":1704189630123}
+{"level":30,"msg":"second","time":1704189630123}
+{"level":30,"msg":"third","time":1704189630123}
+`, readFile(t, path))
+		require.Equal(t, BatchStats{Failed: 3}, sink.Stats())
+	})
+
+	t.Run("gives up after the retries", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+		sink, err := NewHTTPSink(HTTPSinkOptions{URL: server.URL, Encoder: JSONArrayEncoder{}, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).Info("hello")
+		require.ErrorContains(t, sink.Flush(context.Background()), "giving up after 50ms")
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+	})
+
+	t.Run("fails the entries rejected in the response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+		}))
+		defer server.Close()
+		path := filepath.Join(t.TempDir(), "dead-letter.log")
+		sink, err := NewHTTPSink(HTTPSinkOptions{URL: server.URL + "/_bulk", Encoder: ElasticsearchBulkEncoder{Index: "logs"}, DeadLetterPath: path})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).Info("first")
+		logger.WithTime(entryTime).Info("second")
+		require.ErrorContains(t, sink.Flush(context.Background()), "failed to send 1 of 2 entries to "+server.URL+"/_bulk: 1 of 2 documents rejected, first error mapper_parsing_exception: failed to parse")
+		require.Equal(t, BatchStats{Exported: 1, Failed: 1}, sink.Stats())
+		require.Equal(t, `{"level":30,"msg":"second","time":1704189630123}
+`, readFile(t, path))
+	})
+
+	t.Run("retries the entries rejected temporarily", func(t *testing.T) {
+		var mu sync.Mutex
+		var bodies []string
+		responses := []string{
+			`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}},{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}},{"create":{"status":503,"error":{"type":"unavailable_shards_exception","reason":"primary shard is not active"}}}]}`,
+			`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}]}`,
+			`{"errors":false,"items":[{"create":{"status":201}}]}`,
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			bodies = append(bodies, string(body))
+			w.Write([]byte(responses[len(bodies)-1]))
+		}))
+		defer server.Close()
+		path := filepath.Join(t.TempDir(), "dead-letter.log")
+		sink, err := NewHTTPSink(HTTPSinkOptions{URL: server.URL + "/_bulk", Encoder: ElasticsearchBulkEncoder{}, DeadLetterPath: path, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		for _, msg := range []string{"first", "second", "third", "fourth"} {
+			logger.WithTime(entryTime).Info(msg)
+		}
+		require.ErrorContains(t, sink.Flush(context.Background()), "failed to send 1 of 4 entries")
+		require.Equal(t, BatchStats{Exported: 3, Failed: 1}, sink.Stats())
+		require.Equal(t, `{"level":30,"msg":"third","time":1704189630123}
+`, readFile(t, path))
+
+		require.Len(t, bodies, 3)
+		require.Equal(t, `{"create":{}}
+{"level":30,"msg":"second","time":1704189630123}
+{"create":{}}
+{"level":30,"msg":"fourth","time":1704189630123}
+`, bodies[1])
+		require.Equal(t, `{"create":{}}
+{"level":30,"msg":"fourth","time":1704189630123}
+`, bodies[2])
+	})
+
+	t.Run("fails the entries still rejected after the retries", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"errors":true,"items":[{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}]}`))
+		}))
+		defer server.Close()
+		sink, err := NewHTTPSink(HTTPSinkOptions{URL: server.URL + "/_bulk", Encoder: ElasticsearchBulkEncoder{}, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).Info("hello")
+		require.ErrorContains(t, sink.Flush(context.Background()), "giving up after 50ms")
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+	})
+}
@@ -17,6 +17,10 @@
 package logrus
 
 import (
+	"errors"
+	"fmt"
+	"io"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,21 +28,200 @@ import (
 type InitOptions struct {
 	Level             string
 	DisableHTMLEscape bool
+
+	// Format is one of json (the default), pretty or text.
+	Format string
+	// Output is where the entries are written. Defaults to stderr.
+	Output io.Writer
+	// File, Syslog, Journald, OTLP, Fluent, GELF, Loki and HTTP, if set,
+	// write the entries to the corresponding sink instead of Output. When
+	// more than one is set, the entries are written to all of them. Use
+	// Shutdown to close the sinks.
+	File     *FileSinkOptions
+	Syslog   *SyslogSinkOptions
+	Journald *JournaldSinkOptions
+	OTLP     *OTLPSinkOptions
+	Fluent   *FluentSinkOptions
+	GELF     *GELFSinkOptions
+	Loki     *LokiSinkOptions
+	HTTP     *HTTPSinkOptions
+	// Routes, if set, send each entry to the routes allowing it, each one
+	// with its own formatter and output, instead of Output and the sinks.
+	// Routes without a formatter use the one selected by Format.
+	Routes []Route
+	// Redaction masks the sensitive data of each entry.
+	Redaction RedactionRules
+	// Sampling, if set, limits the entries with the same level and message.
+	Sampling *SamplingOptions
+	// ComponentKey is the field read to apply ComponentLevels. Defaults to
+	// DefaultComponentKey.
+	ComponentKey string
+	// ComponentLevels overrides Level for the entries of some components.
+	ComponentLevels map[string]string
 }
 
-// InitHelper is a function to init json logger
+// InitHelper is a function to init json logger. Use Flush and Shutdown with
+// the returned logger, or Init to also get the lifecycle of the sinks.
 func InitHelper(options InitOptions) (*logrus.Logger, error) {
+	handle, err := Init(options)
+	if err != nil {
+		return nil, err
+	}
+	return handle.Logger, nil
+}
+
+func newLogger(options InitOptions) (*logrus.Logger, error) {
 	logger := logrus.New()
-	logger.SetFormatter(&JSONFormatter{
-		DisableHTMLEscape: options.DisableHTMLEscape,
-	})
-	if options.Level == "" {
+
+	formatter, err := newFormatter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	level := logger.GetLevel()
+	if options.Level != "" {
+		if level, err = logrus.ParseLevel(options.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	var filters []EntryFilter
+	if len(options.ComponentLevels) > 0 {
+		componentLevels, err := NewComponentLevels(options.ComponentKey, level, options.ComponentLevels)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, componentLevels)
+		level = componentLevels.MostVerbose()
+	}
+	if options.Sampling != nil {
+		filters = append(filters, NewSampler(*options.Sampling))
+	}
+
+	if !options.Redaction.IsEmpty() {
+		redactor, err := NewRedactor(options.Redaction)
+		if err != nil {
+			return nil, err
+		}
+		logger.AddHook(&RedactionHook{Redactor: redactor})
+	}
+
+	var router *Router
+	if len(options.Routes) > 0 {
+		if options.Output != nil || hasSinks(options) {
+			return nil, errors.New("routes replace output and sinks: set only one of them")
+		}
+		if router, err = NewRouter(formatter, options.Routes...); err != nil {
+			return nil, err
+		}
+		formatter = router
+	}
+
+	if len(filters) > 0 {
+		formatter = &FilterFormatter{Formatter: formatter, Filters: filters}
+	}
+	logger.SetFormatter(formatter)
+	logger.SetLevel(level)
+	if router != nil {
+		setSinks(logger, router)
 		return logger, nil
 	}
-	level, err := logrus.ParseLevel(options.Level)
+	if options.Output != nil {
+		logger.SetOutput(options.Output)
+	}
+
+	sinks, err := newSinks(options)
 	if err != nil {
 		return nil, err
 	}
-	logger.SetLevel(level)
+	switch len(sinks) {
+	case 0:
+	case 1:
+		setSinks(logger, sinks[0])
+	default:
+		setSinks(logger, multiSink(sinks))
+	}
 	return logger, nil
 }
+
+// setSinks sets the output of the logger, flushing it after the panic
+// entries.
+func setSinks(logger *logrus.Logger, out io.Writer) {
+	flusher := &panicFlusher{formatter: logger.Formatter, out: out}
+	logger.SetFormatter(flusher)
+	logger.SetOutput(flusher)
+}
+
+func hasSinks(options InitOptions) bool {
+	return options.File != nil || options.Syslog != nil || options.Journald != nil || options.OTLP != nil ||
+		options.Fluent != nil || options.GELF != nil || options.Loki != nil || options.HTTP != nil
+}
+
+// newSinks creates the sinks set in the options. If one of them fails, the
+// ones already created are closed.
+func newSinks(options InitOptions) ([]io.Writer, error) {
+	var sinks []io.Writer
+	add := func(sink io.Writer, err error) error {
+		if err != nil {
+			closeAll(sinks)
+			return err
+		}
+		sinks = append(sinks, sink)
+		return nil
+	}
+
+	if options.File != nil {
+		if err := add(NewFileSink(*options.File)); err != nil {
+			return nil, err
+		}
+	}
+	if options.Syslog != nil {
+		if err := add(NewSyslogSink(*options.Syslog)); err != nil {
+			return nil, err
+		}
+	}
+	if options.Journald != nil {
+		if err := add(NewJournaldSink(*options.Journald)); err != nil {
+			return nil, err
+		}
+	}
+	if options.OTLP != nil {
+		if err := add(NewOTLPSink(*options.OTLP)); err != nil {
+			return nil, err
+		}
+	}
+	if options.Fluent != nil {
+		if err := add(NewFluentSink(*options.Fluent)); err != nil {
+			return nil, err
+		}
+	}
+	if options.GELF != nil {
+		if err := add(NewGELFSink(*options.GELF)); err != nil {
+			return nil, err
+		}
+	}
+	if options.Loki != nil {
+		if err := add(NewLokiSink(*options.Loki)); err != nil {
+			return nil, err
+		}
+	}
+	if options.HTTP != nil {
+		if err := add(NewHTTPSink(*options.HTTP)); err != nil {
+			return nil, err
+		}
+	}
+	return sinks, nil
+}
+
+func newFormatter(options InitOptions) (logrus.Formatter, error) {
+	switch options.Format {
+	case "", FormatJSON:
+		return &JSONFormatter{DisableHTMLEscape: options.DisableHTMLEscape}, nil
+	case FormatPretty:
+		return &JSONFormatter{DisableHTMLEscape: options.DisableHTMLEscape, PrettyPrint: true}, nil
+	case FormatText:
+		return &logrus.TextFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", options.Format)
+	}
+}
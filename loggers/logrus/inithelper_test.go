@@ -19,6 +19,7 @@ package logrus
 import (
 	"bytes"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -75,4 +76,44 @@ func TestInitHelper(t *testing.T) {
 			Time:    now.UnixNano() / int64(1e6),
 		}, result)
 	})
+	t.Run("format and output", func(t *testing.T) {
+		var buffer bytes.Buffer
+		logger, err := InitHelper(InitOptions{Format: "pretty", Output: &buffer})
+		require.NoError(t, err)
+
+		logger.Info("hello")
+		require.Contains(t, buffer.String(), "\n  \"msg\": \"hello\"")
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		var errorsOutput, allOutput bytes.Buffer
+		logger, err := InitHelper(InitOptions{
+			Level:    "debug",
+			Sampling: &SamplingOptions{Initial: 1},
+			Routes: []Route{
+				{Output: &errorsOutput, Filters: []EntryFilter{LevelAtLeast(logrus.ErrorLevel)}, Formatter: &logrus.TextFormatter{DisableTimestamp: true}},
+				{Output: &allOutput},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, logrus.DebugLevel, logger.GetLevel())
+
+		logger.Error("failed")
+		logger.Debug("sampled")
+		logger.Debug("sampled")
+		require.Equal(t, "level=error msg=failed\n", errorsOutput.String())
+		require.Equal(t, 2, strings.Count(allOutput.String(), "\n"))
+	})
+
+	t.Run("routes with output return error", func(t *testing.T) {
+		_, err := InitHelper(InitOptions{Output: &bytes.Buffer{}, Routes: []Route{{Output: &bytes.Buffer{}}}})
+		require.EqualError(t, err, "routes replace output and sinks: set only one of them")
+	})
+
+	t.Run("unknown format return error", func(t *testing.T) {
+		logger, err := InitHelper(InitOptions{Format: "xml"})
+
+		require.Nil(t, logger)
+		require.EqualError(t, err, `unknown log format "xml"`)
+	})
 }
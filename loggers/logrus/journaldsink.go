@@ -0,0 +1,164 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultJournaldSocket is the socket of the journald native protocol.
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldSinkOptions configures a JournaldSink.
+type JournaldSinkOptions struct {
+	// SocketPath defaults to DefaultJournaldSocket.
+	SocketPath string
+	// SyslogIdentifier defaults to the name of the executable.
+	SyslogIdentifier string
+}
+
+// JournaldSink is an io.Writer sending each entry to journald with its
+// native protocol. The message is sent as MESSAGE, the level is mapped to
+// PRIORITY and each field is sent as a journal field with its name in upper
+// case, e.g. reqId as REQID. Objects are sent as JSON.
+//
+// Each entry is sent as a single datagram, so entries larger than the socket
+// buffer are rejected: use JSONFormatter.MaxLineBytes to limit them.
+// A JournaldSink is safe for concurrent use.
+type JournaldSink struct {
+	identifier string
+
+	mu     sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+// NewJournaldSink connects to the journald socket.
+func NewJournaldSink(options JournaldSinkOptions) (*JournaldSink, error) {
+	socket := options.SocketPath
+	if socket == "" {
+		socket = DefaultJournaldSocket
+	}
+	identifier := options.SyslogIdentifier
+	if identifier == "" {
+		identifier = programName()
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &JournaldSink{identifier: identifier, conn: conn}, nil
+}
+
+// Write sends the entry to journald. Empty writes are ignored.
+func (s *JournaldSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	message := s.format(decodeRecord(p))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if _, err := s.conn.Write(message); err != nil {
+		return 0, fmt.Errorf("failed to write to journald: %w", err)
+	}
+	return len(p), nil
+}
+
+// Close closes the connection.
+func (s *JournaldSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.conn.Close()
+}
+
+func (s *JournaldSink) format(record sinkRecord) []byte {
+	var b bytes.Buffer
+	writeJournaldField(&b, "MESSAGE", record.message)
+	writeJournaldField(&b, "PRIORITY", strconv.Itoa(syslogSeverity(record.level)))
+	writeJournaldField(&b, "SYSLOG_IDENTIFIER", s.identifier)
+	writeJournaldField(&b, "LEVEL", strconv.Itoa(record.level))
+	for _, key := range record.sortedKeys() {
+		name := journaldFieldName(key)
+		switch name {
+		case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER", "LEVEL":
+			// fields written by the sink are not overwritten
+			name = "FIELD_" + name
+		}
+		writeJournaldField(&b, name, fieldString(record.fields[key]))
+	}
+	return b.Bytes()
+}
+
+// writeJournaldField writes a field as NAME=value, or with its length when
+// the value has new lines, as defined by the journald native protocol.
+func writeJournaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.Write(size[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journaldFieldName returns a valid journal field name: upper case letters,
+// digits and underscores, not starting with an underscore or a digit, at
+// most 64 characters.
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "FIELD_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournaldSink(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "journal.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	t.Run("fails without journald", func(t *testing.T) {
+		_, err := NewJournaldSink(JournaldSinkOptions{SocketPath: filepath.Join(shortTempDir(t), "missing.sock")})
+		require.ErrorContains(t, err, "failed to connect to journald")
+	})
+
+	t.Run("writes the native protocol", func(t *testing.T) {
+		sink, err := NewJournaldSink(JournaldSinkOptions{SocketPath: path, SyslogIdentifier: "app"})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).WithFields(logrus.Fields{
+			"reqId":    "abc",
+			"http":     map[string]any{"status": 200},
+			"priority": "high",
+			"_private": true,
+			"1st":      1,
+		}).Error("first line\nsecond line")
+
+		require.Equal(t, "MESSAGE\n"+
+			"\x16\x00\x00\x00\x00\x00\x00\x00first line\nsecond line\n"+
+			"PRIORITY=3\n"+
+			"SYSLOG_IDENTIFIER=app\n"+
+			"LEVEL=50\n"+
+			"FIELD_1ST=1\n"+
+			"PRIVATE=true\n"+
+			"HTTP={\"status\":200}\n"+
+			"FIELD_PRIORITY=high\n"+
+			"REQID=abc\n",
+			readPacket(t, listener),
+		)
+	})
+
+	t.Run("maps the levels to priorities", func(t *testing.T) {
+		sink, err := NewJournaldSink(JournaldSinkOptions{SocketPath: path, SyslogIdentifier: "app"})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		for level, priority := range map[logrus.Level]string{
+			logrus.TraceLevel: "7",
+			logrus.DebugLevel: "7",
+			logrus.InfoLevel:  "6",
+			logrus.WarnLevel:  "4",
+			logrus.ErrorLevel: "3",
+			logrus.FatalLevel: "2",
+		} {
+			logger.Log(level, "msg")
+			require.Contains(t, readPacket(t, listener), "\nPRIORITY="+priority+"\n")
+		}
+
+		require.NoError(t, sink.Close())
+		_, err = sink.Write([]byte("{}\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+	})
+
+	t.Run("InitHelper writes to all the sinks", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "app.log")
+		logger, err := InitHelper(InitOptions{
+			File:     &FileSinkOptions{Path: filePath},
+			Journald: &JournaldSinkOptions{SocketPath: path},
+		})
+		require.NoError(t, err)
+
+		logger.Info("hello")
+		require.Contains(t, readPacket(t, listener), "MESSAGE=hello\n")
+		require.Contains(t, readFile(t, filePath), `"msg":"hello"`)
+		require.NoError(t, Shutdown(context.Background(), logger))
+	})
+
+	t.Run("InitHelper returns the sink errors", func(t *testing.T) {
+		logger, err := InitHelper(InitOptions{
+			Journald: &JournaldSinkOptions{SocketPath: path},
+			Syslog:   &SyslogSinkOptions{Network: "udp"},
+		})
+		require.Nil(t, logger)
+		require.EqualError(t, err, "syslog address is required")
+	})
+}
@@ -0,0 +1,856 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/sirupsen/logrus"
+)
+
+const hex = "0123456789abcdef"
+
+// maxFastDepth is the nesting level after which maps and slices are handed
+// over to encoding/json, which is able to detect cycles.
+const maxFastDepth = 100
+
+// keysPool holds the slices used to sort map keys, so that sorting does not
+// allocate on every entry.
+var keysPool = sync.Pool{
+	New: func() any {
+		keys := make([]string, 0, 16)
+		return &keys
+	},
+}
+
+// encoderPool holds the encoders, reused since the values implementing
+// core.ObjectMarshaler make them escape to the heap.
+var encoderPool = sync.Pool{
+	New: func() any {
+		return new(jsonEncoder)
+	},
+}
+
+// jsonEncoder writes JSON values straight into a buffer. Its output is byte
+// for byte the same produced by encoding/json: map keys are sorted, struct
+// fields keep their declaration order and strings are escaped the same way.
+// Known types and the core.ObjectMarshaler values are written without
+// reflection, everything else falls back to encoding/json.
+type jsonEncoder struct {
+	buf        *bytes.Buffer
+	escapeHTML bool
+	depth      int
+	scratch    [64]byte
+	// first reports whether the next field written by a core.ObjectMarshaler
+	// is the first one of its object.
+	first bool
+
+	// maxStringLength and maxArrayLength limit the values written, when
+	// greater than zero.
+	maxStringLength int
+	maxArrayLength  int
+	// truncated reports whether a value has been truncated in the last pass.
+	truncated bool
+}
+
+// entryState collects what happened while encoding an entry and drives the
+// following passes, when the entry has to be written again.
+type entryState struct {
+	data      logrus.Fields
+	message   string
+	failed    []string
+	errs      []string
+	logError  string
+	truncated bool
+	// elided are the keys whose value is dropped to fit the line limit.
+	elided []string
+	// minimal writes only the level, message and time of the entry.
+	minimal bool
+
+	recordSpans bool
+	spans       []fieldSpan
+}
+
+// fieldSpan is the size of the encoded value of a field.
+type fieldSpan struct {
+	key  string
+	size int
+}
+
+// encodeEntry writes the entry with the given fields as a single JSON object
+// followed by a newline. Values that cannot be serialized are replaced with a
+// placeholder, and the entry is written again reporting them, after errs, in
+// the LogErrorKey field. The same happens when a value is truncated, to add
+// the TruncatedKey field.
+func (e *jsonEncoder) encodeEntry(entry *logrus.Entry, data logrus.Fields, errs []string, maxLineBytes int) {
+	start := e.buf.Len()
+	state := entryState{
+		data:        data,
+		message:     entry.Message,
+		errs:        errs,
+		logError:    strings.Join(errs, "; "),
+		recordSpans: maxLineBytes > 0,
+	}
+	for e.encodeFields(entry, &state) {
+		e.buf.Truncate(start)
+	}
+
+	if maxLineBytes > 0 && e.buf.Len()-start-1 > maxLineBytes {
+		e.fitLine(entry, &state, start, maxLineBytes)
+	}
+}
+
+// fitLine writes the entry again so that it fits in maxLineBytes. First the
+// largest fields are replaced, from the biggest one, then the message is
+// truncated. If that is still not enough, only the level, the message and
+// the time are kept.
+func (e *jsonEncoder) fitLine(entry *logrus.Entry, state *entryState, start, maxLineBytes int) {
+	size := e.buf.Len() - start - 1
+	if !state.truncated {
+		state.truncated = true
+		size += len(`,"":true`) + len(TruncatedKey)
+	}
+
+	spans := append([]fieldSpan(nil), state.spans...)
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].size != spans[j].size {
+			return spans[i].size > spans[j].size
+		}
+		return spans[i].key < spans[j].key
+	})
+	placeholderSize := len(TruncatedValue) + 2
+	for _, span := range spans {
+		if size <= maxLineBytes {
+			break
+		}
+		if span.size <= placeholderSize {
+			continue
+		}
+		state.elided = append(state.elided, span.key)
+		size -= span.size - placeholderSize
+	}
+	e.buf.Truncate(start)
+	e.encodeFields(entry, state)
+
+	if excess := e.buf.Len() - start - 1 - maxLineBytes; excess > 0 && excess < len(state.message) {
+		// Each byte removed from the message shrinks the line by at least
+		// one byte, since escaping can only make the string longer.
+		state.message = truncateString(state.message, len(state.message)-excess)
+		e.buf.Truncate(start)
+		e.encodeFields(entry, state)
+	}
+
+	if e.buf.Len()-start-1 > maxLineBytes {
+		state.minimal = true
+		state.message = truncateString(entry.Message, maxLineBytes-minimalEntrySize)
+		e.buf.Truncate(start)
+		e.encodeFields(entry, state)
+		// the escaped message is longer than its bytes: cut the excess until
+		// the line fits, or the message is empty
+		for excess := e.buf.Len() - start - 1 - maxLineBytes; excess > 0 && state.message != ""; excess = e.buf.Len() - start - 1 - maxLineBytes {
+			state.message = truncateString(state.message, len(state.message)-excess)
+			e.buf.Truncate(start)
+			e.encodeFields(entry, state)
+		}
+	}
+}
+
+// minimalEntrySize is the size of an entry with only the level, the time and
+// an empty message, marked as truncated.
+const minimalEntrySize = len(`{"level":00,"msg":"","time":0000000000000,"truncated":true}`)
+
+// MinLineBytes is the smallest line limit honored by JSONFormatter: the size
+// of an entry with only the level, the time and an empty message.
+const MinLineBytes = minimalEntrySize
+
+// encodeFields writes the entry fields, following what is recorded in state
+// by the previous passes. It returns whether the entry has to be written
+// again, because new fields have to be added to report what happened.
+func (e *jsonEncoder) encodeFields(entry *logrus.Entry, state *entryState) bool {
+	keysPtr := keysPool.Get().(*[]string)
+	defer func() {
+		*keysPtr = (*keysPtr)[:0]
+		keysPool.Put(keysPtr)
+	}()
+
+	keys := append((*keysPtr)[:0], "level", "msg", "time")
+	if state.logError != "" && !state.minimal {
+		keys = append(keys, LogErrorKey)
+	}
+	if state.truncated {
+		keys = append(keys, TruncatedKey)
+	}
+	if !state.minimal {
+		for k := range state.data {
+			if k == "level" || k == "msg" || k == "time" ||
+				(state.logError != "" && k == LogErrorKey) ||
+				(state.truncated && k == TruncatedKey) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys)
+	*keysPtr = keys
+
+	e.truncated = false
+	if state.recordSpans {
+		state.spans = state.spans[:0]
+	}
+	newFailures := false
+	e.buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeString(k)
+		e.buf.WriteByte(':')
+
+		switch {
+		case k == "level":
+			e.writeInt(int64(getLevelFromString(entry.Level)))
+		case k == "msg":
+			e.writeValueString(state.message)
+		case k == "time":
+			e.writeInt(entry.Time.UnixNano() / int64(1e6))
+		case state.logError != "" && k == LogErrorKey:
+			e.writeString(state.logError)
+		case state.truncated && k == TruncatedKey:
+			e.buf.WriteString("true")
+		default:
+			valueStart := e.buf.Len()
+			switch {
+			case contains(state.failed, k):
+				e.writeString(UnserializableValue)
+			case contains(state.elided, k):
+				e.writeString(TruncatedValue)
+			default:
+				if err := e.encodeField(state.data[k]); err != nil {
+					e.buf.Truncate(valueStart)
+					e.writeString(UnserializableValue)
+					state.failed = append(state.failed, k)
+					state.errs = append(state.errs, fieldErrorMessage(k, err))
+					newFailures = true
+				}
+			}
+			if state.recordSpans {
+				state.spans = append(state.spans, fieldSpan{key: k, size: e.buf.Len() - valueStart})
+			}
+		}
+	}
+	e.buf.WriteString("}\n")
+
+	rewrite := false
+	if newFailures {
+		state.logError = strings.Join(state.errs, "; ")
+		rewrite = true
+	}
+	if e.truncated && !state.truncated {
+		state.truncated = true
+		rewrite = true
+	}
+	return rewrite
+}
+
+// encodeField writes a top level field value. Errors are written as their
+// message, since encoding/json would otherwise drop them.
+func (e *jsonEncoder) encodeField(v any) error {
+	if err, ok := v.(error); ok {
+		e.writeValueString(err.Error())
+		return nil
+	}
+	return e.encodeValue(v)
+}
+
+func (e *jsonEncoder) encodeValue(v any) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf.WriteString("null")
+	case string:
+		e.writeValueString(v)
+	case bool:
+		e.buf.Write(strconv.AppendBool(e.scratch[:0], v))
+	case int:
+		e.writeInt(int64(v))
+	case int8:
+		e.writeInt(int64(v))
+	case int16:
+		e.writeInt(int64(v))
+	case int32:
+		e.writeInt(int64(v))
+	case int64:
+		e.writeInt(v)
+	case uint:
+		e.writeUint(uint64(v))
+	case uint8:
+		e.writeUint(uint64(v))
+	case uint16:
+		e.writeUint(uint64(v))
+	case uint32:
+		e.writeUint(uint64(v))
+	case uint64:
+		e.writeUint(v)
+	case float32:
+		return e.writeFloat(float64(v), 32)
+	case float64:
+		return e.writeFloat(v, 64)
+	case time.Duration:
+		e.writeInt(int64(v))
+	case time.Time:
+		if y := v.Year(); y < 0 || y > 9999 {
+			return e.encodeReflect(v)
+		}
+		e.buf.WriteByte('"')
+		e.buf.Write(v.AppendFormat(e.scratch[:0], time.RFC3339Nano))
+		e.buf.WriteByte('"')
+	case []string:
+		e.writeStrings(v)
+	case []any:
+		return e.encodeSlice(v)
+	case map[string]any:
+		return e.encodeMap(v)
+	case logrus.Fields:
+		return e.encodeMap(v)
+	case map[string]string:
+		return e.encodeStringMap(v)
+	case map[string][]string:
+		return e.encodeStringsMap(v)
+	case core.ObjectMarshaler:
+		return e.encodeObject(v)
+	default:
+		return e.encodeReflect(v)
+	}
+	return nil
+}
+
+// encodeReflect is the slow path, used for types the encoder does not know.
+func (e *jsonEncoder) encodeReflect(v any) error {
+	if e.maxStringLength > 0 || e.maxArrayLength > 0 {
+		return e.encodeReflectLimited(v)
+	}
+
+	encoder := json.NewEncoder(e.buf)
+	encoder.SetEscapeHTML(e.escapeHTML)
+	if err := safeEncode(encoder, v); err != nil {
+		return err
+	}
+	// Encode always terminates the value with a newline
+	e.buf.Truncate(e.buf.Len() - 1)
+	return nil
+}
+
+// encodeReflectLimited encodes v with encoding/json, then writes it again
+// token by token applying the limits. Object keys keep their order.
+func (e *jsonEncoder) encodeReflectLimited(v any) error {
+	var raw bytes.Buffer
+	if err := safeEncode(json.NewEncoder(&raw), v); err != nil {
+		return err
+	}
+
+	type frame struct {
+		object    bool
+		items     int
+		expectKey bool
+	}
+	var stack []*frame
+	decoder := json.NewDecoder(&raw)
+	decoder.UseNumber()
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			e.buf.WriteByte(byte(delim))
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.items++
+				parent.expectKey = parent.object
+			}
+			continue
+		}
+
+		if top != nil && top.object && top.expectKey {
+			if top.items > 0 {
+				e.buf.WriteByte(',')
+			}
+			e.writeString(token.(string))
+			e.buf.WriteByte(':')
+			top.expectKey = false
+			continue
+		}
+
+		if top != nil && !top.object {
+			if e.maxArrayLength > 0 && top.items >= e.maxArrayLength {
+				e.truncated = true
+				if err := skipValue(decoder, token); err != nil {
+					return err
+				}
+				continue
+			}
+			if top.items > 0 {
+				e.buf.WriteByte(',')
+			}
+		}
+
+		switch token := token.(type) {
+		case json.Delim:
+			e.buf.WriteByte(byte(token))
+			stack = append(stack, &frame{object: token == '{', expectKey: token == '{'})
+			continue
+		case string:
+			e.writeValueString(token)
+		case json.Number:
+			e.buf.WriteString(string(token))
+		case bool:
+			e.buf.Write(strconv.AppendBool(e.scratch[:0], token))
+		case nil:
+			e.buf.WriteString("null")
+		}
+		if top != nil {
+			top.items++
+			top.expectKey = top.object
+		}
+	}
+}
+
+// skipValue consumes the tokens of the value starting with token.
+func skipValue(decoder *json.Decoder, token json.Token) error {
+	delim, ok := token.(json.Delim)
+	if !ok || delim == '}' || delim == ']' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+	return nil
+}
+
+func (e *jsonEncoder) encodeSlice(v []any) error {
+	if v == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+	if e.depth >= maxFastDepth {
+		return e.encodeReflect(v)
+	}
+	e.depth++
+	defer func() { e.depth-- }()
+
+	e.buf.WriteByte('[')
+	for i, item := range e.limitSlice(v) {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		if err := e.encodeValue(item); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) encodeMap(m map[string]any) error {
+	if m == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+	if e.depth >= maxFastDepth {
+		return e.encodeReflect(m)
+	}
+	e.depth++
+	defer func() { e.depth-- }()
+
+	keysPtr := keysPool.Get().(*[]string)
+	defer func() {
+		*keysPtr = (*keysPtr)[:0]
+		keysPool.Put(keysPtr)
+	}()
+	keys := (*keysPtr)[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	*keysPtr = keys
+
+	e.buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeString(k)
+		e.buf.WriteByte(':')
+		if err := e.encodeValue(m[k]); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+func (e *jsonEncoder) encodeStringMap(m map[string]string) error {
+	if m == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+
+	keysPtr := keysPool.Get().(*[]string)
+	defer func() {
+		*keysPtr = (*keysPtr)[:0]
+		keysPool.Put(keysPtr)
+	}()
+	keys := (*keysPtr)[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	*keysPtr = keys
+
+	e.buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeString(k)
+		e.buf.WriteByte(':')
+		e.writeValueString(m[k])
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+func (e *jsonEncoder) encodeStringsMap(m map[string][]string) error {
+	if m == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+
+	keysPtr := keysPool.Get().(*[]string)
+	defer func() {
+		*keysPtr = (*keysPtr)[:0]
+		keysPool.Put(keysPtr)
+	}()
+	keys := (*keysPtr)[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	*keysPtr = keys
+
+	e.buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeString(k)
+		e.buf.WriteByte(':')
+		if err := e.encodeValue(m[k]); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// encodeObject writes a core.ObjectMarshaler, turning into an error any
+// panic it raises.
+func (e *jsonEncoder) encodeObject(v core.ObjectMarshaler) (err error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		e.buf.WriteString("null")
+		return nil
+	}
+	if e.depth >= maxFastDepth {
+		return e.encodeReflect(v)
+	}
+	depth, first := e.depth, e.first
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while encoding: %v", r)
+		}
+		e.depth, e.first = depth, first
+	}()
+	e.depth++
+	e.first = true
+
+	e.buf.WriteByte('{')
+	if err := v.MarshalLogObject(e); err != nil {
+		return err
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// AddString, AddInt, AddBool, AddStrings, AddObject and AddValue implement
+// core.ObjectEncoder, writing the fields of the object being encoded.
+
+func (e *jsonEncoder) AddString(key, value string) {
+	e.writeFieldKey(key)
+	e.writeValueString(value)
+}
+
+func (e *jsonEncoder) AddInt(key string, value int) {
+	e.writeFieldKey(key)
+	e.writeInt(int64(value))
+}
+
+func (e *jsonEncoder) AddBool(key string, value bool) {
+	e.writeFieldKey(key)
+	e.buf.Write(strconv.AppendBool(e.scratch[:0], value))
+}
+
+func (e *jsonEncoder) AddStrings(key string, values []string) {
+	e.writeFieldKey(key)
+	e.writeStrings(values)
+}
+
+func (e *jsonEncoder) AddObject(key string, value core.ObjectMarshaler) error {
+	e.writeFieldKey(key)
+	return e.encodeObject(value)
+}
+
+func (e *jsonEncoder) AddValue(key string, value any) error {
+	e.writeFieldKey(key)
+	return e.encodeValue(value)
+}
+
+// writeFieldKey writes the key of a field of a core.ObjectMarshaler,
+// preceded by a comma unless it is the first field of the object.
+func (e *jsonEncoder) writeFieldKey(key string) {
+	if !e.first {
+		e.buf.WriteByte(',')
+	}
+	e.first = false
+	e.writeString(key)
+	e.buf.WriteByte(':')
+}
+
+func (e *jsonEncoder) writeStrings(v []string) {
+	if v == nil {
+		e.buf.WriteString("null")
+		return
+	}
+	e.buf.WriteByte('[')
+	for i, s := range e.limitStrings(v) {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeValueString(s)
+	}
+	e.buf.WriteByte(']')
+}
+
+func (e *jsonEncoder) writeInt(v int64) {
+	e.buf.Write(strconv.AppendInt(e.scratch[:0], v, 10))
+}
+
+func (e *jsonEncoder) writeUint(v uint64) {
+	e.buf.Write(strconv.AppendUint(e.scratch[:0], v, 10))
+}
+
+// writeFloat follows the same formatting rules of encoding/json, switching
+// to the exponent notation for very small and very large numbers.
+func (e *jsonEncoder) writeFloat(f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return &json.UnsupportedValueError{
+			Value: reflect.ValueOf(f),
+			Str:   strconv.FormatFloat(f, 'g', -1, bits),
+		}
+	}
+
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b := strconv.AppendFloat(e.scratch[:0], f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	e.buf.Write(b)
+	return nil
+}
+
+// writeValueString writes s as a quoted JSON string, truncated to the string
+// limit. Truncation never splits a multi-byte character.
+func (e *jsonEncoder) writeValueString(s string) {
+	if e.maxStringLength > 0 && len(s) > e.maxStringLength {
+		s = truncateString(s, e.maxStringLength)
+		e.truncated = true
+	}
+	e.writeString(s)
+}
+
+func (e *jsonEncoder) limitStrings(v []string) []string {
+	if e.maxArrayLength > 0 && len(v) > e.maxArrayLength {
+		e.truncated = true
+		return v[:e.maxArrayLength]
+	}
+	return v
+}
+
+func (e *jsonEncoder) limitSlice(v []any) []any {
+	if e.maxArrayLength > 0 && len(v) > e.maxArrayLength {
+		e.truncated = true
+		return v[:e.maxArrayLength]
+	}
+	return v
+}
+
+// truncateString returns the first n bytes of s at most, without splitting
+// a multi-byte character.
+func truncateString(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// writeString writes s as a quoted JSON string, escaping it the same way
+// encoding/json does.
+func (e *jsonEncoder) writeString(s string) {
+	e.buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if !e.needsEscape(b) {
+				i++
+				continue
+			}
+			e.buf.WriteString(s[start:i])
+			switch b {
+			case '\\', '"':
+				e.buf.WriteByte('\\')
+				e.buf.WriteByte(b)
+			case '\b':
+				e.buf.WriteString(`\b`)
+			case '\f':
+				e.buf.WriteString(`\f`)
+			case '\n':
+				e.buf.WriteString(`\n`)
+			case '\r':
+				e.buf.WriteString(`\r`)
+			case '\t':
+				e.buf.WriteString(`\t`)
+			default:
+				e.buf.WriteString(`\u00`)
+				e.buf.WriteByte(hex[b>>4])
+				e.buf.WriteByte(hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			e.buf.WriteString(s[start:i])
+			e.buf.WriteString("\ufffd")
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break JSONP, so encoding/json
+		// escapes them.
+		if c == '\u2028' || c == '\u2029' {
+			e.buf.WriteString(s[start:i])
+			e.buf.WriteString(`\u202`)
+			e.buf.WriteByte(hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.buf.WriteString(s[start:])
+	e.buf.WriteByte('"')
+}
+
+func (e *jsonEncoder) needsEscape(b byte) bool {
+	if b < 0x20 || b == '"' || b == '\\' {
+		return true
+	}
+	return e.escapeHTML && (b == '<' || b == '>' || b == '&')
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKeys sorts keys in place. Entries usually carry a handful of fields,
+// where an insertion sort is faster and, unlike sort.Strings, does not
+// allocate.
+func sortKeys(keys []string) {
+	if len(keys) > 32 {
+		sort.Strings(keys)
+		return
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type customStruct struct {
+	Name    string         `json:"name"`
+	Skipped string         `json:"-"`
+	Empty   string         `json:"empty,omitempty"`
+	Nested  map[string]int `json:"nested"`
+}
+
+// objectStruct is encoded by the fast encoder with MarshalLogObject.
+type objectStruct struct {
+	Name   string        `json:"<name>"`
+	Tags   []string      `json:"tags"`
+	Nested *objectStruct `json:"nested,omitempty"`
+}
+
+func (o objectStruct) MarshalLogObject(encoder core.ObjectEncoder) error {
+	encoder.AddString("<name>", o.Name)
+	encoder.AddStrings("tags", o.Tags)
+	if o.Nested != nil {
+		return encoder.AddObject("nested", o.Nested)
+	}
+	return nil
+}
+
+// panickingObject panics while encoded by the fast encoder.
+type panickingObject struct{}
+
+func (panickingObject) MarshalLogObject(core.ObjectEncoder) error {
+	panic("boom")
+}
+
+func TestFastEncoderEquivalence(t *testing.T) {
+	now := time.Date(2024, 3, 28, 10, 11, 12, 123456789, time.UTC)
+	testCases := []struct {
+		name string
+		data logrus.Fields
+	}{
+		{name: "no fields", data: logrus.Fields{}},
+		{name: "strings", data: logrus.Fields{
+			"plain":     "some value",
+			"html":      "<a href=\"x\">&</a>",
+			"escapes":   "quote \" backslash \\ newline \n tab \t return \r",
+			"control":   "\x00\x01\x1f",
+			"unicode":   "àèìòù 日本語 🚀",
+			"invalid":   "bad \xff utf8",
+			"separator": "line\u2028paragraph\u2029",
+			"":          "empty key",
+			"<key>":     "html in key",
+		}},
+		{name: "numbers", data: logrus.Fields{
+			"int":     -42,
+			"int8":    int8(-8),
+			"int16":   int16(16),
+			"int32":   int32(-32),
+			"int64":   int64(math.MaxInt64),
+			"uint":    uint(42),
+			"uint8":   uint8(8),
+			"uint16":  uint16(16),
+			"uint32":  uint32(32),
+			"uint64":  uint64(math.MaxUint64),
+			"float":   3.14159,
+			"zero":    0.0,
+			"small":   1e-7,
+			"big":     1e21,
+			"neg":     -1.5e-10,
+			"round":   float64(12),
+			"float32": float32(0.1),
+			"f32big":  float32(1e22),
+		}},
+		{name: "other primitives", data: logrus.Fields{
+			"true":     true,
+			"false":    false,
+			"nil":      nil,
+			"duration": 1500 * time.Millisecond,
+			"time":     now,
+			"bytes":    []byte("hello"),
+		}},
+		{name: "collections", data: logrus.Fields{
+			"strings":    []string{"a", "<b>", ""},
+			"nilStrings": []string(nil),
+			"anys":       []any{1, "two", 3.5, nil, map[string]any{"z": 1, "a": 2}},
+			"map":        map[string]any{"b": 1, "a": map[string]any{"d": "x", "c": []any{}}},
+			"fields":     logrus.Fields{"k": "v"},
+			"stringMap":  map[string]string{"z": "1", "a": "<2>"},
+			"nilMap":     map[string]any(nil),
+			"intMap":     map[string]int{"b": 2, "a": 1},
+		}},
+		{name: "errors", data: logrus.Fields{
+			"error": fmt.Errorf("something <bad> happened"),
+		}},
+		{name: "reserved keys are overwritten", data: logrus.Fields{
+			"msg":   "user message",
+			"level": "user level",
+			"time":  "user time",
+		}},
+		{name: "unknown structs", data: logrus.Fields{
+			"struct":  customStruct{Name: "n", Skipped: "s", Nested: map[string]int{"y": 1, "x": 2}},
+			"pointer": &customStruct{Name: "p"},
+		}},
+		{name: "middleware structs", data: logrus.Fields{
+			"reqId": "my-req-id",
+			"http": utils.HTTP{
+				Request: &utils.Request{
+					Method:    "GET",
+					UserAgent: utils.UserAgent{Original: "Mozilla/5.0 <test>"},
+					Headers:   map[string]string{"accept": "text/html", "x-tenant": "<acme>"},
+					Body:      &utils.RequestBody{Content: `{"name":"<me>"}`, Truncated: true},
+				},
+				Response: &utils.Response{
+					StatusCode: 200,
+					Body:       utils.ResponseBody{Bytes: 1234, Content: "{\"id\":1}\n"},
+					Headers:    map[string]string{"content-type": "application/json"},
+				},
+				Route: "/my-req/{id}",
+			},
+			"url": utils.URL{Path: "/my-req", Query: map[string][]string{"foo": {"bar", "<b>"}, "baz": {"1"}, "empty": nil}},
+			"host": utils.Host{
+				Hostname:      "echo-service",
+				ForwardedHost: "my-host",
+				IP:            "127.0.0.1",
+				ProxyChain:    []string{"127.0.0.1", "10.0.0.1"},
+			},
+			"responseTime": float64(12),
+			"trace":        utils.Trace{ID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+			"span":         utils.Span{ID: "00f067aa0ba902b7"},
+		}},
+		{name: "empty middleware structs", data: logrus.Fields{
+			"http":      utils.HTTP{Request: &utils.Request{}, Response: &utils.Response{}},
+			"emptyHTTP": utils.HTTP{},
+			"url":       utils.URL{},
+			"host":      utils.Host{},
+			"trace":     utils.Trace{},
+			"span":      utils.Span{},
+		}},
+		{name: "object marshalers", data: logrus.Fields{
+			"object":  objectStruct{Name: "<a>", Tags: []string{"x"}, Nested: &objectStruct{Name: "b"}},
+			"pointer": &objectStruct{Name: "c"},
+			"nil":     (*objectStruct)(nil),
+		}},
+		{name: "middleware struct pointers", data: logrus.Fields{
+			"http":    &utils.HTTP{Request: &utils.Request{Method: "POST", Body: &utils.RequestBody{}}, Route: "/"},
+			"url":     &utils.URL{Path: "/"},
+			"host":    &utils.Host{IP: "10.0.0.1"},
+			"nilHTTP": (*utils.HTTP)(nil),
+			"nilURL":  (*utils.URL)(nil),
+			"nilHost": (*utils.Host)(nil),
+		}},
+	}
+
+	for _, testCase := range testCases {
+		for _, options := range []JSONFormatter{
+			{},
+			{DisableHTMLEscape: true},
+			{PrettyPrint: true},
+		} {
+			t.Run(fmt.Sprintf("%s %+v", testCase.name, options), func(t *testing.T) {
+				entry := &logrus.Entry{
+					Level:   logrus.WarnLevel,
+					Time:    now,
+					Message: "hello <world> & \"friends\"",
+					Data:    testCase.data,
+				}
+
+				standard := options
+				expected, err := standard.Format(entry)
+				require.NoError(t, err)
+
+				fast := options
+				fast.FastEncoding = true
+				actual, err := fast.Format(entry)
+				require.NoError(t, err)
+
+				require.Equal(t, string(expected), string(actual))
+			})
+		}
+	}
+}
+
+func TestFastEncoder(t *testing.T) {
+	t.Run("writes in the entry buffer", func(t *testing.T) {
+		buffer := bytes.NewBufferString("prefix")
+		entry := &logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    time.Now(),
+			Message: "test",
+			Data:    logrus.Fields{"foo": "bar"},
+			Buffer:  buffer,
+		}
+
+		c := JSONFormatter{FastEncoding: true}
+		result, err := c.Format(entry)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("prefix{\"foo\":\"bar\",\"level\":30,\"msg\":\"test\",\"time\":%d}\n", entry.Time.UnixNano()/int64(1e6)), string(result))
+	})
+
+	t.Run("replaces unsupported values in the entry buffer", func(t *testing.T) {
+		buffer := bytes.NewBufferString("prefix")
+		entry := &logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    time.Now(),
+			Message: "test",
+			Data:    logrus.Fields{"a": "first", "nan": math.NaN(), "z": "last"},
+			Buffer:  buffer,
+		}
+
+		c := JSONFormatter{FastEncoding: true}
+		result, err := c.Format(entry)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(
+			"prefix{\"a\":\"first\",\"level\":30,\"logError\":\"cannot marshal field \\\"nan\\\": json: unsupported value: NaN\",\"msg\":\"test\",\"nan\":\"[unserializable value]\",\"time\":%d,\"z\":\"last\"}\n",
+			entry.Time.UnixNano()/int64(1e6),
+		), string(result))
+	})
+
+	t.Run("replaces panicking object marshalers", func(t *testing.T) {
+		entry := &logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    time.Now(),
+			Message: "test",
+			Data:    logrus.Fields{"a": objectStruct{Name: "first"}, "object": panickingObject{}},
+		}
+
+		c := JSONFormatter{FastEncoding: true}
+		result, err := c.Format(entry)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(
+			"{\"a\":{\"\\u003cname\\u003e\":\"first\",\"tags\":null},\"level\":30,\"logError\":\"cannot marshal field \\\"object\\\": panic while encoding: boom\",\"msg\":\"test\",\"object\":\"[unserializable value]\",\"time\":%d}\n",
+			entry.Time.UnixNano()/int64(1e6),
+		), string(result))
+	})
+
+	t.Run("does not allocate for known types", func(t *testing.T) {
+		entry := benchmarkEntry()
+		entry.Buffer = &bytes.Buffer{}
+		c := JSONFormatter{FastEncoding: true}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			entry.Buffer.Reset()
+			_, _ = c.Format(entry)
+		})
+		require.Zero(t, allocs)
+	})
+}
+
+func benchmarkEntry() *logrus.Entry {
+	return &logrus.Entry{
+		Level:   logrus.InfoLevel,
+		Time:    time.Now(),
+		Message: utils.RequestCompletedMessage,
+		Data: logrus.Fields{
+			"reqId": "16c9c1f2-c001-40d3-bbfe-48857367e7b5",
+			"http": utils.HTTP{
+				Request: &utils.Request{
+					Method:    "GET",
+					UserAgent: utils.UserAgent{Original: "Mozilla/5.0 (X11; Linux x86_64)"},
+				},
+				Response: &utils.Response{
+					StatusCode: 200,
+					Body:       utils.ResponseBody{Bytes: 1234},
+				},
+			},
+			"url": utils.URL{Path: "/api/items?limit=10"},
+			"host": utils.Host{
+				Hostname:      "echo-service",
+				ForwardedHost: "example.com",
+				IP:            "192.168.0.1",
+			},
+			"responseTime": float64(12),
+			"count":        42,
+			"cached":       true,
+		},
+	}
+}
+
+func BenchmarkJSONFormatter(b *testing.B) {
+	for _, benchmark := range []struct {
+		name      string
+		formatter JSONFormatter
+	}{
+		{name: "standard", formatter: JSONFormatter{}},
+		{name: "fast", formatter: JSONFormatter{FastEncoding: true}},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			entry := benchmarkEntry()
+			entry.Buffer = &bytes.Buffer{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				entry.Buffer.Reset()
+				if _, err := benchmark.formatter.Format(entry); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
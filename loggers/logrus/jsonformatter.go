@@ -20,10 +20,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// LogErrorKey is the field describing the values that could not be
+	// serialized in the entry.
+	LogErrorKey = "logError"
+	// UnserializableValue replaces the values that could not be serialized.
+	UnserializableValue = "[unserializable value]"
+	// TruncatedKey is the field set to true when the entry has been
+	// truncated to honor the formatter limits.
+	TruncatedKey = "truncated"
+	// TruncatedValue replaces the values dropped to honor MaxLineBytes.
+	TruncatedValue = "[truncated]"
+)
+
 // JSONFormatter struct formats logs in JSON following Mia-Platform guidelines.
 type JSONFormatter struct {
 	// DisableHTMLEscape allows disabling html escaping in output
@@ -31,12 +46,48 @@ type JSONFormatter struct {
 
 	// PrettyPrint will indent all json logs
 	PrettyPrint bool
+	// FastEncoding writes the fields straight into the entry buffer, in a
+	// stable order and without reflection for primitive types and for the
+	// values implementing core.ObjectMarshaler, like the middleware structs.
+	// The output is the same of the default encoder.
+	FastEncoding bool
+
+	// MaxLineBytes limits the size of each line, newline excluded. The
+	// largest fields are replaced first, then the message is truncated.
+	// Zero means no limit, and limits below MinLineBytes are raised to it.
+	MaxLineBytes int
+	// MaxStringLength limits the bytes of each string value, message
+	// included. Zero means no limit.
+	MaxStringLength int
+	// MaxArrayLength limits the items of each array. Zero means no limit.
+	MaxArrayLength int
+
+	// KeyCollisionPolicy defines how user fields clashing with the keys of
+	// the log schema are handled.
+	KeyCollisionPolicy KeyCollisionPolicy
+	// FieldsKey is the prefix or the parent key used by the collision
+	// policies. Defaults to DefaultFieldsKey.
+	FieldsKey string
+
+	// TypeRegistry, when set, keeps the JSON type of each key consistent
+	// among entries, fixing the conflicting values.
+	TypeRegistry *FieldTypeRegistry
 }
 
 // Format will set how to format entry in JSON
 func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
-	data := make(logrus.Fields, len(entry.Data)+4)
-	for k, v := range entry.Data {
+	fields, errs := f.applyCollisionPolicy(entry.Data)
+	if f.TypeRegistry != nil {
+		fields = f.TypeRegistry.check(fields)
+	}
+
+	// Limits are enforced while encoding, so they always need the fast encoder
+	if f.FastEncoding || f.hasLimits() {
+		return f.formatFast(entry, fields, errs)
+	}
+
+	data := make(logrus.Fields, len(fields)+5)
+	for k, v := range fields {
 		switch v := v.(type) {
 		case error:
 			// Otherwise errors are ignored by `encoding/json`
@@ -50,6 +101,9 @@ func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	data["time"] = entry.Time.UnixNano() / int64(1e6)
 	data["msg"] = entry.Message
 	data["level"] = getLevelFromString(entry.Level)
+	if len(errs) > 0 {
+		data[LogErrorKey] = strings.Join(errs, "; ")
+	}
 
 	var b *bytes.Buffer
 	if entry.Buffer != nil {
@@ -63,30 +117,125 @@ func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	if f.PrettyPrint {
 		encoder.SetIndent("", "  ")
 	}
-	if err := encoder.Encode(data); err != nil {
-		return nil, fmt.Errorf("failed to marshal fields to JSON, %v", err)
+	if err := safeEncode(encoder, data); err != nil {
+		// Replace only the offending values, so that the entry is not lost
+		errs = append(errs, replaceUnserializable(data)...)
+		data[LogErrorKey] = strings.Join(errs, "; ")
+		if err := safeEncode(encoder, data); err != nil {
+			return nil, fmt.Errorf("failed to marshal fields to JSON, %v", err)
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+// replaceUnserializable replaces with a placeholder each field that cannot
+// be serialized, and returns the description of what went wrong.
+func replaceUnserializable(data logrus.Fields) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []string
+	for _, k := range keys {
+		if err := safeEncode(json.NewEncoder(&bytes.Buffer{}), data[k]); err != nil {
+			data[k] = UnserializableValue
+			errs = append(errs, fieldErrorMessage(k, err))
+		}
+	}
+	return errs
+}
+
+// safeEncode encodes v, turning into an error any panic raised by custom
+// marshalers.
+func safeEncode(encoder *json.Encoder, v any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while encoding: %v", r)
+		}
+	}()
+	return encoder.Encode(v)
+}
+
+func fieldErrorMessage(key string, err error) string {
+	return fmt.Sprintf("cannot marshal field %q: %v", key, err)
+}
+
+func (f *JSONFormatter) formatFast(entry *logrus.Entry, data logrus.Fields, errs []string) ([]byte, error) {
+	var b *bytes.Buffer
+	if entry.Buffer != nil {
+		b = entry.Buffer
+	} else {
+		b = &bytes.Buffer{}
+	}
+
+	if f.PrettyPrint {
+		var compact bytes.Buffer
+		f.encodeFast(&compact, entry, data, errs)
+		if err := json.Indent(b, bytes.TrimSpace(compact.Bytes()), "", "  "); err != nil {
+			return nil, fmt.Errorf("failed to marshal fields to JSON, %v", err)
+		}
+		b.WriteByte('\n')
+		return b.Bytes(), nil
 	}
 
+	f.encodeFast(b, entry, data, errs)
 	return b.Bytes(), nil
 }
 
+func (f *JSONFormatter) encodeFast(b *bytes.Buffer, entry *logrus.Entry, data logrus.Fields, errs []string) {
+	encoder := encoderPool.Get().(*jsonEncoder)
+	defer func() {
+		*encoder = jsonEncoder{}
+		encoderPool.Put(encoder)
+	}()
+	*encoder = jsonEncoder{
+		buf:             b,
+		escapeHTML:      !f.DisableHTMLEscape,
+		maxStringLength: f.MaxStringLength,
+		maxArrayLength:  f.MaxArrayLength,
+	}
+	maxLineBytes := f.MaxLineBytes
+	if maxLineBytes > 0 && maxLineBytes < MinLineBytes {
+		maxLineBytes = MinLineBytes
+	}
+	encoder.encodeEntry(entry, data, errs, maxLineBytes)
+}
+
+func (f *JSONFormatter) hasLimits() bool {
+	return f.MaxLineBytes > 0 || f.MaxStringLength > 0 || f.MaxArrayLength > 0
+}
+
+// Numeric levels written by JSONFormatter.
+const (
+	LevelTrace = 10
+	LevelDebug = 20
+	LevelInfo  = 30
+	LevelWarn  = 40
+	LevelError = 50
+	LevelFatal = 60
+	LevelPanic = 70
+)
+
 func getLevelFromString(logLevel logrus.Level) int {
 	switch logLevel {
 	case logrus.TraceLevel:
-		return 10
+		return LevelTrace
 	case logrus.DebugLevel:
-		return 20
+		return LevelDebug
 	case logrus.InfoLevel:
-		return 30
+		return LevelInfo
 	case logrus.WarnLevel:
-		return 40
+		return LevelWarn
 	case logrus.ErrorLevel:
-		return 50
+		return LevelError
 	case logrus.FatalLevel:
-		return 60
+		return LevelFatal
 	case logrus.PanicLevel:
-		return 70
+		return LevelPanic
 	default:
-		return 30
+		return LevelInfo
 	}
 }
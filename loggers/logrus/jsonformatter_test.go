@@ -17,12 +17,15 @@
 package logrus
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/mia-platform/glogger/v4/middleware/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -121,3 +124,256 @@ func TestCustomWriter(t *testing.T) {
 		require.Equal(t, fmt.Sprintf(`{"level":10,"msg":"test with &, < and > encoded","time":%d}`, logEntry.Time.UnixNano()/int64(1e6)), strings.TrimSpace((string(result))))
 	})
 }
+
+type cyclicStruct struct {
+	Name string        `json:"name"`
+	Next *cyclicStruct `json:"next"`
+}
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("marshaler failure")
+}
+
+type panickingMarshaler struct{}
+
+func (panickingMarshaler) MarshalJSON() ([]byte, error) {
+	panic("marshaler panic")
+}
+
+func TestUnserializableFields(t *testing.T) {
+	cyclic := &cyclicStruct{Name: "loop"}
+	cyclic.Next = cyclic
+	cyclicMap := map[string]any{}
+	cyclicMap["self"] = cyclicMap
+
+	testCases := []struct {
+		name          string
+		value         any
+		expectedError string
+	}{
+		{name: "channel", value: make(chan int), expectedError: "json: unsupported type: chan int"},
+		{name: "func", value: func() {}, expectedError: "json: unsupported type: func()"},
+		{name: "complex", value: complex(1, 2), expectedError: "json: unsupported type: complex128"},
+		{name: "cyclic struct", value: cyclic, expectedError: "json: unsupported value: encountered a cycle"},
+		{name: "cyclic map", value: cyclicMap, expectedError: "json: unsupported value: encountered a cycle"},
+		{name: "NaN", value: math.NaN(), expectedError: "json: unsupported value: NaN"},
+		{name: "positive infinity", value: math.Inf(1), expectedError: "json: unsupported value: +Inf"},
+		{name: "negative infinity", value: math.Inf(-1), expectedError: "json: unsupported value: -Inf"},
+		{name: "float32 NaN", value: float32(math.NaN()), expectedError: "json: unsupported value: NaN"},
+		{name: "nested NaN", value: map[string]any{"inner": []any{1, math.NaN()}}, expectedError: "json: unsupported value: NaN"},
+		{name: "failing marshaler", value: failingMarshaler{}, expectedError: "marshaler failure"},
+		{name: "panicking marshaler", value: panickingMarshaler{}, expectedError: "panic while encoding: marshaler panic"},
+	}
+
+	for _, testCase := range testCases {
+		for _, fastEncoding := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s with fast encoding %t", testCase.name, fastEncoding), func(t *testing.T) {
+				c := JSONFormatter{FastEncoding: fastEncoding}
+				logEntry := logrus.Entry{
+					Level:   logrus.InfoLevel,
+					Time:    time.Now(),
+					Message: "the message",
+					Data: logrus.Fields{
+						"bad":   testCase.value,
+						"other": "value",
+						"count": 3,
+					},
+				}
+				result, err := c.Format(&logEntry)
+				require.NoError(t, err)
+
+				var output map[string]any
+				require.NoError(t, json.Unmarshal(result, &output), "output must be valid JSON: %s", result)
+				require.Equal(t, "the message", output["msg"])
+				require.Equal(t, float64(30), output["level"])
+				require.Equal(t, "value", output["other"])
+				require.Equal(t, float64(3), output["count"])
+				require.Equal(t, UnserializableValue, output["bad"])
+				require.Contains(t, output[LogErrorKey], `cannot marshal field "bad": `)
+				require.Contains(t, output[LogErrorKey], testCase.expectedError)
+			})
+		}
+	}
+
+	t.Run("reports every offending field", func(t *testing.T) {
+		for _, fastEncoding := range []bool{false, true} {
+			c := JSONFormatter{FastEncoding: fastEncoding}
+			logEntry := logrus.Entry{
+				Level:   logrus.InfoLevel,
+				Time:    time.Now(),
+				Message: "the message",
+				Data: logrus.Fields{
+					"channel": make(chan int),
+					"nan":     math.NaN(),
+				},
+			}
+			result, err := c.Format(&logEntry)
+			require.NoError(t, err)
+
+			var output map[string]any
+			require.NoError(t, json.Unmarshal(result, &output))
+			require.Equal(t, UnserializableValue, output["channel"])
+			require.Equal(t, UnserializableValue, output["nan"])
+			require.Equal(t,
+				`cannot marshal field "channel": json: unsupported type: chan int; cannot marshal field "nan": json: unsupported value: NaN`,
+				output[LogErrorKey],
+			)
+		}
+	})
+}
+
+type limitedStruct struct {
+	Zeta  string   `json:"zeta"`
+	Alpha []int    `json:"alpha"`
+	Items []string `json:"items"`
+}
+
+func TestLimits(t *testing.T) {
+	now := time.Now()
+	timestamp := now.UnixNano() / int64(1e6)
+
+	format := func(t *testing.T, c JSONFormatter, message string, data logrus.Fields) string {
+		t.Helper()
+		result, err := c.Format(&logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    now,
+			Message: message,
+			Data:    data,
+		})
+		require.NoError(t, err)
+		return string(result)
+	}
+
+	t.Run("no truncation keeps the default output", func(t *testing.T) {
+		data := logrus.Fields{"foo": "bar", "list": []any{1, 2}}
+		limited := format(t, JSONFormatter{MaxLineBytes: 1000, MaxStringLength: 10, MaxArrayLength: 2}, "msg", data)
+		require.Equal(t, format(t, JSONFormatter{}, "msg", data), limited)
+		require.NotContains(t, limited, TruncatedKey)
+	})
+
+	t.Run("truncates strings", func(t *testing.T) {
+		c := JSONFormatter{MaxStringLength: 5}
+		result := format(t, c, "a long message", logrus.Fields{
+			"short":   "abc",
+			"long":    "abcdefghij",
+			"unicode": "àèìòù",
+			"error":   fmt.Errorf("a long error"),
+			"nested":  map[string]any{"a very long key": "a very long value"},
+		})
+		require.Equal(t, fmt.Sprintf(
+			`{"error":"a lon","level":30,"long":"abcde","msg":"a lon","nested":{"a very long key":"a ver"},"short":"abc","time":%d,"truncated":true,"unicode":"àè"}`+"\n",
+			timestamp,
+		), result)
+	})
+
+	t.Run("truncates arrays", func(t *testing.T) {
+		c := JSONFormatter{MaxArrayLength: 2}
+		result := format(t, c, "msg", logrus.Fields{
+			"strings": []string{"a", "b", "c"},
+			"anys":    []any{1, []any{1, 2, 3}, 3},
+			"ints":    []int{1, 2, 3},
+		})
+		require.Equal(t, fmt.Sprintf(
+			`{"anys":[1,[1,2]],"ints":[1,2],"level":30,"msg":"msg","strings":["a","b"],"time":%d,"truncated":true}`+"\n",
+			timestamp,
+		), result)
+	})
+
+	t.Run("limits unknown types keeping their field order", func(t *testing.T) {
+		c := JSONFormatter{MaxArrayLength: 1, MaxStringLength: 3}
+		result := format(t, c, "msg", logrus.Fields{
+			"struct": limitedStruct{
+				Zeta:  "abcdef",
+				Alpha: []int{1, 2},
+				Items: []string{"first", "second"},
+			},
+		})
+		require.Equal(t, fmt.Sprintf(
+			`{"level":30,"msg":"msg","struct":{"zeta":"abc","alpha":[1],"items":["fir"]},"time":%d,"truncated":true}`+"\n",
+			timestamp,
+		), result)
+	})
+
+	t.Run("middleware headers honor the string limit", func(t *testing.T) {
+		c := JSONFormatter{MaxStringLength: 8}
+		result := format(t, c, utils.IncomingRequestMessage, logrus.Fields{
+			"http": utils.HTTP{
+				Request: &utils.Request{
+					Method:    "GET",
+					UserAgent: utils.UserAgent{Original: "Mozilla/5.0 (X11; Linux x86_64)"},
+				},
+			},
+			"host": utils.Host{ForwardedHost: "a-very-long-host.example.com"},
+		})
+		require.Equal(t, fmt.Sprintf(
+			`{"host":{"forwardedHost":"a-very-l"},"http":{"request":{"method":"GET","userAgent":{"original":"Mozilla/"}}},"level":30,"msg":"incoming","time":%d,"truncated":true}`+"\n",
+			timestamp,
+		), result)
+	})
+
+	t.Run("replaces the largest fields to fit the line", func(t *testing.T) {
+		c := JSONFormatter{MaxLineBytes: 160}
+		data := logrus.Fields{
+			"payload": strings.Repeat("x", 200),
+			"other":   strings.Repeat("y", 50),
+			"small":   "z",
+		}
+		result := format(t, c, "msg", data)
+		require.Equal(t, fmt.Sprintf(
+			`{"level":30,"msg":"msg","other":"%s","payload":"[truncated]","small":"z","time":%d,"truncated":true}`+"\n",
+			strings.Repeat("y", 50), timestamp,
+		), result)
+		require.LessOrEqual(t, len(result)-1, 160)
+		require.Equal(t, result, format(t, c, "msg", data), "truncation must be deterministic")
+	})
+
+	t.Run("truncates the message to fit the line", func(t *testing.T) {
+		c := JSONFormatter{MaxLineBytes: 100}
+		result := format(t, c, strings.Repeat("m", 200), logrus.Fields{"payload": strings.Repeat("x", 200)})
+		require.LessOrEqual(t, len(result)-1, 100)
+
+		var output map[string]any
+		require.NoError(t, json.Unmarshal([]byte(result), &output))
+		require.Equal(t, TruncatedValue, output["payload"])
+		require.Equal(t, true, output[TruncatedKey])
+		require.NotEmpty(t, output["msg"])
+		require.True(t, strings.HasPrefix(strings.Repeat("m", 200), output["msg"].(string)))
+	})
+
+	t.Run("fits the line with escaped messages", func(t *testing.T) {
+		messages := []string{
+			strings.Repeat("<", 200),
+			strings.Repeat(`"`, 200),
+			strings.Repeat("\x01", 200),
+			strings.Repeat("a<\"\n\x7f\u2028", 50),
+		}
+		for _, limit := range []int{MinLineBytes, 61, 80, 100} {
+			for _, message := range messages {
+				result := format(t, JSONFormatter{MaxLineBytes: limit}, message, logrus.Fields{"payload": strings.Repeat("x", 200)})
+				require.LessOrEqual(t, len(result)-1, limit)
+
+				var output map[string]any
+				require.NoError(t, json.Unmarshal([]byte(result), &output))
+				require.True(t, strings.HasPrefix(message, output["msg"].(string)))
+			}
+		}
+	})
+
+	t.Run("raises the limits below the minimal entry", func(t *testing.T) {
+		result := format(t, JSONFormatter{MaxLineBytes: 30}, strings.Repeat("<", 200), logrus.Fields{})
+		require.Equal(t, fmt.Sprintf(`{"level":30,"msg":"","time":%d,"truncated":true}`+"\n", timestamp), result)
+		require.Len(t, result, MinLineBytes+1)
+	})
+
+	t.Run("keeps only level, message and time when fields do not fit", func(t *testing.T) {
+		c := JSONFormatter{MaxLineBytes: 80}
+		data := logrus.Fields{}
+		for i := 0; i < 20; i++ {
+			data[fmt.Sprintf("field-%d", i)] = i
+		}
+		result := format(t, c, "msg", data)
+		require.Equal(t, fmt.Sprintf(`{"level":30,"msg":"msg","time":%d,"truncated":true}`+"\n", timestamp), result)
+	})
+}
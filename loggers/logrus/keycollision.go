@@ -0,0 +1,156 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultFieldsKey is the key used by the CollisionPrefix and CollisionNest
+// policies when JSONFormatter.FieldsKey is empty.
+const DefaultFieldsKey = "fields"
+
+// KeyCollisionPolicy defines how JSONFormatter handles user fields whose key
+// is reserved by the log schema.
+//
+// The keys written by the formatter (time, msg, level, logError and
+// truncated) are always reserved. The keys written by the middlewares
+// (reqId, http, url, host and responseTime) are reserved only for values of
+// a different type from the one set by the middlewares.
+type KeyCollisionPolicy int
+
+const (
+	// CollisionOverwrite lets the formatter overwrite the clashing user
+	// fields. It is the default policy.
+	CollisionOverwrite KeyCollisionPolicy = iota
+	// CollisionPrefix renames the clashing user keys prefixing them with
+	// the fields key, e.g. fields.msg.
+	CollisionPrefix
+	// CollisionReject drops the clashing user fields, reporting them in the
+	// logError field.
+	CollisionReject
+	// CollisionNest moves all the user fields under the fields key, leaving
+	// at the top level only the keys of the log schema.
+	CollisionNest
+)
+
+// applyCollisionPolicy returns the fields to encode and the description of
+// the rejected ones. Data is returned as is when there is nothing to change.
+func (f *JSONFormatter) applyCollisionPolicy(data logrus.Fields) (logrus.Fields, []string) {
+	switch f.KeyCollisionPolicy {
+	case CollisionPrefix, CollisionReject:
+		if !hasReservedKeys(data) {
+			return data, nil
+		}
+	case CollisionNest:
+		if len(data) == 0 {
+			return data, nil
+		}
+	default:
+		return data, nil
+	}
+
+	fieldsKey := f.FieldsKey
+	if fieldsKey == "" {
+		fieldsKey = DefaultFieldsKey
+	}
+
+	result := make(logrus.Fields, len(data))
+	var nested logrus.Fields
+	var rejected []string
+	for k, v := range data {
+		reserved := isReservedKey(k, v)
+		switch {
+		case f.KeyCollisionPolicy == CollisionNest && !isSchemaKey(k, v):
+			if nested == nil {
+				nested = make(logrus.Fields, len(data))
+			}
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			nested[k] = v
+		case reserved && f.KeyCollisionPolicy == CollisionPrefix:
+			result[fieldsKey+"."+k] = v
+		case reserved && f.KeyCollisionPolicy == CollisionReject:
+			rejected = append(rejected, k)
+		default:
+			result[k] = v
+		}
+	}
+	if nested != nil {
+		result[fieldsKey] = nested
+	}
+
+	sort.Strings(rejected)
+	for i, k := range rejected {
+		rejected[i] = fmt.Sprintf("field %q rejected: key is reserved", k)
+	}
+	return result, rejected
+}
+
+func hasReservedKeys(data logrus.Fields) bool {
+	for k, v := range data {
+		if isReservedKey(k, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReservedKey reports whether a user field clashes with the log schema.
+func isReservedKey(key string, value any) bool {
+	switch key {
+	case "time", "msg", "level", LogErrorKey, TruncatedKey:
+		return true
+	case core.RequestIDKey, core.HTTPKey, core.URLKey, core.HostKey, core.ResponseTimeKey, core.TraceKey, core.SpanKey:
+		return !isMiddlewareValue(key, value)
+	default:
+		return false
+	}
+}
+
+// isSchemaKey reports whether the field belongs to the log schema, and so it
+// must stay at the top level.
+func isSchemaKey(key string, value any) bool {
+	switch key {
+	case core.RequestIDKey, core.HTTPKey, core.URLKey, core.HostKey, core.ResponseTimeKey, core.TraceKey, core.SpanKey:
+		return isMiddlewareValue(key, value)
+	default:
+		return false
+	}
+}
+
+// isMiddlewareValue reports whether value has the type the middlewares use
+// for key: the structured fields implement core.ObjectMarshaler.
+func isMiddlewareValue(key string, value any) bool {
+	switch key {
+	case core.RequestIDKey:
+		_, ok := value.(string)
+		return ok
+	case core.ResponseTimeKey:
+		_, ok := value.(float64)
+		return ok
+	case core.HTTPKey, core.URLKey, core.HostKey, core.TraceKey, core.SpanKey:
+		_, ok := value.(core.ObjectMarshaler)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCollisionPolicy(t *testing.T) {
+	now := time.Now()
+	timestamp := now.UnixNano() / int64(1e6)
+
+	data := logrus.Fields{
+		"msg":          "user message",
+		"level":        "user level",
+		"custom":       "value",
+		"error":        fmt.Errorf("some error"),
+		"reqId":        "my-req-id",
+		"url":          utils.URL{Path: "/path"},
+		"http":         "not a middleware value",
+		"responseTime": "not a number",
+	}
+
+	testCases := []struct {
+		name      string
+		formatter JSONFormatter
+		expected  string
+	}{
+		{
+			name:      "overwrite is the default",
+			formatter: JSONFormatter{},
+			expected:  `{"custom":"value","error":"some error","http":"not a middleware value","level":30,"msg":"the message","reqId":"my-req-id","responseTime":"not a number","time":%d,"url":{"path":"/path"}}`,
+		},
+		{
+			name:      "prefix",
+			formatter: JSONFormatter{KeyCollisionPolicy: CollisionPrefix},
+			expected:  `{"custom":"value","error":"some error","fields.http":"not a middleware value","fields.level":"user level","fields.msg":"user message","fields.responseTime":"not a number","level":30,"msg":"the message","reqId":"my-req-id","time":%d,"url":{"path":"/path"}}`,
+		},
+		{
+			name:      "prefix with custom fields key",
+			formatter: JSONFormatter{KeyCollisionPolicy: CollisionPrefix, FieldsKey: "user"},
+			expected:  `{"custom":"value","error":"some error","level":30,"msg":"the message","reqId":"my-req-id","time":%d,"url":{"path":"/path"},"user.http":"not a middleware value","user.level":"user level","user.msg":"user message","user.responseTime":"not a number"}`,
+		},
+		{
+			name:      "reject",
+			formatter: JSONFormatter{KeyCollisionPolicy: CollisionReject},
+			expected:  `{"custom":"value","error":"some error","level":30,"logError":"field \"http\" rejected: key is reserved; field \"level\" rejected: key is reserved; field \"msg\" rejected: key is reserved; field \"responseTime\" rejected: key is reserved","msg":"the message","reqId":"my-req-id","time":%d,"url":{"path":"/path"}}`,
+		},
+		{
+			name:      "nest",
+			formatter: JSONFormatter{KeyCollisionPolicy: CollisionNest},
+			expected:  `{"fields":{"custom":"value","error":"some error","http":"not a middleware value","level":"user level","msg":"user message","responseTime":"not a number"},"level":30,"msg":"the message","reqId":"my-req-id","time":%d,"url":{"path":"/path"}}`,
+		},
+		{
+			name:      "nest with custom fields key",
+			formatter: JSONFormatter{KeyCollisionPolicy: CollisionNest, FieldsKey: "data"},
+			expected:  `{"data":{"custom":"value","error":"some error","http":"not a middleware value","level":"user level","msg":"user message","responseTime":"not a number"},"level":30,"msg":"the message","reqId":"my-req-id","time":%d,"url":{"path":"/path"}}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		for _, fastEncoding := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s with fast encoding %t", testCase.name, fastEncoding), func(t *testing.T) {
+				c := testCase.formatter
+				c.FastEncoding = fastEncoding
+				result, err := c.Format(&logrus.Entry{
+					Level:   logrus.InfoLevel,
+					Time:    now,
+					Message: "the message",
+					Data:    data,
+				})
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf(testCase.expected, timestamp)+"\n", string(result))
+			})
+		}
+	}
+
+	t.Run("middleware fields are not reserved", func(t *testing.T) {
+		middlewareData := logrus.Fields{
+			"reqId":        "my-req-id",
+			"http":         &utils.HTTP{Request: &utils.Request{Method: "GET"}},
+			"url":          utils.URL{Path: "/path"},
+			"host":         utils.Host{Hostname: "host"},
+			"responseTime": float64(3),
+		}
+		for _, policy := range []KeyCollisionPolicy{CollisionPrefix, CollisionReject, CollisionNest} {
+			c := JSONFormatter{KeyCollisionPolicy: policy}
+			fields, errs := c.applyCollisionPolicy(middlewareData)
+			require.Empty(t, errs)
+			require.Equal(t, middlewareData, fields)
+		}
+	})
+
+	t.Run("nest without fields", func(t *testing.T) {
+		c := JSONFormatter{KeyCollisionPolicy: CollisionNest}
+		result, err := c.Format(&logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    now,
+			Message: "the message",
+			Data:    logrus.Fields{},
+		})
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(`{"level":30,"msg":"the message","time":%d}`+"\n", timestamp), string(result))
+	})
+
+	t.Run("rejected fields are reported together with unserializable ones", func(t *testing.T) {
+		for _, fastEncoding := range []bool{false, true} {
+			c := JSONFormatter{KeyCollisionPolicy: CollisionReject, FastEncoding: fastEncoding}
+			result, err := c.Format(&logrus.Entry{
+				Level:   logrus.InfoLevel,
+				Time:    now,
+				Message: "the message",
+				Data:    logrus.Fields{"time": "user time", "channel": make(chan int)},
+			})
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(
+				`{"channel":"[unserializable value]","level":30,"logError":"field \"time\" rejected: key is reserved; cannot marshal field \"channel\": json: unsupported type: chan int","msg":"the message","time":%d}`+"\n",
+				timestamp,
+			), string(result))
+		}
+	})
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LokiSinkOptions configures a LokiSink.
+type LokiSinkOptions struct {
+	// URL is the push endpoint, e.g. http://localhost:3100/loki/api/v1/push.
+	URL string
+	// Labels maps the label names to the fields they are taken from. Nested
+	// fields are selected joining the keys with a dot, e.g.
+	// {"route": "http.route"}. Defaults to {"level": "level"}.
+	//
+	// Each distinct set of labels is a stream in Loki: only use fields with
+	// few distinct values, never ids or urls.
+	Labels map[string]string
+	// StaticLabels are added to the labels of each entry, e.g.
+	// {"service": "my-service"}.
+	StaticLabels map[string]string
+	// TenantID is sent as the X-Scope-OrgID header, for multi-tenant Loki.
+	TenantID string
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string
+	// Compress gzips the requests.
+	Compress bool
+	// Timeout limits each request. Defaults to 10s.
+	Timeout time.Duration
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	Batch      BatchOptions
+	Retry      RetryOptions
+}
+
+// LokiSink is an io.Writer pushing the entries to Grafana Loki. The fields
+// configured in Labels become the stream labels, the level with its name
+// (info, error...), and are removed from the line; everything else stays in
+// the line, encoded as JSONFormatter does.
+//
+// Entries are sent in batches from a background goroutine, retrying with
+// backoff when Loki is not available; at most Batch.MaxQueueSize entries are
+// kept in memory.
+type LokiSink struct {
+	*batchSink
+
+	request      httpRequest
+	labels       map[string]string
+	staticLabels map[string]string
+	timeout      time.Duration
+	retry        RetryOptions
+}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// NewLokiSink returns a LokiSink pushing to options.URL.
+func NewLokiSink(options LokiSinkOptions) (*LokiSink, error) {
+	endpoint, err := url.Parse(options.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid Loki URL %q: must be an http or https URL", options.URL)
+	}
+
+	labels := options.Labels
+	if labels == nil {
+		labels = map[string]string{"level": "level"}
+	}
+	for name, field := range labels {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("invalid Loki label name %q", name)
+		}
+		if field == "" {
+			return nil, fmt.Errorf("missing field of Loki label %q", name)
+		}
+	}
+	for name := range options.StaticLabels {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("invalid Loki label name %q", name)
+		}
+	}
+
+	headers := make(map[string]string, len(options.Headers)+1)
+	for name, value := range options.Headers {
+		headers[name] = value
+	}
+	if options.TenantID != "" {
+		headers["X-Scope-OrgID"] = options.TenantID
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	sink := &LokiSink{
+		request: httpRequest{
+			client:      options.HTTPClient,
+			url:         options.URL,
+			contentType: "application/json",
+			headers:     headers,
+			gzip:        options.Compress,
+		},
+		labels:       labels,
+		staticLabels: options.StaticLabels,
+		timeout:      timeout,
+		retry:        options.Retry,
+	}
+	sink.batchSink = newBatchSink(options.Batch, sink.export)
+	return sink, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *LokiSink) export(ctx context.Context, lines [][]byte) error {
+	streams := map[string]*lokiStream{}
+	var keys []string
+	for _, line := range lines {
+		labels, entry := s.entry(decodeRecord(line))
+		key := lokiStreamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		stream.Values = append(stream.Values, entry)
+	}
+
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return fmt.Errorf("failed to encode Loki streams: %w", err)
+	}
+
+	err = withRetry(ctx, s.retry, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		return s.request.post(ctx, body)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push %d entries to Loki: %w", len(lines), err)
+	}
+	return nil
+}
+
+// entry returns the labels of the record and its Loki entry: the timestamp
+// in nanoseconds and the line without the fields used as labels.
+func (s *LokiSink) entry(record sinkRecord) (map[string]string, [2]string) {
+	labels := make(map[string]string, len(s.labels)+len(s.staticLabels))
+	for name, value := range s.staticLabels {
+		labels[name] = value
+	}
+
+	fields := record.entryFields()
+	line := record.line
+	for name, path := range s.labels {
+		value, ok := removeField(fields, path)
+		if !ok {
+			continue
+		}
+		line = nil
+		if path == "level" {
+			labels[name] = levelName(record.level)
+		} else {
+			labels[name] = fieldString(value)
+		}
+	}
+	if len(labels) == 0 {
+		// Loki requires at least a label
+		labels["job"] = programName()
+	}
+
+	if line == nil {
+		line = encodeFields(fields)
+	}
+	return labels, [2]string{strconv.FormatInt(record.time.UnixNano(), 10), string(line)}
+}
+
+// lokiStreamKey identifies a set of labels.
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(strconv.Quote(name))
+		key.WriteString(strconv.Quote(labels[name]))
+	}
+	return key.String()
+}
+
+// removeField removes the field at path, a key or keys of nested objects
+// joined with a dot, returning its value. Objects left empty are removed too.
+func removeField(fields map[string]any, path string) (any, bool) {
+	if value, ok := fields[path]; ok {
+		delete(fields, path)
+		return value, true
+	}
+	key, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+	nested, ok := fields[key].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := removeField(nested, rest)
+	if ok && len(nested) == 0 {
+		delete(fields, key)
+	}
+	return value, ok
+}
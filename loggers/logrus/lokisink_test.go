@@ -0,0 +1,168 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLokiSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	fastRetry := RetryOptions{InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond, MaxElapsedTime: time.Second}
+
+	type push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	decode := func(t *testing.T, body []byte) push {
+		t.Helper()
+		var decoded push
+		require.NoError(t, json.Unmarshal(body, &decoded))
+		return decoded
+	}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewLokiSink(LokiSinkOptions{URL: "localhost:3100"})
+		require.EqualError(t, err, `invalid Loki URL "localhost:3100": must be an http or https URL`)
+
+		_, err = NewLokiSink(LokiSinkOptions{URL: "http://localhost:3100", Labels: map[string]string{"http.route": "route"}})
+		require.EqualError(t, err, `invalid Loki label name "http.route"`)
+
+		_, err = NewLokiSink(LokiSinkOptions{URL: "http://localhost:3100", Labels: map[string]string{"route": ""}})
+		require.EqualError(t, err, `missing field of Loki label "route"`)
+
+		_, err = NewLokiSink(LokiSinkOptions{URL: "http://localhost:3100", StaticLabels: map[string]string{"1st": "x"}})
+		require.EqualError(t, err, `invalid Loki label name "1st"`)
+	})
+
+	t.Run("groups the entries in streams by label", func(t *testing.T) {
+		collector := newCollector(t)
+		sink, err := NewLokiSink(LokiSinkOptions{
+			URL: collector.URL + "/loki/api/v1/push",
+			Labels: map[string]string{
+				"level":   "level",
+				"service": "service",
+				"route":   "http.route",
+			},
+			StaticLabels: map[string]string{"env": "test"},
+			TenantID:     "tenant-1",
+			Headers:      map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			Compress:     true,
+		})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		request := map[string]any{"method": "GET"}
+		logger.WithTime(entryTime).WithFields(logrus.Fields{"service": "api", "http": map[string]any{"request": request, "route": "/users/{id}"}}).Info("first <b>")
+		logger.WithTime(entryTime.Add(time.Millisecond)).WithField("service", "api").Error("second")
+		logger.WithTime(entryTime.Add(2 * time.Millisecond)).WithFields(logrus.Fields{"service": "api", "http": map[string]any{"route": "/users/{id}"}}).Info("third")
+		require.NoError(t, sink.Close())
+
+		requests, bodies := collector.received()
+		require.Len(t, requests, 1)
+		require.Equal(t, "/loki/api/v1/push", requests[0].URL.Path)
+		require.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+		require.Equal(t, "gzip", requests[0].Header.Get("Content-Encoding"))
+		require.Equal(t, "tenant-1", requests[0].Header.Get("X-Scope-OrgID"))
+		require.Equal(t, "Basic dXNlcjpwYXNz", requests[0].Header.Get("Authorization"))
+
+		streams := decode(t, bodies[0]).Streams
+		require.Len(t, streams, 2)
+		require.Equal(t, map[string]string{"env": "test", "level": "info", "service": "api", "route": "/users/{id}"}, streams[0].Stream)
+		require.Equal(t, [][2]string{
+			{"1704189630123000000", `{"http":{"request":{"method":"GET"}},"msg":"first <b>","time":1704189630123}`},
+			{"1704189630125000000", `{"msg":"third","time":1704189630125}`},
+		}, streams[0].Values)
+		require.Equal(t, map[string]string{"env": "test", "level": "error", "service": "api"}, streams[1].Stream)
+		require.Equal(t, [][2]string{
+			{"1704189630124000000", `{"msg":"second","time":1704189630124}`},
+		}, streams[1].Values)
+	})
+
+	t.Run("keeps the line when no label is extracted", func(t *testing.T) {
+		collector := newCollector(t)
+		sink, err := NewLokiSink(LokiSinkOptions{URL: collector.URL, Labels: map[string]string{}})
+		require.NoError(t, err)
+
+		newSinkLogger(sink).WithTime(entryTime).WithField("b", 1).Info("hello")
+		require.NoError(t, sink.Close())
+
+		_, bodies := collector.received()
+		streams := decode(t, bodies[0]).Streams
+		require.Equal(t, map[string]string{"job": programName()}, streams[0].Stream)
+		require.Equal(t, `{"b":1,"level":30,"msg":"hello","time":1704189630123}`, streams[0].Values[0][1])
+	})
+
+	t.Run("retries failed pushes", func(t *testing.T) {
+		collector := newCollector(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+		sink, err := NewLokiSink(LokiSinkOptions{URL: collector.URL, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).Info("hello")
+		require.NoError(t, sink.Flush(context.Background()))
+
+		requests, _ := collector.received()
+		require.Len(t, requests, 1)
+		require.Equal(t, BatchStats{Exported: 1}, sink.Stats())
+	})
+
+	t.Run("does not retry rejected pushes", func(t *testing.T) {
+		collector := newCollector(t, http.StatusBadRequest)
+		sink, err := NewLokiSink(LokiSinkOptions{URL: collector.URL, Retry: fastRetry})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).Info("hello")
+		require.ErrorContains(t, sink.Flush(context.Background()), "failed to push 1 entries to Loki")
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+	})
+}
+
+func TestRemoveField(t *testing.T) {
+	fields := map[string]any{
+		"service.name": "api",
+		"http":         map[string]any{"request": map[string]any{"method": "GET"}, "route": "/", "status": 200},
+	}
+
+	value, ok := removeField(fields, "service.name")
+	require.True(t, ok)
+	require.Equal(t, "api", value)
+
+	value, ok = removeField(fields, "http.route")
+	require.True(t, ok)
+	require.Equal(t, "/", value)
+
+	value, ok = removeField(fields, "http.request.method")
+	require.True(t, ok)
+	require.Equal(t, "GET", value)
+	require.Equal(t, map[string]any{"http": map[string]any{"status": 200}}, fields)
+
+	_, ok = removeField(fields, "http.status.code")
+	require.False(t, ok)
+	_, ok = removeField(fields, "missing")
+	require.False(t, ok)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpack(t *testing.T) {
+	decode := func(t *testing.T, b []byte) any {
+		t.Helper()
+		value, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(b)))
+		require.NoError(t, err)
+		return value
+	}
+
+	testCases := []struct {
+		value    any
+		expected any
+		encoded  []byte
+	}{
+		{value: nil, encoded: []byte{0xc0}},
+		{value: true, encoded: []byte{0xc3}},
+		{value: false, encoded: []byte{0xc2}},
+		{value: 1, expected: int64(1), encoded: []byte{0x01}},
+		{value: -1, expected: int64(-1), encoded: []byte{0xff}},
+		{value: -100, expected: int64(-100), encoded: []byte{0xd0, 0x9c}},
+		{value: 300, expected: int64(300), encoded: []byte{0xd1, 0x01, 0x2c}},
+		{value: int64(math.MaxInt32) + 1, expected: int64(math.MaxInt32) + 1},
+		{value: int64(math.MinInt64), expected: int64(math.MinInt64)},
+		{value: json.Number("42"), expected: int64(42)},
+		{value: json.Number("0.5"), expected: 0.5},
+		{value: 1.5, expected: 1.5},
+		{value: "abc", encoded: []byte{0xa3, 'a', 'b', 'c'}},
+		{value: strings.Repeat("a", 40), expected: strings.Repeat("a", 40)},
+		{value: strings.Repeat("a", 300), expected: strings.Repeat("a", 300)},
+		{value: strings.Repeat("a", 70000), expected: strings.Repeat("a", 70000)},
+		{value: []byte{1, 2}, encoded: []byte{0xc4, 0x02, 0x01, 0x02}},
+		{value: []any{json.Number("1"), "a"}, expected: []any{int64(1), "a"}},
+		{value: make([]any, 20), expected: make([]any, 20)},
+		{value: map[string]any{"b": true, "a": nil}, encoded: []byte{0x82, 0xa1, 'a', 0xc0, 0xa1, 'b', 0xc3}},
+		{value: struct{ A int }{A: 1}, expected: `{"A":1}`},
+		{
+			value:    time.Unix(1704189630, 123000000),
+			expected: msgpackExt{Type: msgpackEventTime, Data: []byte{0x65, 0x93, 0xde, 0xbe, 0x07, 0x54, 0xd4, 0xc0}},
+			encoded:  []byte{0xd7, 0x00, 0x65, 0x93, 0xde, 0xbe, 0x07, 0x54, 0xd4, 0xc0},
+		},
+	}
+	for _, testCase := range testCases {
+		encoded := appendMsgpack(nil, testCase.value)
+		if testCase.encoded != nil {
+			require.Equal(t, testCase.encoded, encoded, "%#v", testCase.value)
+		}
+		expected := testCase.expected
+		if expected == nil {
+			expected = testCase.value
+		}
+		require.Equal(t, expected, decode(t, encoded), "%#v", testCase.value)
+	}
+
+	t.Run("large maps", func(t *testing.T) {
+		value := map[string]any{}
+		for i := 0; i < 20; i++ {
+			value[strings.Repeat("k", i+1)] = int64(i)
+		}
+		require.Equal(t, value, decode(t, appendMsgpack(nil, value)))
+	})
+
+	t.Run("unsupported values", func(t *testing.T) {
+		_, err := decodeMsgpack(bufio.NewReader(bytes.NewReader([]byte{0xc1})))
+		require.EqualError(t, err, "unsupported msgpack type 0xc1")
+
+		_, err = decodeMsgpack(bufio.NewReader(bytes.NewReader([]byte{0x81, 0x01, 0x01})))
+		require.EqualError(t, err, "unsupported msgpack map key of type int64")
+	})
+}
@@ -0,0 +1,389 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"encoding/binary"
+	hexencoding "encoding/hex"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+)
+
+// This file encodes the ExportLogsServiceRequest message of OTLP, in its
+// protobuf and JSON forms, without depending on the generated code of the
+// OpenTelemetry protocol.
+
+const otlpScopeName = "github.com/mia-platform/glogger"
+
+type otlpValueKind int
+
+const (
+	otlpEmpty otlpValueKind = iota
+	otlpString
+	otlpBool
+	otlpInt
+	otlpDouble
+	otlpArray
+	otlpKvlist
+)
+
+// otlpValue is an AnyValue.
+type otlpValue struct {
+	kind    otlpValueKind
+	str     string
+	boolean bool
+	integer int64
+	double  float64
+	array   []otlpValue
+	kvlist  []otlpKeyValue
+}
+
+type otlpKeyValue struct {
+	key   string
+	value otlpValue
+}
+
+type otlpLogRecord struct {
+	timeUnixNano         uint64
+	observedTimeUnixNano uint64
+	severityNumber       int
+	severityText         string
+	body                 otlpValue
+	attributes           []otlpKeyValue
+	traceID              []byte
+	spanID               []byte
+}
+
+// otlpSeverity maps a numeric level to the OTLP severity number and text.
+func otlpSeverity(level int) (int, string) {
+	switch {
+	case level <= LevelTrace:
+		return 1, "TRACE"
+	case level <= LevelDebug:
+		return 5, "DEBUG"
+	case level <= LevelInfo:
+		return 9, "INFO"
+	case level <= LevelWarn:
+		return 13, "WARN"
+	case level <= LevelError:
+		return 17, "ERROR"
+	case level <= LevelFatal:
+		return 21, "FATAL"
+	default:
+		return 22, "PANIC"
+	}
+}
+
+// newOTLPLogRecord converts a record. The ids of the core.TraceIDKey and
+// core.SpanIDKey fields, and the ids of the core.TraceKey and core.SpanKey
+// objects logged by the middlewares, e.g. trace.id, when they are valid hex
+// ids, are moved to the trace context of the record. The top level fields
+// win.
+func newOTLPLogRecord(record sinkRecord, observed uint64) otlpLogRecord {
+	severityNumber, severityText := otlpSeverity(record.level)
+	logRecord := otlpLogRecord{
+		timeUnixNano:         uint64(record.time.UnixNano()),
+		observedTimeUnixNano: observed,
+		severityNumber:       severityNumber,
+		severityText:         severityText,
+		body:                 otlpValue{kind: otlpString, str: record.message},
+	}
+	for _, key := range record.sortedKeys() {
+		value := record.fields[key]
+		switch key {
+		case core.TraceIDKey:
+			if id, ok := otlpID(value, 16); ok {
+				logRecord.traceID = id
+				continue
+			}
+		case core.SpanIDKey:
+			if id, ok := otlpID(value, 8); ok {
+				logRecord.spanID = id
+				continue
+			}
+		case core.TraceKey:
+			if id, rest, ok := otlpObjectID(value, 16); ok {
+				logRecord.traceID = id
+				if value = rest; value == nil {
+					continue
+				}
+			}
+		case core.SpanKey:
+			if id, rest, ok := otlpObjectID(value, 8); ok {
+				logRecord.spanID = id
+				if value = rest; value == nil {
+					continue
+				}
+			}
+		}
+		logRecord.attributes = append(logRecord.attributes, otlpKeyValue{key: key, value: newOTLPValue(value)})
+	}
+	return logRecord
+}
+
+func otlpID(value any, size int) ([]byte, bool) {
+	s, ok := value.(string)
+	if !ok || len(s) != size*2 {
+		return nil, false
+	}
+	id, err := hexencoding.DecodeString(s)
+	return id, err == nil
+}
+
+// otlpObjectID returns the id field of an object, and the other fields, if
+// any.
+func otlpObjectID(value any, size int) ([]byte, any, bool) {
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, nil, false
+	}
+	id, ok := otlpID(object["id"], size)
+	if !ok {
+		return nil, nil, false
+	}
+	if len(object) == 1 {
+		return id, nil, true
+	}
+	rest := make(map[string]any, len(object)-1)
+	for k, v := range object {
+		if k != "id" {
+			rest[k] = v
+		}
+	}
+	return id, rest, true
+}
+
+// newOTLPValue converts a value decoded from JSON with json.Number.
+func newOTLPValue(value any) otlpValue {
+	switch value := value.(type) {
+	case string:
+		return otlpValue{kind: otlpString, str: value}
+	case bool:
+		return otlpValue{kind: otlpBool, boolean: value}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return otlpValue{kind: otlpInt, integer: i}
+		}
+		f, _ := value.Float64()
+		return otlpValue{kind: otlpDouble, double: f}
+	case []any:
+		array := make([]otlpValue, 0, len(value))
+		for _, item := range value {
+			array = append(array, newOTLPValue(item))
+		}
+		return otlpValue{kind: otlpArray, array: array}
+	case map[string]any:
+		return otlpValue{kind: otlpKvlist, kvlist: newOTLPKeyValues(value)}
+	default:
+		return otlpValue{}
+	}
+}
+
+func newOTLPKeyValues(values map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	keyValues := make([]otlpKeyValue, 0, len(values))
+	for _, k := range keys {
+		keyValues = append(keyValues, otlpKeyValue{key: k, value: newOTLPValue(values[k])})
+	}
+	return keyValues
+}
+
+// Protobuf encoding, field numbers from opentelemetry/proto/logs/v1 and
+// opentelemetry/proto/common/v1.
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+func encodeOTLPProtobuf(resource []otlpKeyValue, records []otlpLogRecord) []byte {
+	var scopeLogs []byte
+	scopeLogs = appendProtoBytes(scopeLogs, 1, appendProtoString(nil, 1, otlpScopeName))
+	for _, record := range records {
+		scopeLogs = appendProtoBytes(scopeLogs, 2, appendProtoLogRecord(nil, record))
+	}
+
+	var resourceMessage []byte
+	for _, attribute := range resource {
+		resourceMessage = appendProtoBytes(resourceMessage, 1, appendProtoKeyValue(nil, attribute))
+	}
+
+	var resourceLogs []byte
+	resourceLogs = appendProtoBytes(resourceLogs, 1, resourceMessage)
+	resourceLogs = appendProtoBytes(resourceLogs, 2, scopeLogs)
+
+	return appendProtoBytes(nil, 1, resourceLogs)
+}
+
+func appendProtoLogRecord(b []byte, record otlpLogRecord) []byte {
+	b = appendProtoFixed64(b, 1, record.timeUnixNano)
+	b = appendProtoTag(b, 2, protoVarint)
+	b = appendProtoVarint(b, uint64(record.severityNumber))
+	b = appendProtoString(b, 3, record.severityText)
+	b = appendProtoBytes(b, 5, appendProtoValue(nil, record.body))
+	for _, attribute := range record.attributes {
+		b = appendProtoBytes(b, 6, appendProtoKeyValue(nil, attribute))
+	}
+	if len(record.traceID) > 0 {
+		b = appendProtoBytes(b, 9, record.traceID)
+	}
+	if len(record.spanID) > 0 {
+		b = appendProtoBytes(b, 10, record.spanID)
+	}
+	return appendProtoFixed64(b, 11, record.observedTimeUnixNano)
+}
+
+func appendProtoKeyValue(b []byte, keyValue otlpKeyValue) []byte {
+	b = appendProtoString(b, 1, keyValue.key)
+	return appendProtoBytes(b, 2, appendProtoValue(nil, keyValue.value))
+}
+
+func appendProtoValue(b []byte, value otlpValue) []byte {
+	switch value.kind {
+	case otlpString:
+		b = appendProtoString(b, 1, value.str)
+	case otlpBool:
+		b = appendProtoTag(b, 2, protoVarint)
+		if value.boolean {
+			b = appendProtoVarint(b, 1)
+		} else {
+			b = appendProtoVarint(b, 0)
+		}
+	case otlpInt:
+		b = appendProtoTag(b, 3, protoVarint)
+		b = appendProtoVarint(b, uint64(value.integer))
+	case otlpDouble:
+		b = appendProtoFixed64(b, 4, math.Float64bits(value.double))
+	case otlpArray:
+		var array []byte
+		for _, item := range value.array {
+			array = appendProtoBytes(array, 1, appendProtoValue(nil, item))
+		}
+		b = appendProtoBytes(b, 5, array)
+	case otlpKvlist:
+		var kvlist []byte
+		for _, keyValue := range value.kvlist {
+			kvlist = appendProtoBytes(kvlist, 1, appendProtoKeyValue(nil, keyValue))
+		}
+		b = appendProtoBytes(b, 6, kvlist)
+	}
+	return b
+}
+
+func appendProtoTag(b []byte, field int, wireType int) []byte {
+	return appendProtoVarint(b, uint64(field<<3|wireType))
+}
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoFixed64(b []byte, field int, v uint64) []byte {
+	b = appendProtoTag(b, field, protoFixed64)
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = appendProtoTag(b, field, protoBytes)
+	b = appendProtoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	b = appendProtoTag(b, field, protoBytes)
+	b = appendProtoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// JSON encoding, as defined by the OTLP/HTTP specification: 64 bit integers
+// are strings and trace ids are hex encoded.
+
+func encodeOTLPJSON(resource []otlpKeyValue, records []otlpLogRecord) ([]byte, error) {
+	logRecords := make([]map[string]any, 0, len(records))
+	for _, record := range records {
+		logRecord := map[string]any{
+			"timeUnixNano":         strconv.FormatUint(record.timeUnixNano, 10),
+			"observedTimeUnixNano": strconv.FormatUint(record.observedTimeUnixNano, 10),
+			"severityNumber":       record.severityNumber,
+			"severityText":         record.severityText,
+			"body":                 otlpJSONValue(record.body),
+			"attributes":           otlpJSONKeyValues(record.attributes),
+		}
+		if len(record.traceID) > 0 {
+			logRecord["traceId"] = hexencoding.EncodeToString(record.traceID)
+		}
+		if len(record.spanID) > 0 {
+			logRecord["spanId"] = hexencoding.EncodeToString(record.spanID)
+		}
+		logRecords = append(logRecords, logRecord)
+	}
+
+	return json.Marshal(map[string]any{
+		"resourceLogs": []any{
+			map[string]any{
+				"resource": map[string]any{"attributes": otlpJSONKeyValues(resource)},
+				"scopeLogs": []any{
+					map[string]any{
+						"scope":      map[string]any{"name": otlpScopeName},
+						"logRecords": logRecords,
+					},
+				},
+			},
+		},
+	})
+}
+
+func otlpJSONKeyValues(keyValues []otlpKeyValue) []any {
+	result := make([]any, 0, len(keyValues))
+	for _, keyValue := range keyValues {
+		result = append(result, map[string]any{"key": keyValue.key, "value": otlpJSONValue(keyValue.value)})
+	}
+	return result
+}
+
+func otlpJSONValue(value otlpValue) map[string]any {
+	switch value.kind {
+	case otlpString:
+		return map[string]any{"stringValue": value.str}
+	case otlpBool:
+		return map[string]any{"boolValue": value.boolean}
+	case otlpInt:
+		return map[string]any{"intValue": strconv.FormatInt(value.integer, 10)}
+	case otlpDouble:
+		return map[string]any{"doubleValue": value.double}
+	case otlpArray:
+		values := make([]any, 0, len(value.array))
+		for _, item := range value.array {
+			values = append(values, otlpJSONValue(item))
+		}
+		return map[string]any{"arrayValue": map[string]any{"values": values}}
+	case otlpKvlist:
+		return map[string]any{"kvlistValue": map[string]any{"values": otlpJSONKeyValues(value.kvlist)}}
+	default:
+		return map[string]any{}
+	}
+}
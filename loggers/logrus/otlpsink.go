@@ -0,0 +1,165 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Encodings supported by OTLPSink.
+const (
+	OTLPEncodingProtobuf = "protobuf"
+	OTLPEncodingJSON     = "json"
+)
+
+// OTLPSinkOptions configures an OTLPSink.
+type OTLPSinkOptions struct {
+	// Endpoint is the URL of the collector logs endpoint, e.g.
+	// http://localhost:4318/v1/logs.
+	Endpoint string
+	// Encoding is protobuf (the default) or json.
+	Encoding string
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string
+	// Compress gzips the requests.
+	Compress bool
+	// ResourceAttributes describe the service writing the entries. The
+	// service.name attribute defaults to the name of the executable.
+	ResourceAttributes map[string]string
+	// Timeout limits each request. Defaults to 10s.
+	Timeout time.Duration
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	Batch      BatchOptions
+	Retry      RetryOptions
+}
+
+// OTLPSink is an io.Writer exporting the entries to an OpenTelemetry
+// collector with the OTLP/HTTP protocol. Entries are sent in batches from a
+// background goroutine, retrying with backoff when the collector is not
+// available; at most Batch.MaxQueueSize entries are kept in memory.
+//
+// Flush sends the queued entries, while Shutdown (or Close) sends them and
+// stops the sink: call it before the application exits to lose no entries.
+type OTLPSink struct {
+	*batchSink
+
+	request  httpRequest
+	encoding string
+	resource []otlpKeyValue
+	timeout  time.Duration
+	retry    RetryOptions
+}
+
+// NewOTLPSink returns an OTLPSink exporting to options.Endpoint.
+func NewOTLPSink(options OTLPSinkOptions) (*OTLPSink, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", options.Endpoint)
+	}
+
+	contentType := "application/x-protobuf"
+	switch options.Encoding {
+	case "", OTLPEncodingProtobuf:
+		options.Encoding = OTLPEncodingProtobuf
+	case OTLPEncodingJSON:
+		contentType = "application/json"
+	default:
+		return nil, fmt.Errorf("unsupported OTLP encoding %q", options.Encoding)
+	}
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	sink := &OTLPSink{
+		request: httpRequest{
+			client:      options.HTTPClient,
+			url:         options.Endpoint,
+			contentType: contentType,
+			headers:     options.Headers,
+			gzip:        options.Compress,
+		},
+		encoding: options.Encoding,
+		resource: otlpResource(options.ResourceAttributes),
+		timeout:  timeout,
+		retry:    options.Retry,
+	}
+	sink.batchSink = newBatchSink(options.Batch, sink.export)
+	return sink, nil
+}
+
+func (s *OTLPSink) export(ctx context.Context, lines [][]byte) error {
+	observed := uint64(time.Now().UnixNano())
+	records := make([]otlpLogRecord, 0, len(lines))
+	for _, line := range lines {
+		records = append(records, newOTLPLogRecord(decodeRecord(line), observed))
+	}
+
+	var body []byte
+	if s.encoding == OTLPEncodingJSON {
+		var err error
+		if body, err = encodeOTLPJSON(s.resource, records); err != nil {
+			return fmt.Errorf("failed to encode OTLP logs: %w", err)
+		}
+	} else {
+		body = encodeOTLPProtobuf(s.resource, records)
+	}
+
+	err := withRetry(ctx, s.retry, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		return s.request.post(ctx, body)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export %d entries to OTLP collector: %w", len(lines), err)
+	}
+	return nil
+}
+
+func otlpResource(attributes map[string]string) []otlpKeyValue {
+	resource := map[string]string{
+		"service.name": programName(),
+		"process.pid":  strconv.Itoa(os.Getpid()),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		resource["host.name"] = hostname
+	}
+	for key, value := range attributes {
+		resource[key] = value
+	}
+
+	keys := make([]string, 0, len(resource))
+	for key := range resource {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	keyValues := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		keyValues = append(keyValues, otlpKeyValue{key: key, value: otlpValue{kind: otlpString, str: resource[key]}})
+	}
+	return keyValues
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	hexencoding "encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// collector is a stand-in for an HTTP backend, recording the requests.
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	// responses are the status codes returned to the requests, then 200.
+	responses []int
+}
+
+func newCollector(t *testing.T, responses ...int) *collector {
+	t.Helper()
+	c := &collector{responses: responses}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			reader = gz
+		}
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		c.mu.Lock()
+		status := http.StatusOK
+		if len(c.responses) > 0 {
+			status, c.responses = c.responses[0], c.responses[1:]
+		}
+		if status == http.StatusOK {
+			c.requests = append(c.requests, r)
+			c.bodies = append(c.bodies, body)
+		}
+		c.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) received() ([]*http.Request, [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*http.Request(nil), c.requests...), append([][]byte(nil), c.bodies...)
+}
+
+func TestOTLPSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	fastRetry := RetryOptions{InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond, MaxElapsedTime: time.Second}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewOTLPSink(OTLPSinkOptions{Endpoint: "localhost:4318"})
+		require.EqualError(t, err, `invalid OTLP endpoint "localhost:4318": must be an http or https URL`)
+
+		_, err = NewOTLPSink(OTLPSinkOptions{Endpoint: "http://localhost:4318/v1/logs", Encoding: "xml"})
+		require.EqualError(t, err, `unsupported OTLP encoding "xml"`)
+	})
+
+	t.Run("exports JSON batches", func(t *testing.T) {
+		collector := newCollector(t)
+		sink, err := NewOTLPSink(OTLPSinkOptions{
+			Endpoint:           collector.URL + "/v1/logs",
+			Encoding:           OTLPEncodingJSON,
+			Headers:            map[string]string{"Authorization": "Bearer token"},
+			Compress:           true,
+			ResourceAttributes: map[string]string{"service.name": "my-service", "deployment.environment": "test"},
+			Batch:              BatchOptions{MaxBatchSize: 2, FlushInterval: time.Hour},
+		})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).WithFields(logrus.Fields{
+			core.TraceIDKey: "4bf92f3577b34da6a3ce929d0e0e4736",
+			core.SpanIDKey:  "00f067aa0ba902b7",
+			"count":         3,
+			"ratio":         0.5,
+			"ok":            true,
+			"tags":          []string{"a"},
+			"http":          map[string]any{"status": 200},
+			"empty":         nil,
+		}).Warn("first")
+		logger.WithTime(entryTime).Error("second")
+		logger.WithTime(entryTime).WithField(core.TraceIDKey, "not-an-id").Info("third")
+
+		require.Eventually(t, func() bool {
+			requests, _ := collector.received()
+			return len(requests) == 1
+		}, 5*time.Second, 10*time.Millisecond, "a full batch is sent without waiting")
+		require.NoError(t, sink.Flush(context.Background()))
+
+		requests, bodies := collector.received()
+		require.Len(t, requests, 2)
+		require.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+		require.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+		require.Equal(t, "/v1/logs", requests[0].URL.Path)
+
+		var first map[string]any
+		require.NoError(t, json.Unmarshal(bodies[0], &first))
+		resourceLogs := first["resourceLogs"].([]any)[0].(map[string]any)
+		resource := resourceLogs["resource"].(map[string]any)["attributes"].([]any)
+		require.Contains(t, resource, map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "my-service"}})
+		require.Contains(t, resource, map[string]any{"key": "deployment.environment", "value": map[string]any{"stringValue": "test"}})
+		scopeLogs := resourceLogs["scopeLogs"].([]any)[0].(map[string]any)
+		require.Equal(t, map[string]any{"name": otlpScopeName}, scopeLogs["scope"])
+
+		records := scopeLogs["logRecords"].([]any)
+		require.Len(t, records, 2)
+		record := records[0].(map[string]any)
+		require.NotEmpty(t, record["observedTimeUnixNano"])
+		delete(record, "observedTimeUnixNano")
+		require.Equal(t, map[string]any{
+			"timeUnixNano":   "1704189630123000000",
+			"severityNumber": float64(13),
+			"severityText":   "WARN",
+			"body":           map[string]any{"stringValue": "first"},
+			"traceId":        "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId":         "00f067aa0ba902b7",
+			"attributes": []any{
+				map[string]any{"key": "count", "value": map[string]any{"intValue": "3"}},
+				map[string]any{"key": "empty", "value": map[string]any{}},
+				map[string]any{"key": "http", "value": map[string]any{"kvlistValue": map[string]any{"values": []any{
+					map[string]any{"key": "status", "value": map[string]any{"intValue": "200"}},
+				}}}},
+				map[string]any{"key": "ok", "value": map[string]any{"boolValue": true}},
+				map[string]any{"key": "ratio", "value": map[string]any{"doubleValue": 0.5}},
+				map[string]any{"key": "tags", "value": map[string]any{"arrayValue": map[string]any{"values": []any{
+					map[string]any{"stringValue": "a"},
+				}}}},
+			},
+		}, record)
+		require.Equal(t, "ERROR", records[1].(map[string]any)["severityText"])
+
+		var second map[string]any
+		require.NoError(t, json.Unmarshal(bodies[1], &second))
+		third := second["resourceLogs"].([]any)[0].(map[string]any)["scopeLogs"].([]any)[0].(map[string]any)["logRecords"].([]any)[0].(map[string]any)
+		require.NotContains(t, third, "traceId")
+		require.Equal(t, []any{map[string]any{"key": core.TraceIDKey, "value": map[string]any{"stringValue": "not-an-id"}}}, third["attributes"])
+
+		require.NoError(t, sink.Close())
+		require.Equal(t, BatchStats{Exported: 3}, sink.Stats())
+	})
+
+	t.Run("exports protobuf", func(t *testing.T) {
+		collector := newCollector(t)
+		sink, err := NewOTLPSink(OTLPSinkOptions{
+			Endpoint:           collector.URL,
+			ResourceAttributes: map[string]string{"service.name": "my-service"},
+		})
+		require.NoError(t, err)
+
+		newSinkLogger(sink).WithTime(entryTime).WithFields(logrus.Fields{
+			"count": -3,
+			"ratio": 0.5,
+			"ok":    true,
+		}).Info("hello")
+		require.NoError(t, sink.Close())
+
+		requests, bodies := collector.received()
+		require.Len(t, requests, 1)
+		require.Equal(t, "application/x-protobuf", requests[0].Header.Get("Content-Type"))
+
+		request := decodeProto(t, bodies[0])
+		resourceLogs := decodeProto(t, request[1][0].([]byte))
+		resource := decodeProto(t, resourceLogs[1][0].([]byte))
+		require.Contains(t, protoKeyValues(t, resource[1]), "service.name=my-service")
+
+		scopeLogs := decodeProto(t, resourceLogs[2][0].([]byte))
+		scope := decodeProto(t, scopeLogs[1][0].([]byte))
+		require.Equal(t, otlpScopeName, string(scope[1][0].([]byte)))
+
+		require.Len(t, scopeLogs[2], 1)
+		record := decodeProto(t, scopeLogs[2][0].([]byte))
+		require.Equal(t, uint64(entryTime.UnixNano()), record[1][0])
+		require.Equal(t, uint64(9), record[2][0])
+		require.Equal(t, "INFO", string(record[3][0].([]byte)))
+		require.Equal(t, "hello", string(decodeProto(t, record[5][0].([]byte))[1][0].([]byte)))
+		require.NotZero(t, record[11][0])
+		require.Equal(t, []string{"count=-3", "ok=true", "ratio=0.5"}, protoKeyValues(t, record[6]))
+	})
+
+	t.Run("retries with backoff", func(t *testing.T) {
+		collector := newCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		sink, err := NewOTLPSink(OTLPSinkOptions{Endpoint: collector.URL, Retry: fastRetry})
+		require.NoError(t, err)
+
+		newSinkLogger(sink).Info("hello")
+		require.NoError(t, sink.Flush(context.Background()))
+		requests, _ := collector.received()
+		require.Len(t, requests, 1)
+		require.NoError(t, sink.Close())
+		require.Equal(t, BatchStats{Exported: 1}, sink.Stats())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		collector := newCollector(t, http.StatusBadRequest)
+		sink, err := NewOTLPSink(OTLPSinkOptions{Endpoint: collector.URL, Retry: fastRetry})
+		require.NoError(t, err)
+
+		newSinkLogger(sink).Info("hello")
+		err = sink.Flush(context.Background())
+		require.ErrorContains(t, err, "failed to export 1 entries to OTLP collector")
+		require.ErrorContains(t, err, "400 Bad Request")
+		require.NoError(t, sink.Close())
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+	})
+
+	t.Run("bounds the queued entries", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+
+		sink, err := NewOTLPSink(OTLPSinkOptions{
+			Endpoint: server.URL,
+			Batch:    BatchOptions{MaxBatchSize: 1, MaxQueueSize: 2},
+		})
+		require.NoError(t, err)
+
+		logger := newSinkLogger(sink)
+		for i := 0; i < 10; i++ {
+			logger.Info("hello")
+		}
+		close(release)
+		require.NoError(t, sink.Close())
+
+		stats := sink.Stats()
+		require.Equal(t, uint64(10), stats.Exported+stats.Dropped)
+		require.GreaterOrEqual(t, stats.Dropped, uint64(7), "at most one entry in flight and two queued")
+	})
+
+	t.Run("shutdown aborts the pending requests when the context expires", func(t *testing.T) {
+		collector := newCollector(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		sink, err := NewOTLPSink(OTLPSinkOptions{
+			Endpoint: collector.URL,
+			Retry:    RetryOptions{InitialInterval: time.Hour, MaxElapsedTime: 2 * time.Hour},
+		})
+		require.NoError(t, err)
+		newSinkLogger(sink).Info("hello")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, sink.Shutdown(ctx), context.DeadlineExceeded)
+		require.Equal(t, BatchStats{Failed: 1}, sink.Stats())
+
+		_, err = sink.Write([]byte("{}\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+		require.NoError(t, sink.Flush(context.Background()))
+	})
+
+	t.Run("InitHelper and Shutdown", func(t *testing.T) {
+		collector := newCollector(t)
+		logger, err := InitHelper(InitOptions{OTLP: &OTLPSinkOptions{
+			Endpoint: collector.URL,
+			Batch:    BatchOptions{FlushInterval: time.Hour},
+		}})
+		require.NoError(t, err)
+
+		logger.Info("hello")
+		require.NoError(t, Shutdown(context.Background(), logger))
+		requests, _ := collector.received()
+		require.Len(t, requests, 1)
+	})
+}
+
+func TestNewOTLPLogRecord(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	format := func(fields logrus.Fields) sinkRecord {
+		line, err := (&JSONFormatter{}).Format(&logrus.Entry{Level: logrus.InfoLevel, Time: entryTime, Message: "hello", Data: fields})
+		require.NoError(t, err)
+		return decodeRecord(line)
+	}
+
+	t.Run("reads the trace context of the middlewares", func(t *testing.T) {
+		record := newOTLPLogRecord(format(logrus.Fields{
+			core.TraceKey: utils.Trace{ID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+			core.SpanKey:  utils.Span{ID: "00f067aa0ba902b7"},
+		}), 0)
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hexencoding.EncodeToString(record.traceID))
+		require.Equal(t, "00f067aa0ba902b7", hexencoding.EncodeToString(record.spanID))
+		require.Empty(t, record.attributes)
+	})
+
+	t.Run("keeps the other fields of the trace objects", func(t *testing.T) {
+		record := newOTLPLogRecord(format(logrus.Fields{
+			core.TraceKey: map[string]any{"id": "4bf92f3577b34da6a3ce929d0e0e4736", "sampled": true},
+			core.SpanKey:  map[string]any{"id": "not-an-id"},
+		}), 0)
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hexencoding.EncodeToString(record.traceID))
+		require.Nil(t, record.spanID)
+		require.Equal(t, []otlpKeyValue{
+			{key: core.SpanKey, value: otlpValue{kind: otlpKvlist, kvlist: []otlpKeyValue{{key: "id", value: otlpValue{kind: otlpString, str: "not-an-id"}}}}},
+			{key: core.TraceKey, value: otlpValue{kind: otlpKvlist, kvlist: []otlpKeyValue{{key: "sampled", value: otlpValue{kind: otlpBool, boolean: true}}}}},
+		}, record.attributes)
+	})
+
+	t.Run("prefers the top level ids", func(t *testing.T) {
+		record := newOTLPLogRecord(format(logrus.Fields{
+			core.TraceKey:   utils.Trace{ID: "0000000000000000a3ce929d0e0e4736"},
+			core.TraceIDKey: "4bf92f3577b34da6a3ce929d0e0e4736",
+		}), 0)
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hexencoding.EncodeToString(record.traceID))
+		require.Empty(t, record.attributes)
+	})
+}
+
+// decodeProto decodes a protobuf message, returning the values of each
+// field: uint64 for varint and fixed64 fields, []byte for the others.
+func decodeProto(t *testing.T, b []byte) map[int][]any {
+	t.Helper()
+	fields := map[int][]any{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		require.Positive(t, n)
+		b = b[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case protoVarint:
+			value, n := binary.Uvarint(b)
+			require.Positive(t, n)
+			b = b[n:]
+			fields[field] = append(fields[field], value)
+		case protoFixed64:
+			fields[field] = append(fields[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			require.Positive(t, n)
+			b = b[n:]
+			fields[field] = append(fields[field], b[:size])
+			b = b[size:]
+		default:
+			require.Fail(t, "unexpected wire type")
+		}
+	}
+	return fields
+}
+
+// protoKeyValues decodes KeyValue messages with scalar values as key=value.
+func protoKeyValues(t *testing.T, messages []any) []string {
+	t.Helper()
+	var result []string
+	for _, message := range messages {
+		keyValue := decodeProto(t, message.([]byte))
+		value := decodeProto(t, keyValue[2][0].([]byte))
+		var s string
+		switch {
+		case value[1] != nil:
+			s = string(value[1][0].([]byte))
+		case value[2] != nil:
+			s = map[uint64]string{0: "false", 1: "true"}[value[2][0].(uint64)]
+		case value[3] != nil:
+			s = strconv.FormatInt(int64(value[3][0].(uint64)), 10)
+		case value[4] != nil:
+			s = strconv.FormatFloat(math.Float64frombits(value[4][0].(uint64)), 'g', -1, 64)
+		}
+		result = append(result, string(keyValue[1][0].([]byte))+"="+s)
+	}
+	return result
+}
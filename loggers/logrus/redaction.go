@@ -0,0 +1,221 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRedactionMask replaces the redacted values when no mask is set. It
+// is the same mask of the middlewares.
+const DefaultRedactionMask = core.DefaultRedactionMask
+
+// RedactionRules describes the sensitive data to mask in the entries.
+type RedactionRules struct {
+	// Keys are the field keys whose value is masked, at any nesting level
+	// inside maps. Matching is case-insensitive. The bodies logged by the
+	// middlewares are strings: their fields are masked by the
+	// SensitiveBodyFields middleware option instead.
+	Keys []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	// Patterns are regular expressions masked inside the message and the
+	// string values of the fields.
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+	// Mask replaces the redacted data. Defaults to DefaultRedactionMask.
+	Mask string `json:"mask,omitempty" yaml:"mask,omitempty"`
+}
+
+// IsEmpty reports whether the rules do not redact anything.
+func (r RedactionRules) IsEmpty() bool {
+	return len(r.Keys) == 0 && len(r.Patterns) == 0
+}
+
+// Redactor masks sensitive data following a set of RedactionRules.
+type Redactor struct {
+	keys     map[string]struct{}
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+// NewRedactor compiles the rules. It returns an error if a pattern is not a
+// valid regular expression.
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	redactor := &Redactor{
+		keys: make(map[string]struct{}, len(rules.Keys)),
+		mask: rules.Mask,
+	}
+	if redactor.mask == "" {
+		redactor.mask = DefaultRedactionMask
+	}
+	for _, key := range rules.Keys {
+		redactor.keys[strings.ToLower(key)] = struct{}{}
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		redactor.patterns = append(redactor.patterns, re)
+	}
+	return redactor, nil
+}
+
+// RedactString masks the patterns inside s.
+func (r *Redactor) RedactString(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, r.mask)
+	}
+	return s
+}
+
+// RedactFields masks the sensitive values of data in place. Nested maps are
+// copied before being changed, since they may be shared with the caller.
+func (r *Redactor) RedactFields(data logrus.Fields) {
+	for k, v := range data {
+		if redacted, changed := r.redactValue(k, v); changed {
+			data[k] = redacted
+		}
+	}
+}
+
+func (r *Redactor) redactValue(key string, value any) (any, bool) {
+	if _, ok := r.keys[strings.ToLower(key)]; ok {
+		return r.mask, true
+	}
+	if len(r.patterns) == 0 && len(r.keys) == 0 {
+		return value, false
+	}
+
+	switch value := value.(type) {
+	case string:
+		redacted := r.RedactString(value)
+		return redacted, redacted != value
+	case error:
+		message := value.Error()
+		redacted := r.RedactString(message)
+		return redacted, redacted != message
+	case []string:
+		return r.redactStrings(value)
+	case map[string]string:
+		return r.redactStringMap(value)
+	case core.Redactable:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Pointer {
+			return value.Redact(r)
+		}
+		if rv.IsNil() {
+			return value, false
+		}
+		// keep the pointers, for the values redacted with value receivers
+		result, changed := value.Redact(r)
+		if resultValue := reflect.ValueOf(result); changed && resultValue.Type() == rv.Type().Elem() {
+			pointer := reflect.New(resultValue.Type())
+			pointer.Elem().Set(resultValue)
+			return pointer.Interface(), true
+		}
+		return result, changed
+	case map[string]any:
+		return r.redactMap(value)
+	case logrus.Fields:
+		if result, changed := r.redactMap(value); changed {
+			return logrus.Fields(result), true
+		}
+	}
+	return value, false
+}
+
+func (r *Redactor) redactMap(value map[string]any) (map[string]any, bool) {
+	var result map[string]any
+	for k, v := range value {
+		redacted, changed := r.redactValue(k, v)
+		if !changed {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]any, len(value))
+			for k, v := range value {
+				result[k] = v
+			}
+		}
+		result[k] = redacted
+	}
+	return result, result != nil
+}
+
+// RedactStringMap masks the values of m by their key and content,
+// returning a copy and true when something is masked.
+func (r *Redactor) RedactStringMap(m map[string]string) (map[string]string, bool) {
+	return r.redactStringMap(m)
+}
+
+func (r *Redactor) redactStringMap(value map[string]string) (map[string]string, bool) {
+	var result map[string]string
+	for k, v := range value {
+		redacted, changed := r.redactValue(k, v)
+		if !changed {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string, len(value))
+			for k, v := range value {
+				result[k] = v
+			}
+		}
+		result[k] = redacted.(string)
+	}
+	return result, result != nil
+}
+
+func (r *Redactor) redactStrings(value []string) ([]string, bool) {
+	var result []string
+	for i, s := range value {
+		redacted := r.RedactString(s)
+		if redacted == s {
+			continue
+		}
+		if result == nil {
+			result = append([]string(nil), value...)
+		}
+		result[i] = redacted
+	}
+	return result, result != nil
+}
+
+// RedactionHook is a logrus hook masking the sensitive data of each entry
+// before it is formatted, whatever the formatter is. Hooks added before it
+// see the entries unredacted.
+type RedactionHook struct {
+	Redactor *Redactor
+}
+
+// Levels returns all the levels, since every entry has to be redacted.
+func (h *RedactionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the entry. Logrus passes to hooks a copy of the entry, so
+// the fields of the caller are not changed.
+func (h *RedactionHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.Redactor.RedactString(entry.Message)
+	h.Redactor.RedactFields(entry.Data)
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor(t *testing.T) {
+	t.Run("rejects invalid patterns", func(t *testing.T) {
+		redactor, err := NewRedactor(RedactionRules{Patterns: []string{"("}})
+		require.Nil(t, redactor)
+		require.ErrorContains(t, err, `invalid redaction pattern "("`)
+	})
+
+	t.Run("masks keys and patterns", func(t *testing.T) {
+		redactor, err := NewRedactor(RedactionRules{
+			Keys:     []string{"password", "Authorization"},
+			Patterns: []string{`\d{4}-\d{4}-\d{4}-\d{4}`},
+		})
+		require.NoError(t, err)
+
+		nested := map[string]any{"PASSWORD": "secret", "name": "user"}
+		headers := map[string]string{"authorization": "Bearer token", "accept": "*/*"}
+		data := logrus.Fields{
+			"password": "secret",
+			"card":     "paid with 1234-5678-9012-3456",
+			"err":      fmt.Errorf("card 1234-5678-9012-3456 refused"),
+			"cards":    []string{"1234-5678-9012-3456", "none"},
+			"user":     nested,
+			"headers":  headers,
+			"fields":   logrus.Fields{"password": 1},
+			"count":    3,
+		}
+		redactor.RedactFields(data)
+
+		require.Equal(t, logrus.Fields{
+			"password": DefaultRedactionMask,
+			"card":     "paid with [REDACTED]",
+			"err":      "card [REDACTED] refused",
+			"cards":    []string{DefaultRedactionMask, "none"},
+			"user":     map[string]any{"PASSWORD": DefaultRedactionMask, "name": "user"},
+			"headers":  map[string]string{"authorization": DefaultRedactionMask, "accept": "*/*"},
+			"fields":   logrus.Fields{"password": DefaultRedactionMask},
+			"count":    3,
+		}, data)
+		require.Equal(t, "secret", nested["PASSWORD"], "nested maps are not modified")
+		require.Equal(t, "Bearer token", headers["authorization"], "nested maps are not modified")
+	})
+
+	t.Run("masks the middleware headers", func(t *testing.T) {
+		redactor, err := NewRedactor(RedactionRules{
+			Keys:     []string{"x-tenant"},
+			Patterns: []string{`token-\w+`},
+		})
+		require.NoError(t, err)
+
+		request := &utils.Request{Method: "GET", Headers: map[string]string{"x-tenant": "acme", "accept": "*/*"}}
+		response := &utils.Response{StatusCode: 200, Headers: map[string]string{"location": "/login?t=token-abc"}}
+		body := &utils.Request{Body: &utils.RequestBody{Content: `{"token":"token-xyz"}`, Truncated: true}}
+		responseBody := &utils.Response{Body: utils.ResponseBody{Bytes: 9, Content: "token-abc"}}
+		data := logrus.Fields{
+			"http":    utils.HTTP{Request: request, Response: response},
+			"pointer": &utils.HTTP{Request: request},
+			"nilHTTP": (*utils.HTTP)(nil),
+			"bodies":  utils.HTTP{Request: body, Response: responseBody},
+		}
+		redactor.RedactFields(data)
+
+		require.Equal(t, logrus.Fields{
+			"http": utils.HTTP{
+				Request:  &utils.Request{Method: "GET", Headers: map[string]string{"x-tenant": DefaultRedactionMask, "accept": "*/*"}},
+				Response: &utils.Response{StatusCode: 200, Headers: map[string]string{"location": "/login?t=[REDACTED]"}},
+			},
+			"pointer": &utils.HTTP{
+				Request: &utils.Request{Method: "GET", Headers: map[string]string{"x-tenant": DefaultRedactionMask, "accept": "*/*"}},
+			},
+			"nilHTTP": (*utils.HTTP)(nil),
+			"bodies": utils.HTTP{
+				Request:  &utils.Request{Body: &utils.RequestBody{Content: `{"token":"[REDACTED]"}`, Truncated: true}},
+				Response: &utils.Response{Body: utils.ResponseBody{Bytes: 9, Content: DefaultRedactionMask}},
+			},
+		}, data)
+		require.Equal(t, `{"token":"token-xyz"}`, body.Body.Content, "the middleware structs are not modified")
+		require.Equal(t, "token-abc", responseBody.Body.Content, "the middleware structs are not modified")
+		require.Equal(t, "acme", request.Headers["x-tenant"], "the middleware structs are not modified")
+		require.Equal(t, "/login?t=token-abc", response.Headers["location"], "the middleware structs are not modified")
+	})
+
+	t.Run("uses the custom mask", func(t *testing.T) {
+		redactor, err := NewRedactor(RedactionRules{Keys: []string{"token"}, Mask: "***"})
+		require.NoError(t, err)
+
+		data := logrus.Fields{"token": "abc"}
+		redactor.RedactFields(data)
+		require.Equal(t, logrus.Fields{"token": "***"}, data)
+	})
+}
+
+func TestRedactionHook(t *testing.T) {
+	redactor, err := NewRedactor(RedactionRules{Keys: []string{"password"}, Patterns: []string{`secret-\w+`}})
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buffer
+	logger.SetFormatter(&JSONFormatter{})
+	logger.AddHook(&RedactionHook{Redactor: redactor})
+
+	fields := logrus.Fields{"password": "pwd", "other": "secret-value"}
+	logger.WithFields(fields).Info("found secret-key")
+
+	var output map[string]any
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &output))
+	require.Equal(t, "found [REDACTED]", output["msg"])
+	require.Equal(t, DefaultRedactionMask, output["password"])
+	require.Equal(t, DefaultRedactionMask, output["other"])
+	require.Equal(t, logrus.Fields{"password": "pwd", "other": "secret-value"}, fields, "the caller fields are not modified")
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WatchOptions configures WatchConfig.
+type WatchOptions struct {
+	// EnvFile reads the configuration as an env file, like
+	// LoadConfigFromEnvFile. Files with the .env extension are always read
+	// this way, the others like LoadConfigFromFile.
+	EnvFile bool
+	// Interval is the time between the checks of the file. Defaults to 5s.
+	Interval time.Duration
+}
+
+// WatchConfig initializes a logger from the configuration file at path, and
+// reconfigures it each time the file changes: level, format, output,
+// redaction, sampling and component levels are all applied without a
+// restart, e.g. when a mounted ConfigMap is updated.
+//
+// The file is checked every Interval. Each reconfiguration is logged with the
+// logger reconfigured message and the changes field, holding the old and new
+// value of each changed setting. An invalid configuration is logged as an
+// error, and the current one is kept. Close the handle to stop watching.
+func WatchConfig(path string, options WatchOptions) (*Handle, error) {
+	interval := options.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	r := &reloader{
+		path:    path,
+		envFile: options.EnvFile || strings.ToLower(filepath.Ext(path)) == ".env",
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logger configuration: %w", err)
+	}
+	config, err := r.parse(content)
+	if err != nil {
+		return nil, err
+	}
+	current, err := newLogger(config.InitOptions())
+	if err != nil {
+		return nil, err
+	}
+	r.current, r.config, r.content = current, config, content
+
+	r.logger = logrus.New()
+	r.logger.SetFormatter(r)
+	r.logger.SetOutput(r)
+	r.logger.SetLevel(current.GetLevel())
+	go r.watch(interval)
+	return newHandle(r.logger), nil
+}
+
+// reloader is the formatter and the output of a logger reconfigured at
+// runtime: it formats and writes the entries with the current logger built
+// from the configuration, swapped when the configuration changes.
+type reloader struct {
+	path    string
+	envFile bool
+	logger  *logrus.Logger
+
+	// mu guards current, swapped while no entry is being written. writeMu
+	// serializes the writes of the logger and of the watching goroutine.
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+	current *logrus.Logger
+	config  Config
+
+	// content and lastErr are used by the watching goroutine only.
+	content []byte
+	lastErr string
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// Format writes the entry with the current logger, running its hooks.
+func (r *reloader) Format(entry *logrus.Entry) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return nil, r.write(entry)
+}
+
+// Write ignores p: the entries are written by Format.
+func (r *reloader) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Flush sends the entries buffered by the sinks of the current logger.
+func (r *reloader) Flush(ctx context.Context) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return flushWriters(ctx, outputWriters(r.current.Out))
+}
+
+// Shutdown stops watching the file and shuts down the current logger.
+func (r *reloader) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.stopped
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Shutdown(ctx, r.current)
+}
+
+func (r *reloader) write(entry *logrus.Entry) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if err := r.current.Hooks.Fire(entry.Level, entry); err != nil {
+		return err
+	}
+	serialized, err := r.current.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.current.Out.Write(serialized)
+	return err
+}
+
+func (r *reloader) parse(content []byte) (Config, error) {
+	if r.envFile {
+		return parseConfigEnvFile(r.path, content)
+	}
+	return parseConfigFile(r.path, content)
+}
+
+func (r *reloader) watch(interval time.Duration) {
+	defer close(r.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+// check reloads the configuration if the file changed.
+func (r *reloader) check() {
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		// the file may be missing while a ConfigMap is being updated
+		r.reportError(fmt.Errorf("failed to read logger configuration: %w", err))
+		return
+	}
+	if bytes.Equal(content, r.content) {
+		r.lastErr = ""
+		return
+	}
+	r.content = content
+
+	config, err := r.parse(content)
+	if err != nil {
+		r.reportError(err)
+		return
+	}
+	next, err := newLogger(config.InitOptions())
+	if err != nil {
+		r.reportError(fmt.Errorf("failed to apply logger configuration: %w", err))
+		return
+	}
+	r.lastErr = ""
+
+	r.mu.Lock()
+	previous, previousConfig := r.current, r.config
+	r.current, r.config = next, config
+	r.mu.Unlock()
+	r.logger.SetLevel(next.GetLevel())
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExitFlushTimeout)
+	defer cancel()
+	if err := Shutdown(ctx, previous); err != nil {
+		reportSinkError(err)
+	}
+	r.event(logrus.InfoLevel, "logger reconfigured", logrus.Fields{"changes": configChanges(previousConfig, config)})
+}
+
+// reportError logs an error once, until the configuration changes.
+func (r *reloader) reportError(err error) {
+	if err.Error() == r.lastErr {
+		return
+	}
+	r.lastErr = err.Error()
+	r.event(logrus.ErrorLevel, "invalid logger configuration, keeping the current one", logrus.Fields{logrus.ErrorKey: err.Error()})
+}
+
+// event writes an entry regardless of the logger level, so that the
+// reconfigurations are always visible.
+func (r *reloader) event(level logrus.Level, message string, fields logrus.Fields) {
+	entry := logrus.NewEntry(r.logger).WithFields(fields)
+	entry.Level = level
+	entry.Message = message
+	entry.Time = time.Now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.write(entry); err != nil {
+		reportSinkError(err)
+	}
+}
+
+// configChanges returns the settings changed between two configurations,
+// with their old and new values. Nested settings are joined with a dot,
+// e.g. sampling.initial.
+func configChanges(previous, next Config) map[string]any {
+	before, after := flattenConfig(previous), flattenConfig(next)
+	changes := map[string]any{}
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changes[key] = map[string]any{"old": before[key], "new": value}
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = map[string]any{"old": value, "new": nil}
+		}
+	}
+	return changes
+}
+
+func flattenConfig(config Config) map[string]any {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil
+	}
+	flat := map[string]any{}
+	var flatten func(prefix string, fields map[string]any)
+	flatten = func(prefix string, fields map[string]any) {
+		for key, value := range fields {
+			if nested, ok := value.(map[string]any); ok {
+				flatten(prefix+key+".", nested)
+				continue
+			}
+			flat[prefix+key] = value
+		}
+	}
+	flatten("", fields)
+	return flat
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchConfig(t *testing.T) {
+	// replaceFile changes the file atomically, like a ConfigMap update.
+	replaceFile := func(t *testing.T, path, content string) {
+		t.Helper()
+		tmp := path + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o644))
+		require.NoError(t, os.Rename(tmp, path))
+	}
+	readEntries := func(t *testing.T, path string) []map[string]any {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		require.NoError(t, err)
+		var entries []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	waitEntry := func(t *testing.T, path, message string) map[string]any {
+		t.Helper()
+		var found map[string]any
+		require.Eventually(t, func() bool {
+			for _, entry := range readEntries(t, path) {
+				if entry["msg"] == message {
+					found = entry
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 5*time.Millisecond, "missing entry %q", message)
+		return found
+	}
+	messages := func(t *testing.T, path string) []string {
+		t.Helper()
+		var result []string
+		for _, entry := range readEntries(t, path) {
+			result = append(result, entry["msg"].(string))
+		}
+		return result
+	}
+
+	t.Run("applies the changes of the file", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "app.log")
+		configPath := filepath.Join(dir, "logging.yaml")
+		replaceFile(t, configPath, "level: info\noutput: file\nfile:\n  path: "+logPath+"\n")
+
+		handle, err := WatchConfig(configPath, WatchOptions{Interval: 5 * time.Millisecond})
+		require.NoError(t, err)
+		defer handle.Close(context.Background())
+
+		handle.Debug("dropped")
+		handle.WithField("password", "secret").Info("before")
+
+		replaceFile(t, configPath, "level: debug\noutput: file\nfile:\n  path: "+logPath+"\nredaction:\n  keys: [password]\n")
+		event := waitEntry(t, logPath, "logger reconfigured")
+		require.Equal(t, map[string]any{
+			"level":          map[string]any{"old": "info", "new": "debug"},
+			"redaction.keys": map[string]any{"old": nil, "new": []any{"password"}},
+		}, event["changes"])
+
+		handle.Debug("debug enabled")
+		handle.WithField("password", "secret").Info("after")
+		require.NoError(t, handle.Flush(context.Background()))
+
+		entries := readEntries(t, logPath)
+		require.Equal(t, []string{"before", "logger reconfigured", "debug enabled", "after"}, messages(t, logPath))
+		require.Equal(t, "secret", entries[0]["password"])
+		require.Equal(t, DefaultRedactionMask, entries[3]["password"])
+	})
+
+	t.Run("keeps the current configuration when invalid", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "app.log")
+		configPath := filepath.Join(dir, "logging.json")
+		replaceFile(t, configPath, `{"level":"warn","output":"file","file":{"path":"`+logPath+`"}}`)
+
+		handle, err := WatchConfig(configPath, WatchOptions{Interval: 5 * time.Millisecond})
+		require.NoError(t, err)
+		defer handle.Close(context.Background())
+
+		replaceFile(t, configPath, `{"level":"loud","output":"file","file":{"path":"`+logPath+`"}}`)
+		event := waitEntry(t, logPath, "invalid logger configuration, keeping the current one")
+		require.Contains(t, event["error"], `"loud" is not a valid level`)
+		require.Equal(t, float64(LevelError), event["level"])
+
+		// reported once
+		time.Sleep(50 * time.Millisecond)
+		handle.Info("still dropped")
+		handle.Warn("still written")
+		require.Equal(t, []string{"invalid logger configuration, keeping the current one", "still written"}, messages(t, logPath))
+	})
+
+	t.Run("switches output", func(t *testing.T) {
+		dir := t.TempDir()
+		firstPath, secondPath := filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log")
+		configPath := filepath.Join(dir, "logging.env")
+		replaceFile(t, configPath, "LOG_OUTPUT=file\nLOG_FILE_PATH="+firstPath+"\n")
+
+		handle, err := WatchConfig(configPath, WatchOptions{Interval: 5 * time.Millisecond})
+		require.NoError(t, err)
+		defer handle.Close(context.Background())
+
+		handle.Info("first")
+		replaceFile(t, configPath, "LOG_OUTPUT=file\nLOG_FILE_PATH="+secondPath+"\n")
+		event := waitEntry(t, secondPath, "logger reconfigured")
+		require.Equal(t, map[string]any{"file.path": map[string]any{"old": firstPath, "new": secondPath}}, event["changes"])
+
+		handle.Info("second")
+		require.Equal(t, []string{"first"}, messages(t, firstPath))
+		require.Equal(t, []string{"logger reconfigured", "second"}, messages(t, secondPath))
+	})
+
+	t.Run("rejects an invalid initial configuration", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "logging.yaml")
+		replaceFile(t, configPath, "format: xml\n")
+		_, err := WatchConfig(configPath, WatchOptions{})
+		require.ErrorContains(t, err, `format (LOG_FORMAT): "xml" is not a valid format`)
+
+		_, err = WatchConfig(filepath.Join(t.TempDir(), "missing.yaml"), WatchOptions{})
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
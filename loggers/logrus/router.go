@@ -0,0 +1,217 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Route sends the entries allowed by its filters to an output.
+type Route struct {
+	// Name identifies the route in the errors. Defaults to its position.
+	Name string
+	// Output receives the entries of the route, e.g. os.Stdout or a sink.
+	Output io.Writer
+	// Formatter formats the entries of the route. Defaults to the formatter
+	// of the router.
+	Formatter logrus.Formatter
+	// Filters must all allow an entry for the route to receive it. A route
+	// without filters receives all the entries.
+	Filters []EntryFilter
+	// Fallback routes receive only the entries not sent to any other route,
+	// and ignore Filters.
+	Fallback bool
+}
+
+// Router sends each entry to all the routes allowing it, each one with its
+// own formatter and output. It is both the formatter and the output of a
+// logger: it formats and writes the entries itself in Format, which returns
+// no bytes, and ignores the writes.
+//
+//	router, err := NewRouter(&JSONFormatter{}, routes...)
+//	logger.SetFormatter(router)
+//	logger.SetOutput(router)
+//
+// Shutdown, or the package function Shutdown on the logger, closes the
+// outputs of the routes.
+type Router struct {
+	routes []Route
+}
+
+// NewRouter returns a Router. Routes without a formatter use formatter.
+func NewRouter(formatter logrus.Formatter, routes ...Route) (*Router, error) {
+	router := &Router{routes: make([]Route, 0, len(routes))}
+	for i, route := range routes {
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("#%d", i)
+		}
+		if route.Output == nil {
+			return nil, fmt.Errorf("route %s: missing output", route.Name)
+		}
+		if route.Formatter == nil {
+			if formatter == nil {
+				return nil, fmt.Errorf("route %s: missing formatter", route.Name)
+			}
+			route.Formatter = formatter
+		}
+		router.routes = append(router.routes, route)
+	}
+	return router, nil
+}
+
+// Format writes the entry to the outputs of the routes allowing it.
+func (r *Router) Format(entry *logrus.Entry) ([]byte, error) {
+	var errs []error
+	var routed bool
+	for _, route := range r.routes {
+		if route.Fallback || !allowed(route.Filters, entry) {
+			continue
+		}
+		routed = true
+		errs = append(errs, route.write(entry))
+	}
+	if !routed {
+		for _, route := range r.routes {
+			if route.Fallback {
+				errs = append(errs, route.write(entry))
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Write ignores p: the entries are written by Format.
+func (r *Router) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Shutdown shuts down or closes the outputs of the routes, except standard
+// output and standard error.
+func (r *Router) Shutdown(ctx context.Context) error {
+	var outputs []io.Writer
+	seen := map[io.Writer]bool{}
+	for _, route := range r.routes {
+		// outputs shared by several routes are closed once
+		if reflect.TypeOf(route.Output).Comparable() {
+			if seen[route.Output] {
+				continue
+			}
+			seen[route.Output] = true
+		}
+		outputs = append(outputs, route.Output)
+	}
+	return shutdownWriters(ctx, outputs)
+}
+
+// Close is Shutdown without a deadline.
+func (r *Router) Close() error {
+	return r.Shutdown(context.Background())
+}
+
+func (route Route) write(entry *logrus.Entry) error {
+	// formatters append to the buffer of the entry, which holds the bytes
+	// of the previous route
+	if entry.Buffer != nil {
+		entry.Buffer.Reset()
+	}
+	serialized, err := route.Formatter.Format(entry)
+	if err == nil && len(serialized) > 0 {
+		_, err = route.Output.Write(serialized)
+	}
+	if err != nil {
+		return fmt.Errorf("route %s: %w", route.Name, err)
+	}
+	return nil
+}
+
+func allowed(filters []EntryFilter, entry *logrus.Entry) bool {
+	for _, filter := range filters {
+		if !filter.Allow(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// EntryFilterFunc is a function used as EntryFilter.
+type EntryFilterFunc func(entry *logrus.Entry) bool
+
+// Allow calls f.
+func (f EntryFilterFunc) Allow(entry *logrus.Entry) bool {
+	return f(entry)
+}
+
+// LevelAtLeast allows the entries at level or more severe: LevelAtLeast
+// (logrus.ErrorLevel) allows the error, fatal and panic entries.
+func LevelAtLeast(level logrus.Level) EntryFilter {
+	return EntryFilterFunc(func(entry *logrus.Entry) bool {
+		return entry.Level <= level
+	})
+}
+
+// MessageMatches allows the entries with a message matching re.
+func MessageMatches(re *regexp.Regexp) EntryFilter {
+	return EntryFilterFunc(func(entry *logrus.Entry) bool {
+		return re.MatchString(entry.Message)
+	})
+}
+
+// FieldEquals allows the entries with the field key equal to one of values.
+// With no values, it allows the entries having the field.
+func FieldEquals(key string, values ...any) EntryFilter {
+	return EntryFilterFunc(func(entry *logrus.Entry) bool {
+		value, ok := entry.Data[key]
+		if !ok || len(values) == 0 {
+			return ok
+		}
+		for _, expected := range values {
+			if reflect.DeepEqual(value, expected) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// FieldMatches allows the entries with the field key, formatted as a
+// string, matching re.
+func FieldMatches(key string, re *regexp.Regexp) EntryFilter {
+	return EntryFilterFunc(func(entry *logrus.Entry) bool {
+		value, ok := entry.Data[key]
+		if !ok {
+			return false
+		}
+		if s, ok := value.(string); ok {
+			return re.MatchString(s)
+		}
+		return re.MatchString(fmt.Sprint(value))
+	})
+}
+
+// Not allows the entries not allowed by filter.
+func Not(filter EntryFilter) EntryFilter {
+	return EntryFilterFunc(func(entry *logrus.Entry) bool {
+		return !filter.Allow(entry)
+	})
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// closingBuffer is an output counting the calls to Close.
+type closingBuffer struct {
+	bytes.Buffer
+	closed int
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed++
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	newLogger := func(router *Router) *logrus.Logger {
+		logger := logrus.New()
+		logger.SetLevel(logrus.TraceLevel)
+		logger.SetFormatter(router)
+		logger.SetOutput(router)
+		logger.ExitFunc = func(int) {}
+		return logger
+	}
+	lines := func(buffer *closingBuffer) []string {
+		return strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	}
+
+	t.Run("sends each entry to the matching routes", func(t *testing.T) {
+		stderr, alerts, audit, stdout := &closingBuffer{}, &closingBuffer{}, &closingBuffer{}, &closingBuffer{}
+		router, err := NewRouter(&JSONFormatter{},
+			Route{Name: "stderr", Output: stderr, Filters: []EntryFilter{LevelAtLeast(logrus.ErrorLevel)}},
+			Route{Name: "alerts", Output: alerts, Filters: []EntryFilter{LevelAtLeast(logrus.ErrorLevel)}, Formatter: &logrus.TextFormatter{DisableTimestamp: true}},
+			Route{Name: "audit", Output: audit, Filters: []EntryFilter{FieldEquals("audit", true)}},
+			Route{Name: "stdout", Output: stdout, Fallback: true},
+		)
+		require.NoError(t, err)
+
+		logger := newLogger(router).WithTime(time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC))
+		logger.Info("started")
+		logger.WithField("audit", true).Info("user created")
+		logger.WithField("audit", true).Error("permission denied")
+		logger.Error("failed")
+
+		require.Equal(t, []string{
+			`{"audit":true,"level":50,"msg":"permission denied","time":1704189630123}`,
+			`{"level":50,"msg":"failed","time":1704189630123}`,
+		}, lines(stderr))
+		require.Equal(t, []string{
+			`level=error msg="permission denied" audit=true`,
+			`level=error msg=failed`,
+		}, lines(alerts))
+		require.Equal(t, []string{
+			`{"audit":true,"level":30,"msg":"user created","time":1704189630123}`,
+			`{"audit":true,"level":50,"msg":"permission denied","time":1704189630123}`,
+		}, lines(audit))
+		require.Equal(t, []string{`{"level":30,"msg":"started","time":1704189630123}`}, lines(stdout))
+	})
+
+	t.Run("reports the failing routes", func(t *testing.T) {
+		var output closingBuffer
+		router, err := NewRouter(&JSONFormatter{},
+			Route{Name: "broken", Output: failingWriter{}},
+			Route{Output: &output},
+		)
+		require.NoError(t, err)
+
+		_, err = router.Format(logrus.NewEntry(logrus.New()))
+		require.EqualError(t, err, "route broken: write failed")
+		require.NotEmpty(t, output.String())
+	})
+
+	t.Run("shuts the outputs down once", func(t *testing.T) {
+		shared, other := &closingBuffer{}, &closingBuffer{}
+		router, err := NewRouter(&JSONFormatter{},
+			Route{Output: shared, Filters: []EntryFilter{LevelAtLeast(logrus.ErrorLevel)}},
+			Route{Output: shared, Fallback: true},
+			Route{Output: other},
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, Shutdown(context.Background(), newLogger(router)))
+		require.Equal(t, 1, shared.closed)
+		require.Equal(t, 1, other.closed)
+	})
+
+	t.Run("rejects invalid routes", func(t *testing.T) {
+		_, err := NewRouter(&JSONFormatter{}, Route{Name: "audit"})
+		require.EqualError(t, err, "route audit: missing output")
+
+		_, err = NewRouter(nil, Route{Output: &closingBuffer{}})
+		require.EqualError(t, err, "route #0: missing formatter")
+	})
+}
+
+func TestEntryFilters(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{"status": 503, "path": "/users/1"})
+	entry.Level = logrus.WarnLevel
+	entry.Message = "request failed"
+
+	testCases := []struct {
+		name    string
+		filter  EntryFilter
+		allowed bool
+	}{
+		{name: "level at least warn", filter: LevelAtLeast(logrus.WarnLevel), allowed: true},
+		{name: "level at least error", filter: LevelAtLeast(logrus.ErrorLevel)},
+		{name: "message matches", filter: MessageMatches(regexp.MustCompile(`fail`)), allowed: true},
+		{name: "message does not match", filter: MessageMatches(regexp.MustCompile(`^ok`))},
+		{name: "field equals", filter: FieldEquals("status", 500, 503), allowed: true},
+		{name: "field differs", filter: FieldEquals("status", "503")},
+		{name: "field exists", filter: FieldEquals("path"), allowed: true},
+		{name: "field missing", filter: FieldEquals("audit")},
+		{name: "field matches", filter: FieldMatches("status", regexp.MustCompile(`^5`)), allowed: true},
+		{name: "string field matches", filter: FieldMatches("path", regexp.MustCompile(`^/users/`)), allowed: true},
+		{name: "missing field does not match", filter: FieldMatches("audit", regexp.MustCompile(``))},
+		{name: "not", filter: Not(LevelAtLeast(logrus.ErrorLevel)), allowed: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.allowed, testCase.filter.Allow(entry))
+		})
+	}
+}
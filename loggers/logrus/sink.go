@@ -0,0 +1,314 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExitFlushTimeout limits the time spent sending the buffered entries when
+// the logger exits after a fatal entry.
+var ExitFlushTimeout = 5 * time.Second
+
+// sinkRecord is an entry decoded from a line of JSONFormatter. Sinks are
+// io.Writers receiving a line for each Write, and decode it to forward the
+// entry to their backend.
+type sinkRecord struct {
+	level   int
+	time    time.Time
+	message string
+	// fields are the entry fields, except level, msg and time. Numbers are
+	// decoded as json.Number.
+	fields map[string]any
+	// line is the encoded entry, without the trailing newline.
+	line []byte
+}
+
+// decodeRecord decodes a line of JSONFormatter. Lines that are not a JSON
+// object, e.g. written by a text formatter, become an info record with the
+// line as message.
+func decodeRecord(p []byte) sinkRecord {
+	line := bytes.TrimRight(p, "\r\n")
+	record := sinkRecord{level: LevelInfo, line: line}
+
+	var fields map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		record.time = time.Now()
+		record.message = string(line)
+		return record
+	}
+
+	if level, ok := fields["level"].(json.Number); ok {
+		if value, err := level.Int64(); err == nil {
+			record.level = int(value)
+		}
+	}
+	record.time = time.Now()
+	if ms, ok := fields["time"].(json.Number); ok {
+		if value, err := ms.Int64(); err == nil {
+			record.time = time.UnixMilli(value)
+		}
+	}
+	record.message, _ = fields["msg"].(string)
+	delete(fields, "level")
+	delete(fields, "time")
+	delete(fields, "msg")
+	record.fields = fields
+	return record
+}
+
+// sortedKeys returns the keys of the record fields in a stable order.
+func (r sinkRecord) sortedKeys() []string {
+	keys := make([]string, 0, len(r.fields))
+	for k := range r.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entryFields returns all the fields of the entry, level, msg and time
+// included, as written by JSONFormatter.
+func (r sinkRecord) entryFields() map[string]any {
+	fields := make(map[string]any, len(r.fields)+3)
+	for k, v := range r.fields {
+		fields[k] = v
+	}
+	fields["level"] = json.Number(strconv.Itoa(r.level))
+	fields["msg"] = r.message
+	fields["time"] = json.Number(strconv.FormatInt(r.time.UnixMilli(), 10))
+	return fields
+}
+
+// fieldString returns the value of a record field as a string: strings are
+// returned as they are, anything else is encoded to JSON.
+func fieldString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case nil:
+		return "null"
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return UnserializableValue
+	}
+	return string(encoded)
+}
+
+// syslogSeverity maps a numeric level to a syslog severity.
+func syslogSeverity(level int) int {
+	switch {
+	case level <= LevelDebug:
+		return 7 // debug
+	case level <= LevelInfo:
+		return 6 // informational
+	case level <= LevelWarn:
+		return 4 // warning
+	case level <= LevelError:
+		return 3 // error
+	case level <= LevelFatal:
+		return 2 // critical
+	default:
+		return 1 // alert
+	}
+}
+
+// levelName returns the name of a numeric level.
+func levelName(level int) string {
+	switch {
+	case level <= LevelTrace:
+		return "trace"
+	case level <= LevelDebug:
+		return "debug"
+	case level <= LevelInfo:
+		return "info"
+	case level <= LevelWarn:
+		return "warn"
+	case level <= LevelError:
+		return "error"
+	case level <= LevelFatal:
+		return "fatal"
+	default:
+		return "panic"
+	}
+}
+
+// programName returns the name used to identify the process in the sinks.
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// multiSink writes each entry to all its sinks, also when some of them fail.
+type multiSink []io.Writer
+
+func (m multiSink) Write(p []byte) (int, error) {
+	var errs []error
+	for _, w := range m {
+		if _, err := w.Write(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(p), errors.Join(errs...)
+}
+
+// Shutdown sends the entries buffered by the sinks the logger writes to, and
+// closes them. Call it before the application exits, to lose no entries.
+// Standard output and standard error are never closed. For the loggers
+// created by InitHelper, it is the same as closing their Handle.
+func Shutdown(ctx context.Context, logger *logrus.Logger) error {
+	if handle := openHandle(logger); handle != nil {
+		return handle.Close(ctx)
+	}
+	return shutdownWriters(ctx, outputWriters(logger.Out))
+}
+
+// Flush sends the entries buffered by the sinks the logger writes to,
+// waiting for them to be delivered or for ctx to expire, e.g. for the
+// loggers created by InitHelper.
+func Flush(ctx context.Context, logger *logrus.Logger) error {
+	return flushWriters(ctx, outputWriters(logger.Out))
+}
+
+// shutdownWriters shuts down or closes the writers, except standard output
+// and standard error.
+func shutdownWriters(ctx context.Context, writers []io.Writer) error {
+	var errs []error
+	for _, w := range writers {
+		switch w := w.(type) {
+		case interface{ Shutdown(context.Context) error }:
+			errs = append(errs, w.Shutdown(ctx))
+		case io.Closer:
+			if w != os.Stdout && w != os.Stderr {
+				errs = append(errs, w.Close())
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sinkConn is a connection opened on demand, and opened again after a
+// failure. It is used by the batching sinks from their worker goroutine.
+type sinkConn struct {
+	network string
+	address string
+	timeout time.Duration
+
+	conn   net.Conn
+	reader *bufio.Reader
+	// lastUsed is the time of the last write, to check only idle connections.
+	lastUsed time.Time
+}
+
+// get returns the connection, opening it if needed. A stream connection
+// closed by the server is opened again, otherwise the first write after a
+// server restart would be lost.
+func (c *sinkConn) get(ctx context.Context) (net.Conn, error) {
+	if c.conn != nil && time.Since(c.lastUsed) > idleCheckInterval && c.closedByPeer() {
+		c.close()
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, &retryableError{err: err}
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return conn, nil
+}
+
+// idleCheckInterval is the idle time after which a connection is checked
+// before being used.
+const idleCheckInterval = 100 * time.Millisecond
+
+// closedByPeer checks whether the server has closed the connection: a read
+// then fails with io.EOF instead of timing out. The deadline is in the
+// future, since an expired one fails the read without trying it.
+func (c *sinkConn) closedByPeer() bool {
+	if _, ok := c.conn.(interface{ CloseWrite() error }); !ok {
+		// datagram connections have no state to check
+		return false
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := c.reader.Peek(1)
+	c.conn.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return err != nil && !(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// write writes b, closing the connection on failure so that the next
+// attempt opens a new one.
+func (c *sinkConn) write(ctx context.Context, b []byte) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(b); err != nil {
+		c.close()
+		return &retryableError{err: err}
+	}
+	c.lastUsed = time.Now()
+	return nil
+}
+
+// fail closes the connection after an error, wrapping it as retryable.
+func (c *sinkConn) fail(err error) error {
+	c.close()
+	return &retryableError{err: err}
+}
+
+func (c *sinkConn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.reader = nil
+	}
+}
+
+// closeAll closes the writers that are io.Closer.
+func closeAll(writers []io.Writer) error {
+	var errs []error
+	for _, w := range writers {
+		if closer, ok := w.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeRecord(t *testing.T) {
+	t.Run("JSONFormatter line", func(t *testing.T) {
+		record := decodeRecord([]byte(`{"level":40,"msg":"hello","time":1704189630123,"reqId":"abc","count":3}` + "\n"))
+		require.Equal(t, LevelWarn, record.level)
+		require.Equal(t, "hello", record.message)
+		require.Equal(t, time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC), record.time.UTC())
+		require.Equal(t, map[string]any{"reqId": "abc", "count": json.Number("3")}, record.fields)
+		require.Equal(t, []string{"count", "reqId"}, record.sortedKeys())
+		require.Equal(t, `{"level":40,"msg":"hello","time":1704189630123,"reqId":"abc","count":3}`, string(record.line))
+	})
+
+	t.Run("text line", func(t *testing.T) {
+		record := decodeRecord([]byte("time=now level=info msg=hello\n"))
+		require.Equal(t, LevelInfo, record.level)
+		require.Equal(t, "time=now level=info msg=hello", record.message)
+		require.Empty(t, record.fields)
+		require.WithinDuration(t, time.Now(), record.time, time.Minute)
+	})
+
+	t.Run("field values as strings", func(t *testing.T) {
+		require.Equal(t, "s", fieldString("s"))
+		require.Equal(t, "1.5", fieldString(json.Number("1.5")))
+		require.Equal(t, "true", fieldString(true))
+		require.Equal(t, "null", fieldString(nil))
+		require.Equal(t, `{"a":[1]}`, fieldString(map[string]any{"a": []any{json.Number("1")}}))
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestMultiSink(t *testing.T) {
+	var first, second bytes.Buffer
+	sink := multiSink{&first, failingWriter{}, &second}
+
+	n, err := sink.Write([]byte("line\n"))
+	require.Equal(t, 5, n)
+	require.EqualError(t, err, "write failed")
+	require.Equal(t, "line\n", first.String())
+	require.Equal(t, "line\n", second.String(), "the sinks after a failing one are written")
+}
@@ -0,0 +1,417 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultSyslogStructuredDataID is the SD-ID of the structured data element
+// holding the entry fields. 32473 is the private enterprise number reserved
+// for documentation by RFC 5612.
+const DefaultSyslogStructuredDataID = "glogger@32473"
+
+const syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// DefaultSyslogUDPMaxMessageBytes is the size limit of the messages sent over
+// UDP, the message size all the receivers should accept by RFC 5426.
+const DefaultSyslogUDPMaxMessageBytes = 2048
+
+// syslogMinMessageBytes is the message size all the receivers must accept by
+// RFC 5426, enough for the longest header.
+const syslogMinMessageBytes = 480
+
+// syslogMaxNameLength is the maximum length of an SD-NAME.
+const syslogMaxNameLength = 32
+
+// The waits between the attempts to connect again to the server.
+const (
+	syslogReconnectInitial = 100 * time.Millisecond
+	syslogReconnectMax     = 30 * time.Second
+)
+
+var errSyslogDisconnected = errors.New("syslog server not connected")
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogSinkOptions configures a SyslogSink.
+type SyslogSinkOptions struct {
+	// Network is one of udp, tcp, unix (stream socket) or unixgram. When
+	// empty, the local syslog socket (e.g. /dev/log) is used.
+	Network string
+	// Address is the host:port, or the socket path, of the syslog server.
+	Address string
+	// Facility is the syslog facility name, e.g. local0. Defaults to user.
+	Facility string
+	// AppName defaults to the name of the executable.
+	AppName string
+	// Hostname defaults to the host name reported by the kernel.
+	Hostname string
+	// StructuredDataID is the SD-ID of the element holding the entry fields.
+	// Defaults to DefaultSyslogStructuredDataID.
+	StructuredDataID string
+	// DialTimeout limits the connection to the server, and each write.
+	// Defaults to 5s.
+	DialTimeout time.Duration
+	// MaxMessageBytes truncates the longer messages: the MSG is cut first,
+	// then the fields that do not fit are dropped. Defaults to
+	// DefaultSyslogUDPMaxMessageBytes over UDP, and to no limit otherwise.
+	// Limits below 480 bytes, the size all the receivers accept, are raised
+	// to it.
+	MaxMessageBytes int
+}
+
+// SyslogSink is an io.Writer sending each entry to a syslog server as an
+// RFC 5424 message. The level is mapped to the message severity, the fields
+// are sent as structured data and the message as MSG, and the messages over
+// UDP are truncated to fit a datagram. Over stream sockets,
+// messages are framed with their length as defined by RFC 6587.
+//
+// When a write fails, the connection is opened again from a background
+// goroutine, with backoff: the entries written in the meantime are
+// discarded, and their writes fail without waiting for the server. A
+// SyslogSink is safe for concurrent use.
+type SyslogSink struct {
+	network  string
+	address  string
+	timeout  time.Duration
+	facility int
+	hostname string
+	appName  string
+	procID   string
+	sdID     string
+	maxBytes int
+
+	mu           sync.Mutex
+	conn         net.Conn
+	stream       bool
+	closed       bool
+	reconnecting bool
+	done         chan struct{}
+}
+
+// NewSyslogSink connects to the syslog server.
+func NewSyslogSink(options SyslogSinkOptions) (*SyslogSink, error) {
+	switch options.Network {
+	case "", "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", options.Network)
+	}
+	if options.Network != "" && options.Address == "" {
+		return nil, errors.New("syslog address is required")
+	}
+
+	facility := 1
+	if options.Facility != "" {
+		var ok bool
+		if facility, ok = syslogFacilities[strings.ToLower(options.Facility)]; !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", options.Facility)
+		}
+	}
+	hostname := options.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	appName := options.AppName
+	if appName == "" {
+		appName = programName()
+	}
+	sdID := options.StructuredDataID
+	if sdID == "" {
+		sdID = DefaultSyslogStructuredDataID
+	}
+	timeout := options.DialTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	maxBytes := options.MaxMessageBytes
+	if maxBytes <= 0 && strings.HasPrefix(options.Network, "udp") {
+		maxBytes = DefaultSyslogUDPMaxMessageBytes
+	}
+	if maxBytes > 0 && maxBytes < syslogMinMessageBytes {
+		maxBytes = syslogMinMessageBytes
+	}
+
+	sink := &SyslogSink{
+		network:  options.Network,
+		address:  options.Address,
+		timeout:  timeout,
+		facility: facility,
+		hostname: syslogHeaderField(hostname, 255),
+		appName:  syslogHeaderField(appName, 48),
+		procID:   strconv.Itoa(os.Getpid()),
+		sdID:     syslogName(sdID),
+		maxBytes: maxBytes,
+		done:     make(chan struct{}),
+	}
+	conn, stream, err := sink.dial()
+	if err != nil {
+		return nil, err
+	}
+	sink.conn, sink.stream = conn, stream
+	return sink, nil
+}
+
+// Write sends the entry to the server. Empty writes are ignored.
+func (s *SyslogSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	message := s.format(decodeRecord(p))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if s.conn == nil {
+		return 0, errSyslogDisconnected
+	}
+	if err := s.send(message); err != nil {
+		// the server may have been restarted: connect again without
+		// blocking the writes
+		s.conn.Close()
+		s.conn = nil
+		if !s.reconnecting {
+			s.reconnecting = true
+			go s.reconnect()
+		}
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// reconnect connects to the server again, waiting longer after each failed
+// attempt, until it succeeds or the sink is closed.
+func (s *SyslogSink) reconnect() {
+	wait := syslogReconnectInitial
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		conn, stream, err := s.dial()
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			s.conn, s.stream = conn, stream
+			s.reconnecting = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		reportSinkError(err)
+		if wait *= 2; wait > syslogReconnectMax {
+			wait = syslogReconnectMax
+		}
+	}
+}
+
+// dial opens a connection to the server, reporting whether it is a stream.
+func (s *SyslogSink) dial() (net.Conn, bool, error) {
+	if s.network != "" {
+		conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		return conn, s.network == "unix" || strings.HasPrefix(s.network, "tcp"), nil
+	}
+
+	sockets := localSyslogSockets
+	if s.address != "" {
+		sockets = []string{s.address}
+	}
+	for _, socket := range sockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.DialTimeout(network, socket, s.timeout); err == nil {
+				return conn, network == "unix", nil
+			}
+		}
+	}
+	return nil, false, errors.New("failed to connect to syslog: local syslog socket not found")
+}
+
+func (s *SyslogSink) send(message []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if s.stream {
+		framed := make([]byte, 0, len(message)+8)
+		framed = strconv.AppendInt(framed, int64(len(message)), 10)
+		framed = append(framed, ' ')
+		message = append(framed, message...)
+	}
+	_, err := s.conn.Write(message)
+	return err
+}
+
+// format encodes the record as an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID PARAMS...] MSG
+// The field names that are the same once made valid SD-NAMEs get a ~N suffix.
+func (s *SyslogSink) format(record sinkRecord) []byte {
+	var b bytes.Buffer
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(s.facility*8 + syslogSeverity(record.level)))
+	b.WriteString(">1 ")
+	b.WriteString(record.time.UTC().Format(syslogTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(s.hostname)
+	b.WriteByte(' ')
+	b.WriteString(s.appName)
+	b.WriteByte(' ')
+	b.WriteString(s.procID)
+	b.WriteString(" - [")
+	b.WriteString(s.sdID)
+	b.WriteString(` level="`)
+	b.WriteString(strconv.Itoa(record.level))
+	b.WriteByte('"')
+
+	names := map[string]bool{"level": true}
+	for _, key := range record.sortedKeys() {
+		mark := b.Len()
+		b.WriteByte(' ')
+		b.WriteString(uniqueSyslogName(key, names))
+		b.WriteString(`="`)
+		writeSyslogParamValue(&b, fieldString(record.fields[key]))
+		b.WriteByte('"')
+		if s.maxBytes > 0 && b.Len()+1 > s.maxBytes {
+			// the fields that do not fit are dropped
+			b.Truncate(mark)
+			break
+		}
+	}
+	b.WriteByte(']')
+	if record.message != "" {
+		b.WriteByte(' ')
+		b.WriteString(record.message)
+	}
+	if s.maxBytes > 0 && b.Len() > s.maxBytes {
+		n := s.maxBytes
+		for n > 0 && !utf8.RuneStart(b.Bytes()[n]) {
+			n--
+		}
+		b.Truncate(n)
+	}
+	return b.Bytes()
+}
+
+// uniqueSyslogName returns the SD-NAME of a field, adding a ~N suffix when it
+// is already in names, e.g. when the names are the same once truncated.
+func uniqueSyslogName(key string, names map[string]bool) string {
+	base := syslogName(key)
+	name := base
+	for i := 1; names[name]; i++ {
+		suffix := "~" + strconv.Itoa(i)
+		if len(base) > syslogMaxNameLength-len(suffix) {
+			base = base[:syslogMaxNameLength-len(suffix)]
+		}
+		name = base + suffix
+	}
+	names[name] = true
+	return name
+}
+
+// syslogHeaderField replaces the characters not allowed in the header fields
+// and truncates them to their maximum length.
+func syslogHeaderField(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	return value
+}
+
+// syslogName returns a valid SD-NAME: printable ASCII characters except
+// '=', ' ', ']' and '"', at most 32.
+func syslogName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "_"
+	}
+	if len(name) > syslogMaxNameLength {
+		name = name[:syslogMaxNameLength]
+	}
+	return name
+}
+
+// writeSyslogParamValue escapes '"', '\' and ']' as required by RFC 5424.
+func writeSyslogParamValue(b *bytes.Buffer, value string) {
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\', ']':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
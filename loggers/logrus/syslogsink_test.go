@@ -0,0 +1,315 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogSink(t *testing.T) {
+	entryTime := time.Date(2024, 1, 2, 10, 0, 30, 123000000, time.UTC)
+	options := func(network, address string) SyslogSinkOptions {
+		return SyslogSinkOptions{
+			Network:  network,
+			Address:  address,
+			Facility: "local0",
+			AppName:  "my app",
+			Hostname: "host",
+		}
+	}
+	expectedMessage := func(severity int, fields, message string) string {
+		return fmt.Sprintf("<%d>1 2024-01-02T10:00:30.123000Z host my_app %d - [glogger@32473 %s] %s",
+			16*8+severity, os.Getpid(), fields, message)
+	}
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := NewSyslogSink(SyslogSinkOptions{Network: "sctp", Address: "localhost:514"})
+		require.EqualError(t, err, `unsupported syslog network "sctp"`)
+
+		_, err = NewSyslogSink(SyslogSinkOptions{Network: "udp"})
+		require.EqualError(t, err, "syslog address is required")
+
+		_, err = NewSyslogSink(SyslogSinkOptions{Network: "udp", Address: "127.0.0.1:514", Facility: "local9"})
+		require.EqualError(t, err, `unknown syslog facility "local9"`)
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("udp", listener.LocalAddr().String()))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).WithFields(logrus.Fields{
+			"reqId": "abc",
+			"http":  map[string]any{"status": 200},
+			"note":  `quote " and ] and \`,
+		}).Warn("something happened")
+
+		require.Equal(t,
+			expectedMessage(4, `level="40" http="{\"status\":200}" note="quote \" and \] and \\" reqId="abc"`, "something happened"),
+			readPacket(t, listener),
+		)
+	})
+
+	t.Run("disambiguates the field names", func(t *testing.T) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("udp", listener.LocalAddr().String()))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		long := strings.Repeat("a", 32)
+		newSinkLogger(sink).WithTime(entryTime).WithFields(logrus.Fields{
+			long + "1": "first",
+			long + "2": "second",
+			"a b":      "third",
+			"a_b":      "fourth",
+		}).Info("hello")
+
+		require.Equal(t,
+			expectedMessage(6, `level="30" a_b="third" a_b~1="fourth" `+long+`="first" `+long[:30]+`~1="second"`, "hello"),
+			readPacket(t, listener),
+		)
+	})
+
+	t.Run("udp messages fit a datagram", func(t *testing.T) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("udp", listener.LocalAddr().String()))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).WithField("note", "kept").Info(strings.Repeat("è", 2000))
+		packet := readPacket(t, listener)
+		require.LessOrEqual(t, len(packet), DefaultSyslogUDPMaxMessageBytes)
+		require.True(t, strings.HasPrefix(packet, expectedMessage(6, `level="30" note="kept"`, "èè")))
+		require.True(t, utf8.ValidString(packet), "the message is cut at a character boundary")
+
+		logger.WithTime(entryTime).WithFields(logrus.Fields{"a": "kept", "b": strings.Repeat("x", 3000)}).Info("hello")
+		require.Equal(t, expectedMessage(6, `level="30" a="kept"`, "hello"), readPacket(t, listener))
+	})
+
+	t.Run("raises the limits below the minimal message size", func(t *testing.T) {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sinkOptions := options("udp", listener.LocalAddr().String())
+		sinkOptions.MaxMessageBytes = 10
+		sink, err := NewSyslogSink(sinkOptions)
+		require.NoError(t, err)
+		defer sink.Close()
+
+		newSinkLogger(sink).WithTime(entryTime).Info(strings.Repeat("x", 1000))
+		require.Equal(t, expectedMessage(6, `level="30"`, strings.Repeat("x", 1000))[:480], readPacket(t, listener))
+	})
+
+	t.Run("tcp with octet counting framing", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("tcp", listener.Addr().String()))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		logger := newSinkLogger(sink)
+		logger.WithTime(entryTime).Error("first")
+		logger.WithTime(entryTime).Debug("second")
+
+		require.Equal(t, expectedMessage(3, `level="50"`, "first"), readFrame(t, reader))
+		require.Equal(t, expectedMessage(7, `level="20"`, "second"), readFrame(t, reader))
+	})
+
+	t.Run("tcp reconnects after a failure", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("tcp", listener.Addr().String()))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		conn.Close()
+
+		logger := newSinkLogger(sink)
+		require.Eventually(t, func() bool {
+			// writes to a connection closed by the peer fail only after a
+			// while, so entries are logged until the sink reconnects
+			logger.WithTime(entryTime).Info("lost")
+			listener.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Millisecond))
+			conn, err = listener.Accept()
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		defer conn.Close()
+
+		require.Eventually(t, sink.connected, 5*time.Second, 10*time.Millisecond)
+		logger.WithTime(entryTime).Info("after restart")
+		require.Equal(t, expectedMessage(6, `level="30"`, "after restart"), readFrame(t, bufio.NewReader(conn)))
+	})
+
+	t.Run("tcp writes do not wait for the server to come back", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+
+		sink, err := NewSyslogSink(options("tcp", address))
+		require.NoError(t, err)
+		defer sink.Close()
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		conn.Close()
+		listener.Close()
+
+		require.Eventually(t, func() bool {
+			_, err := sink.Write([]byte(`{"msg":"lost"}`))
+			return err != nil
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err = sink.Write([]byte(`{"msg":"lost"}`))
+		require.ErrorIs(t, err, errSyslogDisconnected)
+
+		listener, err = net.Listen("tcp", address)
+		require.NoError(t, err)
+		defer listener.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				accepted <- conn
+			}
+		}()
+
+		require.Eventually(t, sink.connected, 5*time.Second, 10*time.Millisecond)
+		newSinkLogger(sink).WithTime(entryTime).Info("back")
+		conn = <-accepted
+		defer conn.Close()
+		require.Equal(t, expectedMessage(6, `level="30"`, "back"), readFrame(t, bufio.NewReader(conn)))
+	})
+
+	t.Run("unix datagram socket", func(t *testing.T) {
+		path := filepath.Join(shortTempDir(t), "syslog.sock")
+		listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		require.NoError(t, err)
+		defer listener.Close()
+
+		for _, network := range []string{"unixgram", ""} {
+			sink, err := NewSyslogSink(options(network, path))
+			require.NoError(t, err)
+
+			require.Panics(t, func() {
+				newSinkLogger(sink).WithTime(entryTime).WithField("weird key=", "x").Panic("fatal error")
+			})
+			require.Equal(t, expectedMessage(1, `level="70" weird_key_="x"`, "fatal error"), readPacket(t, listener))
+			require.NoError(t, sink.Close())
+		}
+	})
+
+	t.Run("unix stream socket", func(t *testing.T) {
+		path := filepath.Join(shortTempDir(t), "syslog.sock")
+		listener, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer listener.Close()
+
+		sink, err := NewSyslogSink(options("unix", path))
+		require.NoError(t, err)
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		newSinkLogger(sink).WithTime(entryTime).Info("hello")
+		require.Equal(t, expectedMessage(6, `level="30"`, "hello"), readFrame(t, bufio.NewReader(conn)))
+
+		require.NoError(t, sink.Close())
+		_, err = sink.Write([]byte("{}\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+	})
+}
+
+func newSinkLogger(sink interface{ Write([]byte) (int, error) }) *logrus.Logger {
+	logger := logrus.New()
+	logger.Out = sink
+	logger.Level = logrus.TraceLevel
+	logger.ExitFunc = func(int) {}
+	logger.SetFormatter(&JSONFormatter{})
+	return logger
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buffer := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buffer)
+	require.NoError(t, err)
+	return string(buffer[:n])
+}
+
+func readFrame(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	length, err := reader.ReadString(' ')
+	require.NoError(t, err)
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	require.NoError(t, err)
+	frame := make([]byte, size)
+	_, err = io.ReadFull(reader, frame)
+	require.NoError(t, err)
+	return string(frame)
+}
+
+// shortTempDir returns a temporary directory with a path short enough for
+// unix sockets.
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "glogger")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func (s *SyslogSink) connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
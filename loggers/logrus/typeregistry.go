@@ -0,0 +1,338 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/loggers/core"
+	"github.com/sirupsen/logrus"
+)
+
+// JSON types detected by the FieldTypeRegistry.
+const (
+	JSONTypeString  = "string"
+	JSONTypeNumber  = "number"
+	JSONTypeBoolean = "boolean"
+	JSONTypeObject  = "object"
+	JSONTypeArray   = "array"
+)
+
+// TypeConflictStrategy defines how FieldTypeRegistry fixes a value whose
+// JSON type differs from the one first seen for its key.
+type TypeConflictStrategy int
+
+const (
+	// TypeConflictRename moves the value to a key suffixed with its type,
+	// e.g. userId_string.
+	TypeConflictRename TypeConflictStrategy = iota
+	// TypeConflictStringify converts the value to its JSON representation,
+	// when the key was first seen with a string. Otherwise the value is
+	// renamed as with TypeConflictRename.
+	TypeConflictStringify
+)
+
+// TypeConflict reports the values found with a JSON type different from the
+// one first seen for their key path.
+type TypeConflict struct {
+	Path     string
+	Expected string
+	Found    string
+	Count    int
+}
+
+// maxRegisteredPaths is the number of key paths a FieldTypeRegistry tracks,
+// so that keys generated at runtime do not grow it forever.
+const maxRegisteredPaths = 10000
+
+// FieldTypeRegistry remembers the JSON type of the first value seen for each
+// key path, and fixes the values of the following entries with a different
+// type, so that indexes with a dynamic mapping (e.g. Elasticsearch) do not
+// reject them. Nested paths are joined with a dot, and are tracked inside
+// maps only, up to the same depth encoded without reflection. Null values
+// are ignored. A value is not renamed when its new key is already used. The
+// first 10000 key paths are tracked: the ones seen later are not checked.
+//
+// A FieldTypeRegistry is safe for concurrent use, and can be shared among
+// formatters to enforce the same types on all of them.
+type FieldTypeRegistry struct {
+	strategy TypeConflictStrategy
+
+	mu        sync.RWMutex
+	types     map[string]string
+	conflicts map[typeConflictKey]int
+}
+
+type typeConflictKey struct {
+	path     string
+	expected string
+	found    string
+}
+
+// NewFieldTypeRegistry returns an empty registry fixing conflicts with the
+// given strategy.
+func NewFieldTypeRegistry(strategy TypeConflictStrategy) *FieldTypeRegistry {
+	return &FieldTypeRegistry{
+		strategy:  strategy,
+		types:     map[string]string{},
+		conflicts: map[typeConflictKey]int{},
+	}
+}
+
+// Conflicts returns the conflicts detected so far, sorted by path.
+func (r *FieldTypeRegistry) Conflicts() []TypeConflict {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conflicts := make([]TypeConflict, 0, len(r.conflicts))
+	for key, count := range r.conflicts {
+		conflicts = append(conflicts, TypeConflict{
+			Path:     key.path,
+			Expected: key.expected,
+			Found:    key.found,
+			Count:    count,
+		})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Path != conflicts[j].Path {
+			return conflicts[i].Path < conflicts[j].Path
+		}
+		return conflicts[i].Found < conflicts[j].Found
+	})
+	return conflicts
+}
+
+// Types returns the JSON type registered for each key path.
+func (r *FieldTypeRegistry) Types() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make(map[string]string, len(r.types))
+	for path, jsonType := range r.types {
+		types[path] = jsonType
+	}
+	return types
+}
+
+// check returns the fields with the conflicting values fixed. Data is never
+// modified: a copy is returned when something has to change.
+func (r *FieldTypeRegistry) check(data logrus.Fields) logrus.Fields {
+	if fixed, changed := r.checkMap(data, "", 0); changed {
+		return fixed
+	}
+	return data
+}
+
+func (r *FieldTypeRegistry) checkMap(data map[string]any, prefix string, depth int) (map[string]any, bool) {
+	var result map[string]any
+	for k, v := range data {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		newKey, newValue, changed := r.checkValue(k, v, path, depth)
+		if !changed {
+			continue
+		}
+		if _, exists := data[newKey]; exists && newKey != k {
+			// renaming would overwrite another field
+			continue
+		}
+		if result == nil {
+			result = make(map[string]any, len(data))
+			for k, v := range data {
+				result[k] = v
+			}
+		}
+		delete(result, k)
+		result[newKey] = newValue
+	}
+	return result, result != nil
+}
+
+// checkValue returns the key and the value to use for a field, and whether
+// they differ from the original ones.
+func (r *FieldTypeRegistry) checkValue(key string, value any, path string, depth int) (string, any, bool) {
+	found := jsonType(value)
+	if found == "" {
+		return key, value, false
+	}
+
+	expected := r.register(path, found)
+	if expected != "" && expected != found {
+		r.recordConflict(path, expected, found)
+		if r.strategy == TypeConflictStringify && expected == JSONTypeString {
+			return key, stringify(value), true
+		}
+		return key + "_" + found, value, true
+	}
+
+	if depth+1 >= maxFastDepth {
+		// deeper maps, possibly cyclic, are not tracked
+		return key, value, false
+	}
+	switch nested := value.(type) {
+	case map[string]any:
+		if fixed, changed := r.checkMap(nested, path, depth+1); changed {
+			return key, fixed, true
+		}
+	case logrus.Fields:
+		if fixed, changed := r.checkMap(nested, path, depth+1); changed {
+			return key, logrus.Fields(fixed), true
+		}
+	}
+	return key, value, false
+}
+
+// register stores the type of path, if it is the first time it is seen, and
+// returns the expected type. It returns an empty string for the paths not
+// tracked because the registry is full.
+func (r *FieldTypeRegistry) register(path, jsonType string) string {
+	r.mu.RLock()
+	expected, ok := r.types[path]
+	r.mu.RUnlock()
+	if ok {
+		return expected
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if expected, ok := r.types[path]; ok {
+		return expected
+	}
+	if len(r.types) >= maxRegisteredPaths {
+		return ""
+	}
+	r.types[path] = jsonType
+	return jsonType
+}
+
+func (r *FieldTypeRegistry) recordConflict(path, expected, found string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conflicts[typeConflictKey{path: path, expected: expected, found: found}]++
+}
+
+func stringify(value any) string {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	encoded, err := marshalJSON(value)
+	if err != nil {
+		return UnserializableValue
+	}
+	return string(encoded)
+}
+
+// marshalJSON encodes value, turning into an error any panic raised by
+// custom marshalers.
+func marshalJSON(value any) ([]byte, error) {
+	var b bytes.Buffer
+	if err := safeEncode(json.NewEncoder(&b), value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte{'\n'}), nil
+}
+
+// jsonType returns the JSON type value is encoded to. It returns an empty
+// string for null values and for values that cannot be encoded.
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case string, error, time.Time:
+		return JSONTypeString
+	case bool:
+		return JSONTypeBoolean
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		float32, float64, time.Duration, json.Number:
+		return JSONTypeNumber
+	case []string, []any:
+		return JSONTypeArray
+	case map[string]any, logrus.Fields, map[string]string, map[string][]string, core.ObjectMarshaler:
+		return JSONTypeObject
+	case json.Marshaler:
+		return marshaledType(value)
+	case encoding.TextMarshaler:
+		return JSONTypeString
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return ""
+		}
+		return jsonType(rv.Elem().Interface())
+	case reflect.String:
+		return JSONTypeString
+	case reflect.Bool:
+		return JSONTypeBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return JSONTypeNumber
+	case reflect.Struct:
+		return JSONTypeObject
+	case reflect.Map:
+		if rv.IsNil() {
+			return ""
+		}
+		return JSONTypeObject
+	case reflect.Slice:
+		if rv.IsNil() {
+			return ""
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// byte slices are encoded as base64 strings
+			return JSONTypeString
+		}
+		return JSONTypeArray
+	case reflect.Array:
+		return JSONTypeArray
+	default:
+		return ""
+	}
+}
+
+func marshaledType(value any) string {
+	encoded, err := marshalJSON(value)
+	if err != nil || len(encoded) == 0 {
+		return ""
+	}
+	switch encoded[0] {
+	case '"':
+		return JSONTypeString
+	case '{':
+		return JSONTypeObject
+	case '[':
+		return JSONTypeArray
+	case 't', 'f':
+		return JSONTypeBoolean
+	case 'n':
+		return ""
+	default:
+		return JSONTypeNumber
+	}
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logrus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldTypeRegistry(t *testing.T) {
+	format := func(t *testing.T, c *JSONFormatter, data logrus.Fields) map[string]any {
+		t.Helper()
+		result, err := c.Format(&logrus.Entry{
+			Level:   logrus.InfoLevel,
+			Time:    time.Now(),
+			Message: "msg",
+			Data:    data,
+		})
+		require.NoError(t, err)
+
+		var output map[string]any
+		require.NoError(t, json.Unmarshal(result, &output))
+		delete(output, "level")
+		delete(output, "msg")
+		delete(output, "time")
+		return output
+	}
+
+	t.Run("renames conflicting values", func(t *testing.T) {
+		for _, fastEncoding := range []bool{false, true} {
+			registry := NewFieldTypeRegistry(TypeConflictRename)
+			c := &JSONFormatter{TypeRegistry: registry, FastEncoding: fastEncoding}
+
+			require.Equal(t, map[string]any{"userId": float64(42)}, format(t, c, logrus.Fields{"userId": 42}))
+			require.Equal(t, map[string]any{"userId_string": "abc"}, format(t, c, logrus.Fields{"userId": "abc"}))
+			require.Equal(t, map[string]any{"userId_string": "def"}, format(t, c, logrus.Fields{"userId": "def"}))
+			require.Equal(t, map[string]any{"userId": float64(7)}, format(t, c, logrus.Fields{"userId": 7}))
+
+			require.Equal(t, []TypeConflict{
+				{Path: "userId", Expected: JSONTypeNumber, Found: JSONTypeString, Count: 2},
+			}, registry.Conflicts())
+		}
+	})
+
+	t.Run("stringifies values of string keys", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictStringify)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		require.Equal(t, map[string]any{"userId": "abc", "count": float64(1)}, format(t, c, logrus.Fields{"userId": "abc", "count": 1}))
+		require.Equal(t,
+			map[string]any{"userId": "42", "count_string": "many"},
+			format(t, c, logrus.Fields{"userId": 42, "count": "many"}),
+			"values of non string keys are renamed",
+		)
+		require.Equal(t, map[string]any{"userId": `{"id":1}`}, format(t, c, logrus.Fields{"userId": map[string]any{"id": 1}}))
+
+		require.Equal(t, []TypeConflict{
+			{Path: "count", Expected: JSONTypeNumber, Found: JSONTypeString, Count: 1},
+			{Path: "userId", Expected: JSONTypeString, Found: JSONTypeNumber, Count: 1},
+			{Path: "userId", Expected: JSONTypeString, Found: JSONTypeObject, Count: 1},
+		}, registry.Conflicts())
+	})
+
+	t.Run("tracks nested maps", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		format(t, c, logrus.Fields{"user": map[string]any{"id": 1, "tags": []string{"a"}}})
+		require.Equal(t,
+			map[string]any{"user": map[string]any{"id_string": "1", "tags": []any{"b"}}},
+			format(t, c, logrus.Fields{"user": map[string]any{"id": "1", "tags": []string{"b"}}}),
+		)
+		require.Equal(t, []TypeConflict{
+			{Path: "user.id", Expected: JSONTypeNumber, Found: JSONTypeString, Count: 1},
+		}, registry.Conflicts())
+		require.Equal(t, map[string]string{
+			"user":      JSONTypeObject,
+			"user.id":   JSONTypeNumber,
+			"user.tags": JSONTypeArray,
+		}, registry.Types())
+	})
+
+	t.Run("does not modify the entry data", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		format(t, c, logrus.Fields{"user": map[string]any{"id": 1}})
+		data := logrus.Fields{"user": map[string]any{"id": "1"}}
+		format(t, c, data)
+		require.Equal(t, logrus.Fields{"user": map[string]any{"id": "1"}}, data)
+	})
+
+	t.Run("ignores null values", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		format(t, c, logrus.Fields{"value": nil, "pointer": (*utils.URL)(nil)})
+		format(t, c, logrus.Fields{"value": "set", "pointer": &utils.URL{Path: "/"}})
+		require.Empty(t, registry.Conflicts())
+		require.Equal(t, map[string]string{"value": JSONTypeString, "pointer": JSONTypeObject}, registry.Types())
+	})
+
+	t.Run("does not overwrite fields when renaming", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		format(t, c, logrus.Fields{"userId": 42})
+		require.Equal(t,
+			map[string]any{"userId": "abc", "userId_string": "other"},
+			format(t, c, logrus.Fields{"userId": "abc", "userId_string": "other"}),
+		)
+	})
+
+	t.Run("survives panicking marshalers", func(t *testing.T) {
+		for _, strategy := range []TypeConflictStrategy{TypeConflictRename, TypeConflictStringify} {
+			registry := NewFieldTypeRegistry(strategy)
+			c := &JSONFormatter{TypeRegistry: registry}
+
+			format(t, c, logrus.Fields{"value": "text"})
+			output := format(t, c, logrus.Fields{"value": panickingMarshaler{}, "other": 1})
+			require.Equal(t, UnserializableValue, output["value"])
+			require.Equal(t, float64(1), output["other"])
+		}
+	})
+
+	t.Run("stops at the maximum depth", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		cyclicMap := map[string]any{}
+		cyclicMap["self"] = cyclicMap
+		result, err := c.Format(&logrus.Entry{Level: logrus.InfoLevel, Message: "msg", Data: logrus.Fields{"cyclic": cyclicMap}})
+		require.NoError(t, err)
+		require.Contains(t, string(result), UnserializableValue)
+		require.Len(t, registry.Types(), maxFastDepth)
+	})
+
+	t.Run("tracks a bounded number of paths", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		data := make(logrus.Fields, maxRegisteredPaths)
+		for i := 0; i < maxRegisteredPaths; i++ {
+			data[fmt.Sprintf("key%d", i)] = i
+		}
+		format(t, c, data)
+		require.Len(t, registry.Types(), maxRegisteredPaths)
+
+		require.Equal(t, map[string]any{"late": float64(1)}, format(t, c, logrus.Fields{"late": 1}))
+		require.Equal(t, map[string]any{"late": "one"}, format(t, c, logrus.Fields{"late": "one"}))
+		require.Len(t, registry.Types(), maxRegisteredPaths)
+		require.Empty(t, registry.Conflicts())
+	})
+
+	t.Run("detects the type of any value", func(t *testing.T) {
+		type custom struct{ Name string }
+		testCases := []struct {
+			value    any
+			expected string
+		}{
+			{value: "s", expected: JSONTypeString},
+			{value: fmt.Errorf("err"), expected: JSONTypeString},
+			{value: time.Now(), expected: JSONTypeString},
+			{value: []byte("bytes"), expected: JSONTypeString},
+			{value: true, expected: JSONTypeBoolean},
+			{value: 1, expected: JSONTypeNumber},
+			{value: uint8(1), expected: JSONTypeNumber},
+			{value: 1.5, expected: JSONTypeNumber},
+			{value: time.Second, expected: JSONTypeNumber},
+			{value: json.Number("1"), expected: JSONTypeNumber},
+			{value: []int{1}, expected: JSONTypeArray},
+			{value: [2]string{}, expected: JSONTypeArray},
+			{value: custom{}, expected: JSONTypeObject},
+			{value: &custom{}, expected: JSONTypeObject},
+			{value: map[string]int{}, expected: JSONTypeObject},
+			{value: utils.HTTP{}, expected: JSONTypeObject},
+			{value: json.RawMessage(`[1]`), expected: JSONTypeArray},
+			{value: nil, expected: ""},
+			{value: []int(nil), expected: ""},
+			{value: make(chan int), expected: ""},
+		}
+		for _, testCase := range testCases {
+			require.Equal(t, testCase.expected, jsonType(testCase.value), "%T", testCase.value)
+		}
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		registry := NewFieldTypeRegistry(TypeConflictRename)
+		c := &JSONFormatter{TypeRegistry: registry}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					var value any = j
+					if i%2 == 0 {
+						value = fmt.Sprint(j)
+					}
+					_, err := c.Format(&logrus.Entry{Time: time.Now(), Data: logrus.Fields{"field": value}})
+					require.NoError(t, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		conflicts := registry.Conflicts()
+		require.Len(t, conflicts, 1)
+		require.Equal(t, 500, conflicts[0].Count)
+	})
+}
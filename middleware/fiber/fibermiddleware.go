@@ -2,7 +2,6 @@ package fiber
 
 import (
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,6 +13,9 @@ import (
 type fiberLoggingContext struct {
 	c          *fiber.Ctx
 	handlerErr error
+	// middlewareRoute is the route of the middleware, set until the request
+	// is routed.
+	middlewareRoute *fiber.Route
 }
 
 func (flc *fiberLoggingContext) Request() glogger.RequestLoggingContext {
@@ -28,10 +30,39 @@ func (flc *fiberLoggingContext) GetHeader(key string) string {
 	return flc.c.Get(key, "")
 }
 
+func (flc *fiberLoggingContext) RequestHeaderValues(key string) []string {
+	return headerValues(flc.c.Request().Header.PeekAll(key))
+}
+
 func (flc *fiberLoggingContext) URI() string {
 	return string(flc.c.Request().URI().RequestURI())
 }
 
+// Route returns the path of the matched route, e.g. /users/:id, once the
+// request is routed.
+func (flc *fiberLoggingContext) Route() string {
+	if flc.middlewareRoute != nil {
+		return ""
+	}
+	route := flc.c.Route()
+	if route == nil || len(route.Handlers) == 0 {
+		return ""
+	}
+	return route.Path
+}
+
+func (flc *fiberLoggingContext) RemoteAddr() string {
+	return flc.c.IP()
+}
+
+// RequestBody returns the request body, unless it is streamed.
+func (flc *fiberLoggingContext) RequestBody() []byte {
+	if flc.c.Request().IsBodyStream() {
+		return nil
+	}
+	return flc.c.Request().Body()
+}
+
 func (flc *fiberLoggingContext) Host() string {
 	return string(flc.c.Request().Host())
 }
@@ -51,6 +82,39 @@ func (flc *fiberLoggingContext) setError(err error) {
 	flc.handlerErr = err
 }
 
+func (flc *fiberLoggingContext) ResponseHeader(key string) string {
+	return flc.c.GetRespHeader(key)
+}
+
+func (flc *fiberLoggingContext) ResponseHeaderValues(key string) []string {
+	return headerValues(flc.c.Response().Header.PeekAll(key))
+}
+
+// headerValues copies the header values, only valid until the request is
+// released by fasthttp.
+func headerValues(values [][]byte) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	copied := make([]string, 0, len(values))
+	for _, value := range values {
+		copied = append(copied, string(value))
+	}
+	return copied
+}
+
+// ResponseBody returns the response body, unless it is streamed. The body of
+// the errors not handled yet is the error message.
+func (flc *fiberLoggingContext) ResponseBody() []byte {
+	if fiberErr := flc.getFiberError(); fiberErr != nil {
+		return []byte(fiberErr.Error())
+	}
+	if flc.c.Response().IsBodyStream() {
+		return nil
+	}
+	return flc.c.Response().Body()
+}
+
 func (flc *fiberLoggingContext) BodySize() int {
 	if fiberErr := flc.getFiberError(); fiberErr != nil {
 		return len(fiberErr.Error())
@@ -61,6 +125,10 @@ func (flc *fiberLoggingContext) BodySize() int {
 			return length
 		}
 	}
+	// reading a streamed body would buffer it all
+	if flc.c.Response().IsBodyStream() {
+		return 0
+	}
 	return len(flc.c.Response().Body())
 }
 
@@ -73,31 +141,48 @@ func (flc *fiberLoggingContext) StatusCode() int {
 }
 
 // RequestMiddlewareLogger is a fiber middleware to log all requests
-// It logs the incoming request and when request is completed, adding latency of the request
-func RequestMiddlewareLogger[Logger any](logger core.Logger[Logger], excludedPrefix []string) func(*fiber.Ctx) error {
+// It logs the incoming request and when request is completed, adding latency of the request.
+// The requests whose path starts with one of excludedPrefix are not logged, and
+// options add more rules to choose the logged requests. When the rules read
+// the route, e.g. with the Route matcher, the incoming request is logged after
+// routing. The logger is injected in the context of all the requests.
+func RequestMiddlewareLogger[Logger any](logger core.Logger[Logger], excludedPrefix []string, options ...utils.Option) func(*fiber.Ctx) error {
+	config := utils.NewOptions(excludedPrefix, options...)
 	return func(fiberCtx *fiber.Ctx) error {
-		fiberLoggingContext := &fiberLoggingContext{c: fiberCtx}
-
-		for _, prefix := range excludedPrefix {
-			if strings.HasPrefix(fiberLoggingContext.Request().URI(), prefix) {
-				return fiberCtx.Next()
-			}
-		}
+		fiberLoggingContext := &fiberLoggingContext{c: fiberCtx, middlewareRoute: fiberCtx.Route()}
 
 		start := time.Now()
 
-		requestID := utils.GetReqID(fiberLoggingContext)
-		loggerWithReqId := logger.WithContext(fiberCtx.UserContext()).WithFields(map[string]any{
-			"reqId": requestID,
-		})
-		ctx := glogger.WithLogger(fiberCtx.UserContext(), loggerWithReqId.OriginalLogger())
+		info := config.RequestInfo(fiberCtx.UserContext(), fiberLoggingContext)
+		if header := config.RequestIDResponseHeader(); header != "" {
+			fiberCtx.Set(header, info.RequestID)
+		}
+		ctx := fiberCtx.UserContext()
+		if info.TraceContext.IsValid() {
+			ctx = utils.ContextWithTraceContext(ctx, info.TraceContext)
+		}
+		loggerWithReqId := logger.WithContext(ctx).WithFields(info.Fields())
+		ctx = glogger.WithLogger(ctx, loggerWithReqId.OriginalLogger())
 		fiberCtx.SetUserContext(ctx)
 
-		utils.LogIncomingRequest[Logger](fiberLoggingContext, loggerWithReqId)
+		// when the rules read the route, the incoming request entry waits for
+		// routing, so that both the entries are logged or none is
+		shouldLog, routed := config.ShouldLogBeforeRouting(fiberLoggingContext.Request())
+		if shouldLog && routed {
+			loggerWithReqId.WithFields(config.IncomingRequestFields(fiberLoggingContext)).Trace(utils.IncomingRequestMessage)
+		}
 		err := fiberCtx.Next()
 		fiberLoggingContext.setError(err)
+		if fiberLoggingContext.c.Route() != fiberLoggingContext.middlewareRoute {
+			fiberLoggingContext.middlewareRoute = nil
+		}
 
-		utils.LogRequestCompleted[Logger](fiberLoggingContext, loggerWithReqId, start)
+		if config.ShouldLog(fiberLoggingContext.Request()) {
+			if !routed {
+				loggerWithReqId.WithFields(config.IncomingRequestFields(fiberLoggingContext)).Trace(utils.IncomingRequestMessage)
+			}
+			loggerWithReqId.WithFields(config.RequestCompletedFields(fiberLoggingContext, start)).Info(utils.RequestCompletedMessage)
+		}
 
 		return err
 	}
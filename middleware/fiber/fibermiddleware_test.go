@@ -1,8 +1,10 @@
 package fiber
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -133,6 +135,7 @@ func TestFiberLogMiddleware(t *testing.T) {
 							Bytes: bodyBytes,
 						},
 					},
+					Route: path,
 				},
 				"url": utils.URL{Path: reqPath},
 				"host": utils.Host{
@@ -171,7 +174,7 @@ func TestFiberLogMiddleware(t *testing.T) {
 						},
 					},
 				},
-				"url": utils.URL{Path: pathWithQuery},
+				"url": utils.URL{Path: path, Query: map[string][]string{"foo": {"bar"}, "some": {"other"}}},
 				"host": utils.Host{
 					ForwardedHost: clientHost,
 					Hostname:      mockHostname,
@@ -200,8 +203,9 @@ func TestFiberLogMiddleware(t *testing.T) {
 							Bytes: bodyBytes,
 						},
 					},
+					Route: path,
 				},
-				"url": utils.URL{Path: pathWithQuery},
+				"url": utils.URL{Path: path, Query: map[string][]string{"foo": {"bar"}, "some": {"other"}}},
 				"host": utils.Host{
 					ForwardedHost: clientHost,
 					Hostname:      mockHostname,
@@ -294,6 +298,7 @@ func TestFiberLogMiddleware(t *testing.T) {
 							Bytes: 10,
 						},
 					},
+					Route: path,
 				},
 				"url": utils.URL{Path: path},
 				"host": utils.Host{
@@ -338,6 +343,7 @@ func TestFiberLogMiddleware(t *testing.T) {
 							Bytes: len(contentToWrite),
 						},
 					},
+					Route: path,
 				},
 				"url": utils.URL{Path: path},
 				"host": utils.Host{
@@ -366,6 +372,333 @@ func TestFiberLogMiddleware(t *testing.T) {
 		require.Len(t, records, 0, "Unexpected entries length.")
 	})
 
+	t.Run("inject logger in skipped paths", func(t *testing.T) {
+		records := testMockFiberMiddlewareInvocation(nil, func(c *fiber.Ctx) error {
+			logger := glogger.GetOrDie[core.Logger[*fake.Entry]](c.UserContext())
+			logger.Info("ok")
+			return nil
+		}, "my-req-id", mockHostname, "/-/healthz")
+
+		require.Len(t, records, 1, "Unexpected entries length.")
+		require.Equal(t, "my-req-id", records[0].Fields["reqId"])
+	})
+
+	t.Run("exclude requests with options", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil,
+			utils.Exclude(utils.Header("user-agent", "^kube-probe/")),
+		))
+		app.Get("/healthz", func(c *fiber.Ctx) error { return nil })
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz?probe=liveness", nil)
+		req.Header.Add("user-agent", "kube-probe/1.29")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+		require.Len(t, glog.OriginalLogger().AllRecords(), 0, "Unexpected entries length.")
+
+		req = httptest.NewRequest(http.MethodGet, "/healthz?probe=liveness", nil)
+		req.Header.Add("user-agent", userAgent)
+		_, err = app.Test(req)
+		require.NoError(t, err)
+		require.Len(t, glog.OriginalLogger().AllRecords(), 2, "Unexpected entries length.")
+	})
+
+	t.Run("echo the request id in the response", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil,
+			utils.RequestIDHeaders("x-request-id", "x-correlation-id"),
+			utils.EchoRequestID("x-request-id"),
+		))
+		app.Get("/my-req", func(c *fiber.Ctx) error { return nil })
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("x-correlation-id", "my-req-id")
+		res, err := app.Test(req)
+		require.NoError(t, err)
+
+		require.Equal(t, "my-req-id", res.Header.Get("x-request-id"))
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, "my-req-id", records[1].Fields["reqId"])
+	})
+
+	t.Run("trace context", func(t *testing.T) {
+		const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		var tc utils.TraceContext
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.WithTraceContext()))
+		app.Get("/my-req", func(c *fiber.Ctx) error {
+			tc, _ = utils.TraceContextFromContext(c.UserContext())
+			return nil
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		require.Equal(t, traceID, tc.TraceID)
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		for _, record := range records {
+			require.Equal(t, utils.Trace{ID: traceID}, record.Fields["trace"])
+			require.Equal(t, utils.Span{ID: tc.SpanID}, record.Fields["span"])
+		}
+	})
+
+	t.Run("trace context from b3 headers", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.WithTraceExtractors(utils.ExtractW3C, utils.ExtractB3)))
+		app.Get("/my-req", func(c *fiber.Ctx) error { return nil })
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("x-b3-traceid", "a3ce929d0e0e4736")
+		req.Header.Add("x-b3-spanid", "00f067aa0ba902b7")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.Trace{ID: "0000000000000000a3ce929d0e0e4736"}, records[1].Fields["trace"])
+	})
+
+	t.Run("route template", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.Exclude(utils.Route("/-/healthz"))))
+		app.Get("/users/:id", func(c *fiber.Ctx) error { return nil })
+		app.Get("/-/healthz", func(c *fiber.Ctx) error { return nil })
+
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, "/-/healthz", nil))
+		require.NoError(t, err)
+		_, err = app.Test(httptest.NewRequest(http.MethodGet, "/users/42?verbose=true", nil))
+		require.NoError(t, err)
+
+		// the incoming request entries wait for routing, as the rules read the route
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.IncomingRequestMessage, records[0].Message)
+		require.Equal(t, "/users/:id", records[0].Fields["http"].(utils.HTTP).Route)
+		require.Equal(t, utils.RequestCompletedMessage, records[1].Message)
+		require.Equal(t, "/users/:id", records[1].Fields["http"].(utils.HTTP).Route)
+		require.Equal(t, utils.URL{Path: "/users/42", Query: map[string][]string{"verbose": {"true"}}}, records[1].Fields["url"])
+	})
+
+	t.Run("wrapped route matcher", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		notHealthz := func(req glogger.RequestLoggingContext) bool {
+			return utils.RequestRoute(req) != "/-/healthz"
+		}
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.Include(notHealthz)))
+		app.Get("/users/:id", func(c *fiber.Ctx) error { return nil })
+		app.Get("/-/healthz", func(c *fiber.Ctx) error { return nil })
+
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, "/-/healthz", nil))
+		require.NoError(t, err)
+		_, err = app.Test(httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.IncomingRequestMessage, records[0].Message)
+		require.Equal(t, utils.RequestCompletedMessage, records[1].Message)
+		require.Equal(t, "/users/:id", records[1].Fields["http"].(utils.HTTP).Route)
+	})
+
+	t.Run("incoming request before routing without route rules", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil))
+		app.Get("/users/:id", func(c *fiber.Ctx) error {
+			glogger.GetOrDie[core.Logger[*fake.Entry]](c.UserContext()).Info("in handler")
+			return nil
+		})
+
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 3, "Unexpected entries length.")
+		require.Equal(t, utils.IncomingRequestMessage, records[0].Message)
+		require.Empty(t, records[0].Fields["http"].(utils.HTTP).Route)
+		require.Equal(t, "in handler", records[1].Message)
+	})
+
+	t.Run("include by route template", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.Include(utils.Route("/users/:id"))))
+		app.Get("/users/:id", func(c *fiber.Ctx) error { return nil })
+		app.Get("/-/healthz", func(c *fiber.Ctx) error { return nil })
+
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.IncomingRequestMessage, records[0].Message)
+		require.Equal(t, utils.RequestCompletedMessage, records[1].Message)
+		require.Equal(t, "/users/:id", records[1].Fields["http"].(utils.HTTP).Route)
+	})
+
+	t.Run("sensitive query parameters", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.QueryAllowList("page", "token")))
+		app.Get("/my-req", func(c *fiber.Ctx) error { return nil })
+
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, "/my-req?page=2&token=abc&debug=1", nil))
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.URL{
+			Path:  path,
+			Query: map[string][]string{"page": {"2"}, "token": {utils.DefaultRedactionMask}},
+		}, records[1].Fields["url"])
+	})
+
+	t.Run("client ip behind trusted proxies", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		// the requests of app.Test come from 0.0.0.0
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.TrustedProxies("0.0.0.0", "10.0.0.0/8")))
+		app.Get("/my-req", func(c *fiber.Ctx) error { return nil })
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("x-forwarded-for", "198.51.100.1, 203.0.113.7, 10.0.0.2")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		host := records[1].Fields["host"].(utils.Host)
+		require.Equal(t, "203.0.113.7", host.IP)
+		require.Equal(t, []string{"198.51.100.1", "203.0.113.7", "10.0.0.2", "0.0.0.0"}, host.ProxyChain)
+	})
+
+	t.Run("allow-listed headers", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil,
+			utils.LogRequestHeaders("Accept", "Authorization"),
+			utils.LogResponseHeaders("Content-Type"),
+		))
+		app.Get("/my-req", func(c *fiber.Ctx) error {
+			c.Set("Content-Type", "text/plain")
+			return nil
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("accept", "application/json")
+		req.Header.Add("authorization", "Bearer secret")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		requestHeaders := map[string]string{"accept": "application/json", "authorization": utils.DefaultRedactionMask}
+		require.Equal(t, requestHeaders, records[0].Fields["http"].(utils.HTTP).Request.Headers)
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, requestHeaders, completed.Request.Headers)
+		require.Equal(t, map[string]string{"content-type": "text/plain"}, completed.Response.Headers)
+	})
+
+	t.Run("repeated headers", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil,
+			utils.LogRequestHeaders("Via"),
+			utils.LogResponseHeaders("Vary"),
+		))
+		app.Get("/my-req", func(c *fiber.Ctx) error {
+			c.Response().Header.Add("Vary", "Accept")
+			c.Response().Header.Add("Vary", "Accept-Encoding")
+			return nil
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("Via", "1.1 proxy-a")
+		req.Header.Add("Via", "1.1 proxy-b")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, map[string]string{"via": "1.1 proxy-a, 1.1 proxy-b"}, completed.Request.Headers)
+		require.Equal(t, map[string]string{"vary": "Accept, Accept-Encoding"}, completed.Response.Headers)
+	})
+
+	t.Run("request and response bodies", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.LogBodies(1024)))
+		app.Post("/my-req", func(c *fiber.Ctx) error {
+			return c.Status(http.StatusCreated).SendString("created")
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/my-req", strings.NewReader(`{"name":"me","password":"secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, &utils.RequestBody{Content: `{"name":"me","password":"[REDACTED]"}`}, completed.Request.Body)
+		require.Equal(t, utils.ResponseBody{Bytes: 7, Content: "created"}, completed.Response.Body)
+	})
+
+	t.Run("bodies of the error responses", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.LogBodies(1024), utils.BodyStatusClasses(4, 5)))
+		app.Get("/my-req", func(c *fiber.Ctx) error { return c.SendString("ok") })
+		app.Get("/bad", func(c *fiber.Ctx) error { return fiber.NewError(http.StatusBadRequest, "invalid id") })
+
+		for _, target := range []string{"/my-req", "/bad"} {
+			_, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+			require.NoError(t, err)
+		}
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 4, "Unexpected entries length.")
+		require.Equal(t, utils.ResponseBody{Bytes: 2}, records[1].Fields["http"].(utils.HTTP).Response.Body)
+		require.Equal(t, utils.ResponseBody{Bytes: 10, Content: "invalid id"}, records[3].Fields["http"].(utils.HTTP).Response.Body)
+	})
+
+	t.Run("streamed response body", func(t *testing.T) {
+		glog := fake.GetLogger()
+		app := fiber.New()
+		app.Use(RequestMiddlewareLogger(glog, nil, utils.LogBodies(8)))
+		app.Get("/my-req", func(c *fiber.Ctx) error {
+			c.Set("Content-Type", "text/event-stream")
+			c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < 3; i++ {
+					w.WriteString("data: chunk\n\n")
+					w.Flush()
+				}
+			})
+			return nil
+		})
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, "/my-req", nil))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("data: chunk\n\n", 3), string(body))
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.ResponseBody{}, records[1].Fields["http"].(utils.HTTP).Response.Body, "the stream is not read by the middleware")
+	})
+
 	t.Run("middleware correctly create request id if not present in header", func(t *testing.T) {
 		const statusCode = 400
 
@@ -452,6 +785,7 @@ func TestFiberLogMiddleware(t *testing.T) {
 							Bytes: bodyBytes,
 						},
 					},
+					Route: path,
 				},
 				"url": utils.URL{Path: reqPath},
 				"host": utils.Host{
@@ -3,7 +3,6 @@ package mux
 import (
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,10 +14,12 @@ import (
 type muxLoggingContext struct {
 	req *http.Request
 	res *readableResponseWriter
+	// body keeps the beginning of the request body, when it is logged
+	body *utils.BodyBuffer
 }
 
 func (mlc *muxLoggingContext) Request() glogger.RequestLoggingContext {
-	return &muxRequestLoggingContext{mlc.req}
+	return &muxRequestLoggingContext{mlc.req, mlc.body}
 }
 
 func (mlc *muxLoggingContext) Response() glogger.ResponseLoggingContext {
@@ -26,17 +27,41 @@ func (mlc *muxLoggingContext) Response() glogger.ResponseLoggingContext {
 }
 
 type muxRequestLoggingContext struct {
-	req *http.Request
+	req  *http.Request
+	body *utils.BodyBuffer
 }
 
 func (mrlc *muxRequestLoggingContext) GetHeader(key string) string {
 	return mrlc.req.Header.Get(key)
 }
 
+func (mrlc *muxRequestLoggingContext) RequestHeaderValues(key string) []string {
+	return mrlc.req.Header.Values(key)
+}
+
 func (mrlc *muxRequestLoggingContext) URI() string {
 	return mrlc.req.URL.RequestURI()
 }
 
+// Route returns the path template of the matched route, e.g. /users/{id}.
+func (mrlc *muxRequestLoggingContext) Route() string {
+	if route := mux.CurrentRoute(mrlc.req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return ""
+}
+
+func (mrlc *muxRequestLoggingContext) RemoteAddr() string {
+	return mrlc.req.RemoteAddr
+}
+
+// RequestBody returns the part of the request body read by the handler.
+func (mrlc *muxRequestLoggingContext) RequestBody() []byte {
+	return mrlc.body.Bytes()
+}
+
 func (mrlc *muxRequestLoggingContext) Host() string {
 	return mrlc.req.Host
 }
@@ -49,6 +74,18 @@ type muxResponseLoggingContext struct {
 	res *readableResponseWriter
 }
 
+func (mrlc *muxResponseLoggingContext) ResponseHeader(key string) string {
+	return mrlc.res.Header().Get(key)
+}
+
+func (mrlc *muxResponseLoggingContext) ResponseHeaderValues(key string) []string {
+	return mrlc.res.Header().Values(key)
+}
+
+func (mrlc *muxResponseLoggingContext) ResponseBody() []byte {
+	return mrlc.res.body.Bytes()
+}
+
 func (mrlc *muxResponseLoggingContext) BodySize() int {
 	if content := mrlc.res.Header().Get("Content-Length"); content != "" {
 		if length, err := strconv.Atoi(content); err == nil {
@@ -63,8 +100,12 @@ func (mrlc *muxResponseLoggingContext) StatusCode() int {
 }
 
 // RequestMiddlewareLogger is a gorilla/mux middleware to log all requests
-// It logs the incoming request and when request is completed, adding latency of the request
-func RequestMiddlewareLogger[Logger any](logger core.Logger[Logger], excludedPrefix []string) mux.MiddlewareFunc {
+// It logs the incoming request and when request is completed, adding latency of the request.
+// The requests whose path starts with one of excludedPrefix are not logged, and
+// options add more rules to choose the logged requests. The logger is injected
+// in the context of all the requests.
+func RequestMiddlewareLogger[Logger any](logger core.Logger[Logger], excludedPrefix []string, options ...utils.Option) mux.MiddlewareFunc {
+	config := utils.NewOptions(excludedPrefix, options...)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -74,23 +115,31 @@ func RequestMiddlewareLogger[Logger any](logger core.Logger[Logger], excludedPre
 				res: &myw,
 			}
 
-			requestID := utils.GetReqID(muxLoggingContext)
-			loggerWithReqId := logger.WithContext(r.Context()).WithFields(map[string]any{
-				"reqId": requestID,
-			})
-			ctx := glogger.WithLogger(r.Context(), loggerWithReqId.OriginalLogger())
-
-			// Skip logging for excluded routes
-			for _, prefix := range excludedPrefix {
-				if strings.HasPrefix(r.URL.RequestURI(), prefix) {
-					next.ServeHTTP(&myw, r.WithContext(ctx))
-					return
-				}
+			info := config.RequestInfo(r.Context(), muxLoggingContext)
+			if header := config.RequestIDResponseHeader(); header != "" {
+				w.Header().Set(header, info.RequestID)
+			}
+			ctx := r.Context()
+			if info.TraceContext.IsValid() {
+				ctx = utils.ContextWithTraceContext(ctx, info.TraceContext)
 			}
+			loggerWithReqId := logger.WithContext(ctx).WithFields(info.Fields())
+			ctx = glogger.WithLogger(ctx, loggerWithReqId.OriginalLogger())
 
-			utils.LogIncomingRequest[Logger](muxLoggingContext, loggerWithReqId)
-			next.ServeHTTP(&myw, r.WithContext(ctx))
-			utils.LogRequestCompleted[Logger](muxLoggingContext, loggerWithReqId, start)
+			if !config.ShouldLog(muxLoggingContext.Request()) {
+				next.ServeHTTP(&myw, r.WithContext(ctx))
+				return
+			}
+
+			loggerWithReqId.WithFields(config.IncomingRequestFields(muxLoggingContext)).Trace(utils.IncomingRequestMessage)
+			req := r.WithContext(ctx)
+			muxLoggingContext.body = config.NewBodyBuffer(muxLoggingContext.Request())
+			myw.body = config.NewBodyBuffer(muxLoggingContext.Request())
+			if muxLoggingContext.body != nil && req.Body != nil && req.Body != http.NoBody {
+				req.Body = &bodyReader{ReadCloser: req.Body, body: muxLoggingContext.body}
+			}
+			next.ServeHTTP(&myw, req)
+			loggerWithReqId.WithFields(config.RequestCompletedFields(muxLoggingContext, start)).Info(utils.RequestCompletedMessage)
 		})
 	}
 }
@@ -19,10 +19,13 @@ package mux
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/mia-platform/glogger/v4"
 	"github.com/mia-platform/glogger/v4/loggers/core"
 	"github.com/mia-platform/glogger/v4/loggers/fake"
@@ -356,7 +359,7 @@ func TestMuxLogMiddleware(t *testing.T) {
 						},
 					},
 				},
-				"url": utils.URL{Path: pathWithQuery},
+				"url": utils.URL{Path: path, Query: map[string][]string{"foo": {"bar"}, "some": {"other"}}},
 				"host": utils.Host{
 					ForwardedHost: clientHost,
 					Hostname:      hostname,
@@ -380,6 +383,231 @@ func TestMuxLogMiddleware(t *testing.T) {
 		require.Len(t, records, 0, "Unexpected entries length.")
 	})
 
+	t.Run("inject logger in excluded endpoints", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := glogger.GetOrDie[core.Logger[*fake.Entry]](r.Context())
+			logger.Info("ok")
+		})
+		records := testMockMuxMiddlewareInvocation(nil, handler, "my-req-id", "/-/healthz")
+		require.Len(t, records, 1, "Unexpected entries length.")
+		require.Equal(t, "my-req-id", records[0].Fields["reqId"])
+	})
+
+	t.Run("exclude requests with options", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/healthz?probe=liveness", nil)
+		req.Header.Add("user-agent", "kube-probe/1.29")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil,
+			utils.Exclude(utils.Header("user-agent", "^kube-probe/")),
+		)
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+		require.Len(t, glog.OriginalLogger().AllRecords(), 0, "Unexpected entries length.")
+
+		req.Header.Set("user-agent", userAgent)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+		require.Len(t, glog.OriginalLogger().AllRecords(), 2, "Unexpected entries length.")
+	})
+
+	t.Run("echo the request id in the response", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("x-correlation-id", "my-req-id")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil,
+			utils.RequestIDHeaders("x-request-id", "x-correlation-id"),
+			utils.EchoRequestID("x-request-id"),
+		)
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		writer := httptest.NewRecorder()
+		server.ServeHTTP(writer, req)
+
+		require.Equal(t, "my-req-id", writer.Header().Get("x-request-id"))
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, "my-req-id", records[1].Fields["reqId"])
+	})
+
+	t.Run("trace context", func(t *testing.T) {
+		const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+		var tc utils.TraceContext
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.WithTraceContext())
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tc, _ = utils.TraceContextFromContext(r.Context())
+		}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.Equal(t, traceID, tc.TraceID)
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		for _, record := range records {
+			require.Equal(t, utils.Trace{ID: traceID}, record.Fields["trace"])
+			require.Equal(t, utils.Span{ID: tc.SpanID}, record.Fields["span"])
+		}
+	})
+
+	t.Run("trace context from b3 headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("x-b3-traceid", "a3ce929d0e0e4736")
+		req.Header.Add("x-b3-spanid", "00f067aa0ba902b7")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.WithTraceExtractors(utils.ExtractW3C, utils.ExtractB3))
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.Trace{ID: "0000000000000000a3ce929d0e0e4736"}, records[1].Fields["trace"])
+	})
+
+	t.Run("route template", func(t *testing.T) {
+		glog := fake.GetLogger()
+		router := mux.NewRouter()
+		router.Use(RequestMiddlewareLogger(glog, nil, utils.Exclude(utils.Route("/-/healthz"))))
+		router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+		router.HandleFunc("/-/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/-/healthz", nil))
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42?verbose=true", nil))
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		for _, record := range records {
+			require.Equal(t, "/users/{id}", record.Fields["http"].(utils.HTTP).Route)
+			require.Equal(t, utils.URL{Path: "/users/42", Query: map[string][]string{"verbose": {"true"}}}, record.Fields["url"])
+		}
+	})
+
+	t.Run("sensitive query parameters", func(t *testing.T) {
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.QueryAllowList("page", "token"))
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/my-req?page=2&token=abc&debug=1", nil))
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		require.Equal(t, utils.URL{
+			Path:  path,
+			Query: map[string][]string{"page": {"2"}, "token": {utils.DefaultRedactionMask}},
+		}, records[1].Fields["url"])
+	})
+
+	t.Run("client ip behind trusted proxies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.RemoteAddr = "10.0.0.1:4711"
+		req.Header.Add("x-forwarded-for", "198.51.100.1, 203.0.113.7, 10.0.0.2")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.TrustedProxies("10.0.0.0/8"))
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		host := records[1].Fields["host"].(utils.Host)
+		require.Equal(t, "203.0.113.7", host.IP)
+		require.Equal(t, []string{"198.51.100.1", "203.0.113.7", "10.0.0.2", "10.0.0.1"}, host.ProxyChain)
+	})
+
+	t.Run("allow-listed headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("accept", "application/json")
+		req.Header.Add("authorization", "Bearer secret")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil,
+			utils.LogRequestHeaders("Accept", "Authorization"),
+			utils.LogResponseHeaders("Content-Type"),
+		)
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+		}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		requestHeaders := map[string]string{"accept": "application/json", "authorization": utils.DefaultRedactionMask}
+		require.Equal(t, requestHeaders, records[0].Fields["http"].(utils.HTTP).Request.Headers)
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, requestHeaders, completed.Request.Headers)
+		require.Equal(t, map[string]string{"content-type": "text/plain"}, completed.Response.Headers)
+	})
+
+	t.Run("repeated headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/my-req", nil)
+		req.Header.Add("Via", "1.1 proxy-a")
+		req.Header.Add("Via", "1.1 proxy-b")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil,
+			utils.LogRequestHeaders("Via"),
+			utils.LogResponseHeaders("Vary"),
+		)
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			w.Header().Add("Vary", "Accept-Encoding")
+		}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, map[string]string{"via": "1.1 proxy-a, 1.1 proxy-b"}, completed.Request.Headers)
+		require.Equal(t, map[string]string{"vary": "Accept, Accept-Encoding"}, completed.Response.Headers)
+	})
+
+	t.Run("request and response bodies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/my-req", strings.NewReader(`{"name":"me","password":"secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.LogBodies(1024))
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, `{"name":"me","password":"secret"}`, string(body), "the handler reads the whole body")
+
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		}))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Equal(t, &utils.RequestBody{Content: `{"name":"me","password":"[REDACTED]"}`}, completed.Request.Body)
+		require.Equal(t, utils.ResponseBody{Bytes: 7, Content: "created"}, completed.Response.Body)
+	})
+
+	t.Run("streamed response body", func(t *testing.T) {
+		glog := fake.GetLogger()
+		loggerMiddleware := RequestMiddlewareLogger(glog, nil, utils.LogBodies(8))
+		server := loggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				w.Write([]byte("data: chunk\n\n"))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/my-req", nil))
+
+		require.True(t, recorder.Flushed)
+		require.Equal(t, strings.Repeat("data: chunk\n\n", 3), recorder.Body.String())
+		records := glog.OriginalLogger().AllRecords()
+		require.Len(t, records, 2, "Unexpected entries length.")
+		completed := records[1].Fields["http"].(utils.HTTP)
+		require.Nil(t, completed.Request.Body)
+		require.Equal(t, utils.ResponseBody{Bytes: 39, Content: "data: ch", Truncated: true}, completed.Response.Body)
+	})
+
 	t.Run("middleware correctly create request id if not present in header", func(t *testing.T) {
 		const statusCode = 400
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -19,8 +19,11 @@ package mux
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils"
 )
 
 // readableResponseWriter struct, add readable statusCode to ResponseWriter
@@ -28,6 +31,8 @@ type readableResponseWriter struct {
 	Writer     http.ResponseWriter
 	StatusCode int
 	length     int
+	// body keeps the beginning of the response body, when it is logged
+	body *utils.BodyBuffer
 }
 
 // WriteHeader func, set statusCode parameter
@@ -45,6 +50,7 @@ func (r *readableResponseWriter) Write(b []byte) (int, error) {
 	}
 
 	r.length += n
+	r.body.Write(b[:n])
 	return n, err
 }
 
@@ -72,3 +78,16 @@ func (r *readableResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 
 	return nil, nil, fmt.Errorf("the Hijacker interface is not supported")
 }
+
+// bodyReader keeps the beginning of the request body while the handler
+// reads it, so that the body is not read ahead of the handler
+type bodyReader struct {
+	io.ReadCloser
+	body *utils.BodyBuffer
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.body.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+// DefaultBodyContentTypes are the media types of the bodies logged by
+// default, with the syntax of path.Match.
+var DefaultBodyContentTypes = []string{
+	"text/*",
+	"application/json", "application/*+json",
+	"application/xml", "application/*+xml",
+	formMediaType,
+}
+
+// DefaultSensitiveBodyFields are the JSON and form fields whose values are
+// masked by default in the logged bodies.
+var DefaultSensitiveBodyFields = []string{
+	"password", "passwd", "pwd",
+	"secret", "client_secret",
+	"token", "access_token", "refresh_token", "id_token",
+	"apikey", "api_key", "private_key",
+}
+
+// formMediaType is the media type of the form bodies.
+const formMediaType = "application/x-www-form-urlencoded"
+
+// RequestBodyLoggingContext is implemented by the request logging contexts
+// exposing the beginning of the request body.
+type RequestBodyLoggingContext interface {
+	RequestBody() []byte
+}
+
+// ResponseBodyLoggingContext is implemented by the response logging contexts
+// exposing the beginning of the response body.
+type ResponseBodyLoggingContext interface {
+	ResponseBody() []byte
+}
+
+// LogBodies logs in the request completed entry the first maxBytes bytes of
+// the request and response bodies, in the http.request.body.content and
+// http.response.body.content fields. Only the bodies of the requests
+// matching one of the matchers are logged, or of all the requests without
+// matchers. The compressed bodies and the ones with a media type not in
+// DefaultBodyContentTypes are not logged, and the values of the sensitive
+// fields are masked, as set by SensitiveBodyFields.
+func LogBodies(maxBytes int, matchers ...RequestMatcher) Option {
+	return func(o *Options) {
+		o.bodyMaxBytes = maxBytes
+		o.bodyMatchers = matchers
+	}
+}
+
+// BodyStatusClasses logs the bodies only for the responses with the status
+// classes, e.g. 4 and 5 for the 4xx and 5xx responses.
+func BodyStatusClasses(classes ...int) Option {
+	return func(o *Options) {
+		o.bodyStatusClasses = make(map[int]bool, len(classes))
+		for _, class := range classes {
+			o.bodyStatusClasses[class] = true
+		}
+	}
+}
+
+// BodyContentTypes sets the media types of the logged bodies, replacing the
+// DefaultBodyContentTypes. The types are patterns with the syntax of
+// path.Match, e.g. application/*+json. It panics if a pattern is malformed.
+func BodyContentTypes(types ...string) Option {
+	for _, pattern := range types {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("invalid content type pattern %q: %w", pattern, err))
+		}
+	}
+	return func(o *Options) {
+		o.bodyContentTypes = types
+	}
+}
+
+// SensitiveBodyFields sets the JSON and form fields whose values are
+// replaced with DefaultRedactionMask in the logged bodies, compared case
+// insensitively. It replaces the DefaultSensitiveBodyFields.
+func SensitiveBodyFields(names ...string) Option {
+	return func(o *Options) {
+		o.bodyRedaction = newBodyRedaction(names)
+	}
+}
+
+// BodyBuffer keeps the beginning of the data written to it, to log a body
+// while it is streamed.
+type BodyBuffer struct {
+	limit int
+	data  []byte
+}
+
+// NewBodyBuffer returns a buffer keeping enough data to log a body of req,
+// or nil if its bodies are not logged.
+func (o *Options) NewBodyBuffer(req glogger.RequestLoggingContext) *BodyBuffer {
+	if o.bodyMaxBytes <= 0 || !o.logBodiesOf(req) {
+		return nil
+	}
+	// a byte more than the limit marks the truncated bodies
+	return &BodyBuffer{limit: o.bodyMaxBytes + 1}
+}
+
+// Write keeps the data up to the limit of the buffer, and never fails. It
+// does nothing on a nil buffer.
+func (b *BodyBuffer) Write(p []byte) (int, error) {
+	if b != nil && len(b.data) < b.limit {
+		n := len(p)
+		if available := b.limit - len(b.data); n > available {
+			n = available
+		}
+		b.data = append(b.data, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the data kept by the buffer.
+func (b *BodyBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+func (o *Options) logBodiesOf(req glogger.RequestLoggingContext) bool {
+	return len(o.bodyMatchers) == 0 || anyMatch(o.bodyMatchers, req)
+}
+
+// requestBody returns the http.request.body field.
+func (o *Options) requestBody(ctx glogger.LoggingContext) *RequestBody {
+	if !o.logBodies(ctx) {
+		return nil
+	}
+	bodyCtx, ok := ctx.Request().(RequestBodyLoggingContext)
+	if !ok {
+		return nil
+	}
+	req := ctx.Request()
+	content, truncated, ok := o.bodyContent(bodyCtx.RequestBody(), req.GetHeader("content-type"), req.GetHeader("content-encoding"))
+	if !ok {
+		return nil
+	}
+	return &RequestBody{Content: content, Truncated: truncated}
+}
+
+// responseBody returns the http.response.body field.
+func (o *Options) responseBody(ctx glogger.LoggingContext) ResponseBody {
+	body := ResponseBody{Bytes: ctx.Response().BodySize()}
+	if !o.logBodies(ctx) {
+		return body
+	}
+	bodyCtx, ok := ctx.Response().(ResponseBodyLoggingContext)
+	if !ok {
+		return body
+	}
+	var contentType, contentEncoding string
+	if headerCtx, ok := ctx.Response().(ResponseHeaderLoggingContext); ok {
+		contentType = headerCtx.ResponseHeader("content-type")
+		contentEncoding = headerCtx.ResponseHeader("content-encoding")
+	}
+	body.Content, body.Truncated, _ = o.bodyContent(bodyCtx.ResponseBody(), contentType, contentEncoding)
+	return body
+}
+
+func (o *Options) logBodies(ctx glogger.LoggingContext) bool {
+	if o.bodyMaxBytes <= 0 || !o.logBodiesOf(ctx.Request()) {
+		return false
+	}
+	return len(o.bodyStatusClasses) == 0 || o.bodyStatusClasses[ctx.Response().StatusCode()/100]
+}
+
+// bodyContent returns the content to log of a body, truncated at the limit
+// and redacted. It returns false for the empty, compressed or not textual
+// bodies.
+func (o *Options) bodyContent(data []byte, contentType, contentEncoding string) (string, bool, bool) {
+	if len(data) == 0 || (contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity")) {
+		return "", false, false
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	mediaType, ok := o.textualMediaType(contentType)
+	if !ok {
+		return "", false, false
+	}
+
+	truncated := len(data) > o.bodyMaxBytes
+	if truncated {
+		n := o.bodyMaxBytes
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		data = data[:n]
+	}
+	return o.bodyRedaction.redact(string(data), mediaType == formMediaType), truncated, true
+}
+
+// textualMediaType returns the media type of the content type, if it is
+// one of the logged ones.
+func (o *Options) textualMediaType(contentType string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	for _, pattern := range o.bodyContentTypes {
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return mediaType, true
+		}
+	}
+	return "", false
+}
+
+// bodyRedaction masks the values of the sensitive fields in JSON and form
+// bodies, JSON objects and arrays included. The values cut by the
+// truncation are masked too. The JSON fields are masked in any body, e.g.
+// in JSON lines, the form fields only in the form bodies.
+type bodyRedaction struct {
+	json *regexp.Regexp
+	form *regexp.Regexp
+}
+
+func newBodyRedaction(names []string) bodyRedaction {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != "" {
+			quoted = append(quoted, regexp.QuoteMeta(name))
+		}
+	}
+	if len(quoted) == 0 {
+		return bodyRedaction{}
+	}
+	alternatives := strings.Join(quoted, "|")
+	return bodyRedaction{
+		json: regexp.MustCompile(`(?i)"(?:` + alternatives + `)"\s*:\s*`),
+		form: regexp.MustCompile(`(?i)((?:^|&)(?:` + alternatives + `)=)[^&]*`),
+	}
+}
+
+func (r bodyRedaction) redact(content string, form bool) string {
+	if r.json == nil {
+		return content
+	}
+	if form {
+		return r.form.ReplaceAllString(content, `${1}`+DefaultRedactionMask)
+	}
+	return r.redactJSON(content)
+}
+
+// redactJSON replaces the values following the sensitive keys.
+func (r bodyRedaction) redactJSON(content string) string {
+	var redacted strings.Builder
+	for {
+		loc := r.json.FindStringIndex(content)
+		if loc == nil {
+			if redacted.Len() == 0 {
+				return content
+			}
+			redacted.WriteString(content)
+			return redacted.String()
+		}
+		redacted.WriteString(content[:loc[1]])
+		redacted.WriteString(`"` + DefaultRedactionMask + `"`)
+		content = content[loc[1]+jsonValueLength(content[loc[1]:]):]
+	}
+}
+
+// jsonValueLength returns the length of the JSON value at the beginning of
+// s, or the length of s when the value is truncated.
+func jsonValueLength(s string) int {
+	if s == "" {
+		return 0
+	}
+	switch s[0] {
+	case '"':
+		return jsonStringLength(s)
+	case '{', '[':
+		depth := 0
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				i += jsonStringLength(s[i:]) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(s)
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ',', '}', ']', ' ', '\t', '\r', '\n':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// jsonStringLength returns the length of the JSON string at the beginning
+// of s, quotes included, or the length of s when the string is truncated.
+func jsonStringLength(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
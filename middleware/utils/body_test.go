@@ -0,0 +1,234 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodies(t *testing.T) {
+	jsonRequest := fake.Request{
+		Headers: map[string]string{"content-type": "application/json; charset=utf-8"},
+		Body:    []byte(`{"user":"me","password":"p@ss","nested":{"Token":12}}`),
+	}
+	jsonResponse := fake.Response{
+		StatusCode: 500,
+		Headers:    map[string]string{"content-type": "application/problem+json"},
+		Body:       []byte(`{"title":"failed"}`),
+	}
+
+	testCases := []struct {
+		name             string
+		options          []Option
+		request          fake.Request
+		response         fake.Response
+		expectedRequest  *RequestBody
+		expectedResponse ResponseBody
+	}{
+		{
+			name:             "not logged by default",
+			request:          jsonRequest,
+			response:         jsonResponse,
+			expectedResponse: ResponseBody{},
+		},
+		{
+			name:             "redacted json bodies",
+			options:          []Option{LogBodies(1024)},
+			request:          jsonRequest,
+			response:         jsonResponse,
+			expectedRequest:  &RequestBody{Content: `{"user":"me","password":"[REDACTED]","nested":{"Token":"[REDACTED]"}}`},
+			expectedResponse: ResponseBody{Content: `{"title":"failed"}`},
+		},
+		{
+			name:    "generic names and form syntax in other bodies",
+			options: []Option{LogBodies(1024)},
+			request: fake.Request{
+				Headers: map[string]string{"content-type": "application/json"},
+				Body:    []byte(`{"key":"user-42","sig":"abc","query":"user=me&password=secret"}`),
+			},
+			response: fake.Response{
+				StatusCode: 200,
+				Headers:    map[string]string{"content-type": "text/plain"},
+				Body:       []byte("token=abc"),
+			},
+			expectedRequest:  &RequestBody{Content: `{"key":"user-42","sig":"abc","query":"user=me&password=secret"}`},
+			expectedResponse: ResponseBody{Content: "token=abc"},
+		},
+		{
+			name:    "truncated bodies",
+			options: []Option{LogBodies(17)},
+			request: fake.Request{
+				Headers: map[string]string{"content-type": "application/x-www-form-urlencoded"},
+				Body:    []byte("user=me&secret=abcdef"),
+			},
+			response: fake.Response{
+				StatusCode: 200,
+				Headers:    map[string]string{"content-type": "text/plain"},
+				Body:       []byte("caffè è buono è"),
+			},
+			expectedRequest:  &RequestBody{Content: "user=me&secret=[REDACTED]", Truncated: true},
+			expectedResponse: ResponseBody{Content: "caffè è buono ", Truncated: true},
+		},
+		{
+			name:            "content type detected",
+			options:         []Option{LogBodies(1024)},
+			request:         fake.Request{Body: []byte("plain text")},
+			response:        fake.Response{StatusCode: 200, Body: []byte{0x00, 0x01, 0x02}},
+			expectedRequest: &RequestBody{Content: "plain text"},
+		},
+		{
+			name:    "not textual or compressed bodies",
+			options: []Option{LogBodies(1024)},
+			request: fake.Request{
+				Headers: map[string]string{"content-type": "image/png"},
+				Body:    []byte("png"),
+			},
+			response: fake.Response{
+				StatusCode: 200,
+				Headers:    map[string]string{"content-type": "text/plain", "content-encoding": "gzip"},
+				Body:       []byte("gzip"),
+			},
+		},
+		{
+			name:             "custom content types and sensitive fields",
+			options:          []Option{LogBodies(1024), BodyContentTypes("application/json"), SensitiveBodyFields("user")},
+			request:          jsonRequest,
+			response:         jsonResponse,
+			expectedRequest:  &RequestBody{Content: `{"user":"[REDACTED]","password":"p@ss","nested":{"Token":12}}`},
+			expectedResponse: ResponseBody{},
+		},
+		{
+			name:             "matching status class",
+			options:          []Option{LogBodies(1024), BodyStatusClasses(4, 5)},
+			request:          fake.Request{Body: []byte("text")},
+			response:         jsonResponse,
+			expectedRequest:  &RequestBody{Content: "text"},
+			expectedResponse: ResponseBody{Content: `{"title":"failed"}`},
+		},
+		{
+			name:     "not matching status class",
+			options:  []Option{LogBodies(1024), BodyStatusClasses(4)},
+			request:  fake.Request{Body: []byte("text")},
+			response: jsonResponse,
+		},
+		{
+			name:     "not matching route",
+			options:  []Option{LogBodies(1024, Route("/users/{id}"))},
+			request:  fake.Request{Route: "/items", Body: []byte("text")},
+			response: jsonResponse,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			o := NewOptions(nil, testCase.options...)
+			ctx := fake.NewContext(context.Background(), testCase.request, testCase.response)
+
+			fields := o.RequestCompletedFields(ctx, time.Now())[HTTPKey].(HTTP)
+			require.Equal(t, testCase.expectedRequest, fields.Request.Body)
+			require.Equal(t, testCase.expectedResponse, fields.Response.Body)
+
+			incoming := o.IncomingRequestFields(ctx)[HTTPKey].(HTTP)
+			require.Nil(t, incoming.Request.Body, "the bodies are logged only on completion")
+		})
+	}
+
+	t.Run("invalid content type pattern", func(t *testing.T) {
+		require.Panics(t, func() { BodyContentTypes("text/[") })
+	})
+}
+
+func TestBodyRedaction(t *testing.T) {
+	redaction := newBodyRedaction([]string{"password", "token"})
+
+	testCases := []struct {
+		name     string
+		content  string
+		form     bool
+		expected string
+	}{
+		{
+			name:     "strings and scalars",
+			content:  `{"password": "a\"b", "token":12, "user":"me"}`,
+			expected: `{"password": "[REDACTED]", "token":"[REDACTED]", "user":"me"}`,
+		},
+		{
+			name:     "objects and arrays",
+			content:  `{"password":{"old":"a}","new":"b"},"token":[1,["x]"]],"user":"me"}`,
+			expected: `{"password":"[REDACTED]","token":"[REDACTED]","user":"me"}`,
+		},
+		{
+			name:     "truncated values",
+			content:  `{"user":"me","token":{"value":"ab`,
+			expected: `{"user":"me","token":"[REDACTED]"`,
+		},
+		{
+			name:     "form fields",
+			content:  "user=me&Password=secret",
+			form:     true,
+			expected: "user=me&Password=[REDACTED]",
+		},
+		{
+			name:     "form syntax in other bodies",
+			content:  "user=me&Password=secret",
+			expected: "user=me&Password=secret",
+		},
+		{
+			name:     "without sensitive fields",
+			content:  `{"user":"me"}`,
+			expected: `{"user":"me"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, redaction.redact(testCase.content, testCase.form))
+		})
+	}
+}
+
+func TestBodyBuffer(t *testing.T) {
+	t.Run("nil when bodies are not logged", func(t *testing.T) {
+		req := fake.NewContext(context.Background(), fake.Request{Route: "/items"}, fake.Response{}).Request()
+		require.Nil(t, NewOptions(nil).NewBodyBuffer(req))
+		require.Nil(t, NewOptions(nil, LogBodies(10, Route("/users"))).NewBodyBuffer(req))
+
+		var buffer *BodyBuffer
+		n, err := buffer.Write([]byte("data"))
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+		require.Nil(t, buffer.Bytes())
+	})
+
+	t.Run("keeps a byte more than the limit", func(t *testing.T) {
+		req := fake.NewContext(context.Background(), fake.Request{}, fake.Response{}).Request()
+		buffer := NewOptions(nil, LogBodies(4)).NewBodyBuffer(req)
+		require.NotNil(t, buffer)
+
+		for _, chunk := range []string{"ab", "cd", "ef"} {
+			n, err := buffer.Write([]byte(chunk))
+			require.NoError(t, err)
+			require.Equal(t, len(chunk), n)
+		}
+		require.Equal(t, []byte("abcde"), buffer.Bytes())
+	})
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+const (
+	forwardedHeaderKey = "forwarded"
+	realIPHeaderKey    = "x-real-ip"
+)
+
+// RemoteAddrLoggingContext is implemented by the request logging contexts
+// exposing the address of the peer sending the request.
+type RemoteAddrLoggingContext interface {
+	RemoteAddr() string
+}
+
+// TrustedProxies resolves the client IP of the requests, logged in the
+// host.ip field, trusting the forwarding headers set by the proxies with the
+// given addresses or CIDRs, e.g. 10.0.0.0/8. The addresses in the Forwarded
+// (RFC 7239) or X-Forwarded-For header are walked from the right, skipping
+// the trusted proxies: the first one not trusted is the client. Without
+// those headers, X-Real-IP is used. Requests not sent by a trusted proxy
+// have the peer address as client IP. The host.proxyChain field has the
+// forwarded addresses and the peer one.
+//
+// Without this option host.ip is the X-Forwarded-For header as it is, that
+// the clients can spoof. It panics if an address is malformed.
+func TrustedProxies(proxies ...string) Option {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			panic(fmt.Errorf("invalid trusted proxy %q: %w", proxy, err))
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return func(o *Options) {
+		o.resolveClientIP = true
+		o.trustedProxies = prefixes
+	}
+}
+
+func parsePrefix(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// host returns the host field of the request.
+func (o *Options) host(req glogger.RequestLoggingContext) Host {
+	host := Host{
+		ForwardedHost: req.GetHeader(forwardedHostHeaderKey),
+		Hostname:      removePort(req.Host()),
+	}
+	if !o.resolveClientIP {
+		host.IP = req.GetHeader(forwardedForHeaderKey)
+		return host
+	}
+	host.IP, host.ProxyChain = o.clientIP(req)
+	return host
+}
+
+// clientIP returns the client IP and the chain of addresses the request
+// went through, the peer address last.
+func (o *Options) clientIP(req glogger.RequestLoggingContext) (string, []string) {
+	var peer string
+	if remoteCtx, ok := req.(RemoteAddrLoggingContext); ok {
+		peer = stripPort(remoteCtx.RemoteAddr())
+	}
+
+	forwarded := forwardedFor(req.GetHeader(forwardedHeaderKey))
+	if len(forwarded) == 0 {
+		forwarded = splitList(req.GetHeader(forwardedForHeaderKey))
+	}
+	if len(forwarded) == 0 {
+		forwarded = splitList(req.GetHeader(realIPHeaderKey))
+	}
+	var chain []string
+	if len(forwarded) > 0 {
+		chain = append(chain, forwarded...)
+		if peer != "" {
+			chain = append(chain, peer)
+		}
+	}
+
+	if !o.trusted(peer) {
+		return peer, chain
+	}
+	client := peer
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := stripPort(forwarded[i])
+		if _, err := netip.ParseAddr(addr); err != nil {
+			// e.g. unknown or an obfuscated identifier: the last proxy
+			// reached is the closest known address to the client
+			break
+		}
+		client = addr
+		if !o.trusted(addr) {
+			break
+		}
+	}
+	return client, chain
+}
+
+func (o *Options) trusted(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range o.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for parameters of a Forwarded header, e.g.
+// for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711".
+func forwardedFor(header string) []string {
+	var addresses []string
+	for _, element := range splitList(header) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(key, "for") {
+				addresses = append(addresses, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return addresses
+}
+
+func splitList(header string) []string {
+	if header == "" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(header, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stripPort removes the port and the brackets of IPv6 addresses, e.g.
+// [2001:db8::1]:4711 or 192.0.2.1:8080.
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := TrustedProxies("10.0.0.0/8", "192.0.2.10", "2001:db8:cafe::/48")
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		options    []Option
+		expected   Host
+	}{
+		{
+			name:       "x-forwarded-for as it is without trusted proxies",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"x-forwarded-for": "203.0.113.7, 10.0.0.2"},
+			expected:   Host{IP: "203.0.113.7, 10.0.0.2"},
+		},
+		{
+			name:       "peer address without headers",
+			remoteAddr: "203.0.113.7:4711",
+			options:    []Option{trusted},
+			expected:   Host{IP: "203.0.113.7"},
+		},
+		{
+			name:       "headers ignored from untrusted peer",
+			remoteAddr: "198.51.100.1:4711",
+			headers:    map[string]string{"x-forwarded-for": "203.0.113.7"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "198.51.100.1", ProxyChain: []string{"203.0.113.7", "198.51.100.1"}},
+		},
+		{
+			name:       "x-forwarded-for walked from the right",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"x-forwarded-for": "1.1.1.1, 203.0.113.7, 192.0.2.10, 10.0.0.2"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "203.0.113.7", ProxyChain: []string{"1.1.1.1", "203.0.113.7", "192.0.2.10", "10.0.0.2", "10.0.0.1"}},
+		},
+		{
+			name:       "all trusted",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"x-forwarded-for": "10.0.0.3, 10.0.0.2"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "10.0.0.3", ProxyChain: []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}},
+		},
+		{
+			name:       "forwarded header preferred",
+			remoteAddr: "[2001:db8:cafe::1]:443",
+			headers: map[string]string{
+				"forwarded":       `for=198.51.100.17;proto=https, for="[2001:db8:cafe::17]:4711";by=10.0.0.1`,
+				"x-forwarded-for": "203.0.113.7",
+			},
+			options:  []Option{trusted},
+			expected: Host{IP: "198.51.100.17", ProxyChain: []string{"198.51.100.17", "[2001:db8:cafe::17]:4711", "2001:db8:cafe::1"}},
+		},
+		{
+			name:       "obfuscated address",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"forwarded": "for=unknown, for=10.0.0.2"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "10.0.0.2", ProxyChain: []string{"unknown", "10.0.0.2", "10.0.0.1"}},
+		},
+		{
+			name:       "x-real-ip",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"x-real-ip": "203.0.113.7"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "203.0.113.7", ProxyChain: []string{"203.0.113.7", "10.0.0.1"}},
+		},
+		{
+			name:       "ipv4 mapped peer",
+			remoteAddr: "[::ffff:10.0.0.1]:4711",
+			headers:    map[string]string{"x-forwarded-for": "203.0.113.7"},
+			options:    []Option{trusted},
+			expected:   Host{IP: "203.0.113.7", ProxyChain: []string{"203.0.113.7", "::ffff:10.0.0.1"}},
+		},
+		{
+			name:       "nothing trusted",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"x-forwarded-for": "203.0.113.7"},
+			options:    []Option{TrustedProxies()},
+			expected:   Host{IP: "10.0.0.1", ProxyChain: []string{"203.0.113.7", "10.0.0.1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := fake.NewContext(context.Background(), fake.Request{Headers: tc.headers, RemoteAddr: tc.remoteAddr}, fake.Response{})
+			tc.expected.Hostname = "echo-service"
+			require.Equal(t, tc.expected, NewOptions(nil, tc.options...).IncomingRequestFields(ctx)[HostKey])
+		})
+	}
+
+	t.Run("invalid proxy panics", func(t *testing.T) {
+		require.Panics(t, func() { TrustedProxies("10.0.0.0/33") })
+		require.Panics(t, func() { TrustedProxies("proxy.local") })
+	})
+}
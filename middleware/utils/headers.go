@@ -0,0 +1,137 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+// DefaultSensitiveHeaders are the headers whose values are masked by
+// default, when logged.
+var DefaultSensitiveHeaders = []string{
+	"authorization", "proxy-authorization",
+	"cookie", "set-cookie",
+	"x-api-key", "x-auth-token",
+}
+
+// ResponseHeaderLoggingContext is implemented by the response logging
+// contexts exposing the response headers.
+type ResponseHeaderLoggingContext interface {
+	ResponseHeader(key string) string
+}
+
+// RequestHeaderValuesLoggingContext is implemented by the request logging
+// contexts exposing all the values of a request header, e.g. of a repeated
+// Via or X-Forwarded-For header. Without it only the first value is logged.
+type RequestHeaderValuesLoggingContext interface {
+	RequestHeaderValues(key string) []string
+}
+
+// ResponseHeaderValuesLoggingContext is implemented by the response logging
+// contexts exposing all the values of a response header, e.g. of a repeated
+// Set-Cookie header. Without it only the first value is logged.
+type ResponseHeaderValuesLoggingContext interface {
+	ResponseHeaderValues(key string) []string
+}
+
+// LogRequestHeaders logs the request headers with the names in the
+// http.request.headers field, with the names lowercased. Headers not set
+// are not logged, and the values of the repeated ones are joined with ", ".
+func LogRequestHeaders(names ...string) Option {
+	return func(o *Options) {
+		o.requestHeaderNames = normalizeHeaderNames(names)
+	}
+}
+
+// LogResponseHeaders logs the response headers with the names in the
+// http.response.headers field of the request completed entry, with the names
+// lowercased, joining the values of the repeated ones with ", ".
+func LogResponseHeaders(names ...string) Option {
+	return func(o *Options) {
+		o.responseHeaderNames = normalizeHeaderNames(names)
+	}
+}
+
+// SensitiveHeaders sets the headers whose values are replaced with
+// DefaultRedactionMask when logged, compared case insensitively. It replaces
+// the DefaultSensitiveHeaders. The redaction rules of the logger apply to
+// the logged headers too.
+func SensitiveHeaders(names ...string) Option {
+	return func(o *Options) {
+		o.sensitiveHeaders = lowerNames(names)
+	}
+}
+
+// requestHeaders returns the request headers to log.
+func (o *Options) requestHeaders(req glogger.RequestLoggingContext) map[string]string {
+	if valuesCtx, ok := req.(RequestHeaderValuesLoggingContext); ok {
+		return o.captureHeaders(o.requestHeaderNames, valuesCtx.RequestHeaderValues)
+	}
+	return o.captureHeaders(o.requestHeaderNames, firstValue(req.GetHeader))
+}
+
+// responseHeaders returns the response headers to log.
+func (o *Options) responseHeaders(res glogger.ResponseLoggingContext) map[string]string {
+	if valuesCtx, ok := res.(ResponseHeaderValuesLoggingContext); ok {
+		return o.captureHeaders(o.responseHeaderNames, valuesCtx.ResponseHeaderValues)
+	}
+	headerCtx, ok := res.(ResponseHeaderLoggingContext)
+	if !ok {
+		return nil
+	}
+	return o.captureHeaders(o.responseHeaderNames, firstValue(headerCtx.ResponseHeader))
+}
+
+func (o *Options) captureHeaders(names []string, values func(string) []string) map[string]string {
+	var headers map[string]string
+	for _, name := range names {
+		value := strings.Join(values(name), ", ")
+		if value == "" {
+			continue
+		}
+		if o.sensitiveHeaders[name] {
+			value = DefaultRedactionMask
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(names))
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+// firstValue adapts the getters of the first value of a header.
+func firstValue(get func(string) string) func(string) []string {
+	return func(name string) []string {
+		if value := get(name); value != "" {
+			return []string{value}
+		}
+		return nil
+	}
+}
+
+func normalizeHeaderNames(names []string) []string {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaders(t *testing.T) {
+	req := fake.Request{Headers: map[string]string{
+		"user-agent":    "goTest",
+		"accept":        "application/json",
+		"authorization": "Bearer secret",
+		"x-tenant":      "acme",
+	}}
+	res := fake.Response{StatusCode: 200, Headers: map[string]string{
+		"content-type": "application/json",
+		"set-cookie":   "session=secret",
+	}}
+
+	testCases := []struct {
+		name             string
+		options          []Option
+		expectedRequest  map[string]string
+		expectedResponse map[string]string
+	}{
+		{
+			name: "no headers by default",
+		},
+		{
+			name:            "normalized request header names",
+			options:         []Option{LogRequestHeaders(" Accept", "X-Tenant", "X-Missing", "")},
+			expectedRequest: map[string]string{"accept": "application/json", "x-tenant": "acme"},
+		},
+		{
+			name: "default sensitive headers masked",
+			options: []Option{
+				LogRequestHeaders("authorization"),
+				LogResponseHeaders("Content-Type", "Set-Cookie"),
+			},
+			expectedRequest:  map[string]string{"authorization": DefaultRedactionMask},
+			expectedResponse: map[string]string{"content-type": "application/json", "set-cookie": DefaultRedactionMask},
+		},
+		{
+			name: "custom sensitive headers",
+			options: []Option{
+				LogRequestHeaders("authorization", "x-tenant"),
+				SensitiveHeaders("X-Tenant"),
+			},
+			expectedRequest: map[string]string{"authorization": "Bearer secret", "x-tenant": DefaultRedactionMask},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			o := NewOptions(nil, testCase.options...)
+			ctx := fake.NewContext(context.Background(), req, res)
+
+			incoming := o.IncomingRequestFields(ctx)[HTTPKey].(HTTP)
+			require.Equal(t, testCase.expectedRequest, incoming.Request.Headers)
+			require.Nil(t, incoming.Response)
+
+			completed := o.RequestCompletedFields(ctx, time.Now())[HTTPKey].(HTTP)
+			require.Equal(t, testCase.expectedRequest, completed.Request.Headers)
+			require.Equal(t, testCase.expectedResponse, completed.Response.Headers)
+		})
+	}
+}
@@ -14,12 +14,19 @@ type fakeLoggingContext struct {
 
 type Request struct {
 	Headers map[string]string
+	Method  string
 	URI     string
+	Route   string
+	// RemoteAddr is the peer address, e.g. 192.0.2.1:1234.
+	RemoteAddr string
+	Body       []byte
 }
 
 type Response struct {
 	StatusCode int
 	BodySize   int
+	Headers    map[string]string
+	Body       []byte
 }
 
 func NewContext(ctx context.Context, req Request, res Response) glogger.LoggingContext {
@@ -47,17 +54,43 @@ func (flc *fakeLoggingContext) GetHeader(key string) string {
 }
 
 func (flc *fakeLoggingContext) URI() string {
+	if flc.req.URI != "" {
+		return flc.req.URI
+	}
 	return "/custom-uri"
 }
 
+func (flc *fakeLoggingContext) Route() string {
+	return flc.req.Route
+}
+
+func (flc *fakeLoggingContext) RemoteAddr() string {
+	return flc.req.RemoteAddr
+}
+
+func (flc *fakeLoggingContext) RequestBody() []byte {
+	return flc.req.Body
+}
+
 func (flc *fakeLoggingContext) Host() string {
 	return "echo-service:3456"
 }
 
 func (flc *fakeLoggingContext) Method() string {
+	if flc.req.Method != "" {
+		return flc.req.Method
+	}
 	return "GET"
 }
 
+func (flc *fakeLoggingContext) ResponseHeader(key string) string {
+	return flc.res.Headers[key]
+}
+
+func (flc *fakeLoggingContext) ResponseBody() []byte {
+	return flc.res.Body
+}
+
 func (flc *fakeLoggingContext) BodySize() int {
 	return flc.res.BodySize
 }
@@ -17,11 +17,9 @@
 package utils
 
 import (
-	"fmt"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/mia-platform/glogger/v4"
 	"github.com/mia-platform/glogger/v4/loggers/core"
 )
@@ -33,12 +31,25 @@ const (
 
 	IncomingRequestMessage  = "incoming request"
 	RequestCompletedMessage = "request completed"
+
+	// Keys of the fields added to the logs by the middlewares.
+	RequestIDKey    = core.RequestIDKey
+	HTTPKey         = core.HTTPKey
+	URLKey          = core.URLKey
+	HostKey         = core.HostKey
+	ResponseTimeKey = core.ResponseTimeKey
+	TraceKey        = core.TraceKey
+	SpanKey         = core.SpanKey
 )
 
 // HTTP is the struct of the log formatter.
 type HTTP struct {
 	Request  *Request  `json:"request,omitempty"`
 	Response *Response `json:"response,omitempty"`
+	// Route is the template of the route matching the request, e.g.
+	// /users/{id}, while URL.Path is the path requested. It is logged as
+	// http.route, the name of the OpenTelemetry semantic conventions.
+	Route string `json:"route,omitempty"`
 }
 
 type UserAgent struct {
@@ -47,18 +58,29 @@ type UserAgent struct {
 
 // Request contains the items of request info log.
 type Request struct {
-	Method    string    `json:"method,omitempty"`
-	UserAgent UserAgent `json:"userAgent,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	UserAgent UserAgent         `json:"userAgent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      *RequestBody      `json:"body,omitempty"`
 }
 
 type ResponseBody struct {
-	Bytes int `json:"bytes,omitempty"`
+	Bytes     int    `json:"bytes,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// RequestBody contains the request body logged with the LogBodies option.
+type RequestBody struct {
+	Content   string `json:"content,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 // Response contains the items of response info log.
 type Response struct {
-	StatusCode int          `json:"statusCode,omitempty"`
-	Body       ResponseBody `json:"body,omitempty"`
+	StatusCode int               `json:"statusCode,omitempty"`
+	Body       ResponseBody      `json:"body,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
 }
 
 // Host has the host information.
@@ -66,74 +88,103 @@ type Host struct {
 	Hostname      string `json:"hostname,omitempty"`
 	ForwardedHost string `json:"forwardedHost,omitempty"`
 	IP            string `json:"ip,omitempty"`
+	// ProxyChain has the forwarded addresses and the peer one, when the
+	// client IP is resolved with the TrustedProxies option.
+	ProxyChain []string `json:"proxyChain,omitempty"`
 }
 
 // URL info
 type URL struct {
 	Path string `json:"path,omitempty"`
+	// Query holds the parameters of the query string, filtered and masked
+	// as set by the middleware options.
+	Query map[string][]string `json:"query,omitempty"`
+}
+
+// Trace has the trace information.
+type Trace struct {
+	ID string `json:"id,omitempty"`
+}
+
+// Span has the span information.
+type Span struct {
+	ID string `json:"id,omitempty"`
+}
+
+// RouteLoggingContext is implemented by the request logging contexts of the
+// routers exposing the template of the route matching the request.
+type RouteLoggingContext interface {
+	Route() string
+}
+
+// RequestRoute returns the template of the route matching the request, or an
+// empty string when it is not known.
+func RequestRoute(req glogger.RequestLoggingContext) string {
+	if routeCtx, ok := req.(RouteLoggingContext); ok {
+		return routeCtx.Route()
+	}
+	return ""
 }
 
 func removePort(host string) string {
 	return strings.Split(host, ":")[0]
 }
 
+// GetReqID returns the id of the request, taken from the x-request-id header
+// when valid or generated, with the default options.
 func GetReqID(ctx glogger.LoggingContext) string {
-	if requestID := ctx.Request().GetHeader(requestIDHeaderName); requestID != "" {
-		return requestID
-	}
-	// Generate a random uuid string. e.g. 16c9c1f2-c001-40d3-bbfe-48857367e7b5
-	requestID, err := uuid.NewRandom()
-	if err != nil {
-		panic(fmt.Errorf("error generating request id: %s", err))
-	}
-	return requestID.String()
+	return defaultOptions.RequestID(ctx)
 }
 
+var defaultOptions = NewOptions(nil)
+
 func LogIncomingRequest[T any](ctx glogger.LoggingContext, logger core.Logger[T]) {
-	logger.
-		WithFields(map[string]any{
-			"http": HTTP{
-				Request: &Request{
-					Method: ctx.Request().Method(),
-					UserAgent: UserAgent{
-						Original: ctx.Request().GetHeader("user-agent"),
-					},
-				},
-			},
-			"url": URL{Path: ctx.Request().URI()},
-			"host": Host{
-				ForwardedHost: ctx.Request().GetHeader(forwardedHostHeaderKey),
-				Hostname:      removePort(ctx.Request().Host()),
-				IP:            ctx.Request().GetHeader(forwardedForHeaderKey),
-			},
-		}).
-		Trace(IncomingRequestMessage)
+	logger.WithFields(defaultOptions.IncomingRequestFields(ctx)).Trace(IncomingRequestMessage)
 }
 
 func LogRequestCompleted[T any](ctx glogger.LoggingContext, logger core.Logger[T], startTime time.Time) {
-	logger.
-		WithFields(map[string]any{
-			"http": HTTP{
-				Request: &Request{
-					Method: ctx.Request().Method(),
-					UserAgent: UserAgent{
-						Original: ctx.Request().GetHeader("user-agent"),
-					},
+	logger.WithFields(defaultOptions.RequestCompletedFields(ctx, startTime)).Info(RequestCompletedMessage)
+}
+
+// IncomingRequestFields returns the fields of the incoming request entry.
+func (o *Options) IncomingRequestFields(ctx glogger.LoggingContext) map[string]any {
+	return map[string]any{
+		HTTPKey: HTTP{
+			Request: &Request{
+				Method: ctx.Request().Method(),
+				UserAgent: UserAgent{
+					Original: ctx.Request().GetHeader("user-agent"),
 				},
-				Response: &Response{
-					StatusCode: ctx.Response().StatusCode(),
-					Body: ResponseBody{
-						Bytes: ctx.Response().BodySize(),
-					},
+				Headers: o.requestHeaders(ctx.Request()),
+			},
+			Route: RequestRoute(ctx.Request()),
+		},
+		URLKey:  o.url(ctx.Request()),
+		HostKey: o.host(ctx.Request()),
+	}
+}
+
+// RequestCompletedFields returns the fields of the request completed entry.
+func (o *Options) RequestCompletedFields(ctx glogger.LoggingContext, startTime time.Time) map[string]any {
+	return map[string]any{
+		HTTPKey: HTTP{
+			Request: &Request{
+				Method: ctx.Request().Method(),
+				UserAgent: UserAgent{
+					Original: ctx.Request().GetHeader("user-agent"),
 				},
+				Headers: o.requestHeaders(ctx.Request()),
+				Body:    o.requestBody(ctx),
 			},
-			"url": URL{Path: ctx.Request().URI()},
-			"host": Host{
-				ForwardedHost: ctx.Request().GetHeader(forwardedHostHeaderKey),
-				Hostname:      removePort(ctx.Request().Host()),
-				IP:            ctx.Request().GetHeader(forwardedForHeaderKey),
+			Response: &Response{
+				StatusCode: ctx.Response().StatusCode(),
+				Body:       o.responseBody(ctx),
+				Headers:    o.responseHeaders(ctx.Response()),
 			},
-			"responseTime": float64(time.Since(startTime).Milliseconds()),
-		}).
-		Info(RequestCompletedMessage)
+			Route: RequestRoute(ctx.Request()),
+		},
+		URLKey:          o.url(ctx.Request()),
+		HostKey:         o.host(ctx.Request()),
+		ResponseTimeKey: float64(time.Since(startTime).Milliseconds()),
+	}
 }
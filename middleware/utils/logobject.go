@@ -0,0 +1,178 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import "github.com/mia-platform/glogger/v4/loggers/core"
+
+// The following methods encode the structs of the fields without
+// reflection, following their json tags: keep them in sync when a struct
+// changes. The structs stored by value in the fields have value receivers.
+
+func (h HTTP) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if h.Request != nil {
+		if err := encoder.AddObject("request", h.Request); err != nil {
+			return err
+		}
+	}
+	if h.Response != nil {
+		if err := encoder.AddObject("response", h.Response); err != nil {
+			return err
+		}
+	}
+	if h.Route != "" {
+		encoder.AddString("route", h.Route)
+	}
+	return nil
+}
+
+func (r *Request) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if r.Method != "" {
+		encoder.AddString("method", r.Method)
+	}
+	if err := encoder.AddObject("userAgent", &r.UserAgent); err != nil {
+		return err
+	}
+	if len(r.Headers) > 0 {
+		if err := encoder.AddValue("headers", r.Headers); err != nil {
+			return err
+		}
+	}
+	if r.Body != nil {
+		return encoder.AddObject("body", r.Body)
+	}
+	return nil
+}
+
+func (u *UserAgent) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if u.Original != "" {
+		encoder.AddString("original", u.Original)
+	}
+	return nil
+}
+
+func (b *RequestBody) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if b.Content != "" {
+		encoder.AddString("content", b.Content)
+	}
+	if b.Truncated {
+		encoder.AddBool("truncated", true)
+	}
+	return nil
+}
+
+func (r *Response) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if r.StatusCode != 0 {
+		encoder.AddInt("statusCode", r.StatusCode)
+	}
+	if err := encoder.AddObject("body", &r.Body); err != nil {
+		return err
+	}
+	if len(r.Headers) > 0 {
+		return encoder.AddValue("headers", r.Headers)
+	}
+	return nil
+}
+
+func (b *ResponseBody) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if b.Bytes != 0 {
+		encoder.AddInt("bytes", b.Bytes)
+	}
+	if b.Content != "" {
+		encoder.AddString("content", b.Content)
+	}
+	if b.Truncated {
+		encoder.AddBool("truncated", true)
+	}
+	return nil
+}
+
+func (h Host) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if h.Hostname != "" {
+		encoder.AddString("hostname", h.Hostname)
+	}
+	if h.ForwardedHost != "" {
+		encoder.AddString("forwardedHost", h.ForwardedHost)
+	}
+	if h.IP != "" {
+		encoder.AddString("ip", h.IP)
+	}
+	if len(h.ProxyChain) > 0 {
+		encoder.AddStrings("proxyChain", h.ProxyChain)
+	}
+	return nil
+}
+
+func (u URL) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if u.Path != "" {
+		encoder.AddString("path", u.Path)
+	}
+	if len(u.Query) > 0 {
+		return encoder.AddValue("query", u.Query)
+	}
+	return nil
+}
+
+func (t Trace) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if t.ID != "" {
+		encoder.AddString("id", t.ID)
+	}
+	return nil
+}
+
+func (s Span) MarshalLogObject(encoder core.ObjectEncoder) error {
+	if s.ID != "" {
+		encoder.AddString("id", s.ID)
+	}
+	return nil
+}
+
+// Redact masks the headers and the bodies, for the redaction rules of the
+// loggers.
+func (h HTTP) Redact(redactor core.Redactor) (any, bool) {
+	changed := false
+	if h.Request != nil {
+		request := *h.Request
+		headers, headersChanged := redactor.RedactStringMap(request.Headers)
+		if headersChanged {
+			request.Headers = headers
+		}
+		bodyChanged := false
+		if request.Body != nil {
+			if content := redactor.RedactString(request.Body.Content); content != request.Body.Content {
+				request.Body = &RequestBody{Content: content, Truncated: request.Body.Truncated}
+				bodyChanged = true
+			}
+		}
+		if headersChanged || bodyChanged {
+			h.Request = &request
+			changed = true
+		}
+	}
+	if h.Response != nil {
+		response := *h.Response
+		headers, headersChanged := redactor.RedactStringMap(response.Headers)
+		if headersChanged {
+			response.Headers = headers
+		}
+		response.Body.Content = redactor.RedactString(response.Body.Content)
+		if headersChanged || response.Body.Content != h.Response.Body.Content {
+			h.Response = &response
+			changed = true
+		}
+	}
+	return h, changed
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+// Option configures the logging middlewares.
+type Option func(*Options)
+
+// Options is the configuration of the logging middlewares, built by
+// NewOptions from the Option functions.
+type Options struct {
+	include []RequestMatcher
+	exclude []RequestMatcher
+
+	requestIDHeaders        []string
+	generateRequestID       RequestIDGenerator
+	requestIDMaxLength      int
+	requestIDCharset        string
+	requestIDResponseHeader string
+
+	traceContext       bool
+	traceExtractors    []TraceExtractor
+	traceIDAsRequestID bool
+	contextFields      []func(ctx context.Context) map[string]any
+
+	queryAllowList       map[string]bool
+	sensitiveQueryParams map[string]bool
+	disableQuery         bool
+
+	resolveClientIP bool
+	trustedProxies  []netip.Prefix
+
+	requestHeaderNames  []string
+	responseHeaderNames []string
+	sensitiveHeaders    map[string]bool
+
+	bodyMaxBytes      int
+	bodyMatchers      []RequestMatcher
+	bodyStatusClasses map[int]bool
+	bodyContentTypes  []string
+	bodyRedaction     bodyRedaction
+}
+
+// NewOptions applies the options. The excluded prefixes are the ones passed
+// to RequestMiddlewareLogger, and are the same as Exclude(PathPrefix(...)).
+func NewOptions(excludedPrefix []string, options ...Option) *Options {
+	o := &Options{
+		requestIDHeaders:   []string{requestIDHeaderName},
+		generateRequestID:  UUIDv4,
+		requestIDMaxLength: DefaultRequestIDMaxLength,
+		requestIDCharset:   DefaultRequestIDCharset,
+		traceExtractors:    []TraceExtractor{ExtractW3C},
+
+		sensitiveQueryParams: lowerNames(DefaultSensitiveQueryParams),
+		sensitiveHeaders:     lowerNames(DefaultSensitiveHeaders),
+		bodyContentTypes:     DefaultBodyContentTypes,
+		bodyRedaction:        newBodyRedaction(DefaultSensitiveBodyFields),
+	}
+	if len(excludedPrefix) > 0 {
+		Exclude(PathPrefix(excludedPrefix...))(o)
+	}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// ShouldLog reports whether the incoming request and request completed
+// entries are logged: the request must match one of the include rules, if
+// any, and none of the exclude rules. The logger is injected in the context
+// of all the requests anyway.
+func (o *Options) ShouldLog(req glogger.RequestLoggingContext) bool {
+	if len(o.include) > 0 && !anyMatch(o.include, req) {
+		return false
+	}
+	return !anyMatch(o.exclude, req)
+}
+
+// ShouldLogBeforeRouting is ShouldLog for the requests whose route is not
+// known yet, e.g. the incoming request entries of fiber. The route of the
+// request is empty, and routed is false when a rule reads it, e.g. with the
+// Route matcher: ShouldLog must then be checked again after routing.
+func (o *Options) ShouldLogBeforeRouting(req glogger.RequestLoggingContext) (shouldLog, routed bool) {
+	unrouted := &unroutedRequest{RequestLoggingContext: req}
+	shouldLog = o.ShouldLog(unrouted)
+	return shouldLog, !unrouted.routeRead
+}
+
+// unroutedRequest is a request checked before routing: its route is empty,
+// and routeRead records whether a rule read it.
+type unroutedRequest struct {
+	glogger.RequestLoggingContext
+	routeRead bool
+}
+
+func (r *unroutedRequest) Route() string {
+	r.routeRead = true
+	return ""
+}
+
+func (r *unroutedRequest) RemoteAddr() string {
+	if remoteCtx, ok := r.RequestLoggingContext.(RemoteAddrLoggingContext); ok {
+		return remoteCtx.RemoteAddr()
+	}
+	return ""
+}
+
+func (r *unroutedRequest) RequestBody() []byte {
+	if bodyCtx, ok := r.RequestLoggingContext.(RequestBodyLoggingContext); ok {
+		return bodyCtx.RequestBody()
+	}
+	return nil
+}
+
+// RequestInfo holds the ids of a request, added to the fields of the logger
+// injected in the request context.
+type RequestInfo struct {
+	RequestID string
+	// TraceContext is set with the WithTraceContext option.
+	TraceContext TraceContext
+
+	contextFields map[string]any
+}
+
+// RequestInfo returns the ids of the request. ctx is the request context,
+// passed to the WithContextFields functions.
+func (o *Options) RequestInfo(ctx context.Context, logCtx glogger.LoggingContext) RequestInfo {
+	var info RequestInfo
+	if o.traceContext {
+		info.TraceContext = o.requestTraceContext(logCtx)
+	}
+
+	if requestID, ok := o.headerRequestID(logCtx); ok {
+		info.RequestID = requestID
+	} else if o.traceIDAsRequestID && info.TraceContext.IsValid() {
+		info.RequestID = info.TraceContext.TraceID
+	} else {
+		info.RequestID = o.newRequestID()
+	}
+
+	for _, extract := range o.contextFields {
+		for k, v := range extract(ctx) {
+			if info.contextFields == nil {
+				info.contextFields = map[string]any{}
+			}
+			info.contextFields[k] = v
+		}
+	}
+	return info
+}
+
+// WithContextFields adds to the request logger the fields returned by
+// extract from the request context, e.g. the ids of the span started by a
+// tracing middleware running before the logging one.
+func WithContextFields(extract func(ctx context.Context) map[string]any) Option {
+	return func(o *Options) {
+		o.contextFields = append(o.contextFields, extract)
+	}
+}
+
+// Fields returns the fields added to the request logger.
+func (r RequestInfo) Fields() map[string]any {
+	fields := map[string]any{RequestIDKey: r.RequestID}
+	for k, v := range r.contextFields {
+		fields[k] = v
+	}
+	if r.TraceContext.IsValid() {
+		fields[TraceKey] = Trace{ID: r.TraceContext.TraceID}
+		fields[SpanKey] = Span{ID: r.TraceContext.SpanID}
+	}
+	return fields
+}
+
+// Include logs only the requests matching one of the matchers. When used
+// more than once, a request matching any of the matchers is logged.
+func Include(matchers ...RequestMatcher) Option {
+	return func(o *Options) {
+		o.include = append(o.include, matchers...)
+	}
+}
+
+// Exclude does not log the requests matching one of the matchers, even when
+// they match an include rule.
+func Exclude(matchers ...RequestMatcher) Option {
+	return func(o *Options) {
+		o.exclude = append(o.exclude, matchers...)
+	}
+}
+
+// RequestMatcher selects requests for the Include and Exclude rules.
+type RequestMatcher func(req glogger.RequestLoggingContext) bool
+
+func anyMatch(matchers []RequestMatcher, req glogger.RequestLoggingContext) bool {
+	for _, match := range matchers {
+		if match(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// PathPrefix matches the requests whose path starts with one of the
+// prefixes. The path is matched without the query string.
+func PathPrefix(prefixes ...string) RequestMatcher {
+	return func(req glogger.RequestLoggingContext) bool {
+		requestPath := RequestPath(req)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(requestPath, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathGlob matches the requests whose path matches one of the patterns, with
+// the syntax of path.Match: * does not match a slash, e.g. /api/*/status.
+// It panics if a pattern is malformed.
+func PathGlob(patterns ...string) RequestMatcher {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("invalid path glob %q: %w", pattern, err))
+		}
+	}
+	return func(req glogger.RequestLoggingContext) bool {
+		requestPath := RequestPath(req)
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, requestPath); matched {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathRegexp matches the requests whose path matches the regular expression.
+// It panics if the expression does not compile.
+func PathRegexp(expr string) RequestMatcher {
+	re := regexp.MustCompile(expr)
+	return func(req glogger.RequestLoggingContext) bool {
+		return re.MatchString(RequestPath(req))
+	}
+}
+
+// Route matches the requests whose route template is one of the templates,
+// e.g. /users/{id} with mux or /users/:id with fiber. With fiber the route
+// is known after routing only, so the incoming request entries of the
+// requests checked by route are logged after routing too.
+func Route(templates ...string) RequestMatcher {
+	return func(req glogger.RequestLoggingContext) bool {
+		route := RequestRoute(req)
+		for _, template := range templates {
+			if route != "" && route == template {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Method matches the requests with one of the methods, compared case
+// insensitively.
+func Method(methods ...string) RequestMatcher {
+	return func(req glogger.RequestLoggingContext) bool {
+		for _, method := range methods {
+			if strings.EqualFold(req.Method(), method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Header matches the requests with a header value matching the regular
+// expression, e.g. Header("User-Agent", "^kube-probe/"). An empty expression
+// matches the requests where the header is set. It panics if the expression
+// does not compile.
+func Header(name, expr string) RequestMatcher {
+	re := regexp.MustCompile(expr)
+	return func(req glogger.RequestLoggingContext) bool {
+		value := req.GetHeader(name)
+		if expr == "" {
+			return value != ""
+		}
+		return re.MatchString(value)
+	}
+}
+
+// RequestPath returns the path of the request, without the query string and
+// unescaped when possible.
+func RequestPath(req glogger.RequestLoggingContext) string {
+	requestPath, _, _ := strings.Cut(req.URI(), "?")
+	if unescaped, err := url.PathUnescape(requestPath); err == nil {
+		return unescaped
+	}
+	return requestPath
+}
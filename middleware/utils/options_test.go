@@ -0,0 +1,208 @@
+/*
+ * Copyright 2023 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4"
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	request := func(method, uri string, headers map[string]string) fake.Request {
+		return fake.Request{Method: method, URI: uri, Headers: headers}
+	}
+
+	testCases := []struct {
+		name           string
+		excludedPrefix []string
+		options        []Option
+		request        fake.Request
+		expected       bool
+	}{
+		{
+			name:     "log everything by default",
+			request:  request("GET", "/-/healthz", nil),
+			expected: true,
+		},
+		{
+			name:           "excluded prefix",
+			excludedPrefix: []string{"/-/"},
+			request:        request("GET", "/-/healthz", nil),
+			expected:       false,
+		},
+		{
+			name:           "prefix does not match the query string",
+			excludedPrefix: []string{"/api?debug"},
+			request:        request("GET", "/api?debug=true", nil),
+			expected:       true,
+		},
+		{
+			name:     "path glob",
+			options:  []Option{Exclude(PathGlob("/api/*/status"))},
+			request:  request("GET", "/api/orders/status?verbose=1", nil),
+			expected: false,
+		},
+		{
+			name:     "path glob does not match across segments",
+			options:  []Option{Exclude(PathGlob("/api/*/status"))},
+			request:  request("GET", "/api/orders/1/status", nil),
+			expected: true,
+		},
+		{
+			name:     "path regexp on the unescaped path",
+			options:  []Option{Exclude(PathRegexp(`^/files/.* draft$`))},
+			request:  request("GET", "/files/my%20draft", nil),
+			expected: false,
+		},
+		{
+			name:     "method",
+			options:  []Option{Exclude(Method("options", "HEAD"))},
+			request:  request("OPTIONS", "/api", nil),
+			expected: false,
+		},
+		{
+			name:     "header",
+			options:  []Option{Exclude(Header("user-agent", "^kube-probe/"))},
+			request:  request("GET", "/healthz", map[string]string{"user-agent": "kube-probe/1.29"}),
+			expected: false,
+		},
+		{
+			name:     "header not matching",
+			options:  []Option{Exclude(Header("user-agent", "^kube-probe/"))},
+			request:  request("GET", "/healthz", map[string]string{"user-agent": "curl/8.0"}),
+			expected: true,
+		},
+		{
+			name:     "header set",
+			options:  []Option{Exclude(Header("x-internal", ""))},
+			request:  request("GET", "/api", map[string]string{"x-internal": "1"}),
+			expected: false,
+		},
+		{
+			name:     "route",
+			options:  []Option{Exclude(Route("/users/{id}"))},
+			request:  fake.Request{Method: "GET", URI: "/users/42", Route: "/users/{id}"},
+			expected: false,
+		},
+		{
+			name:     "unknown route",
+			options:  []Option{Include(Route("/users/{id}", ""))},
+			request:  request("GET", "/users/42", nil),
+			expected: false,
+		},
+		{
+			name:     "not included",
+			options:  []Option{Include(PathPrefix("/api/"))},
+			request:  request("GET", "/metrics", nil),
+			expected: false,
+		},
+		{
+			name:     "included by one of the rules",
+			options:  []Option{Include(PathPrefix("/api/")), Include(Method("POST"))},
+			request:  request("POST", "/metrics", nil),
+			expected: true,
+		},
+		{
+			name:     "excluded wins over included",
+			options:  []Option{Include(PathPrefix("/api/")), Exclude(Method("OPTIONS"))},
+			request:  request("OPTIONS", "/api/orders", nil),
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := NewOptions(tc.excludedPrefix, tc.options...)
+			ctx := fake.NewContext(context.Background(), tc.request, fake.Response{})
+			require.Equal(t, tc.expected, options.ShouldLog(ctx.Request()))
+		})
+	}
+
+	t.Run("invalid patterns panic", func(t *testing.T) {
+		require.Panics(t, func() { PathGlob("/api/[") })
+		require.Panics(t, func() { PathRegexp("(") })
+		require.Panics(t, func() { Header("user-agent", "(") })
+	})
+}
+
+func TestShouldLogBeforeRouting(t *testing.T) {
+	fromPeer := func(addr string) RequestMatcher {
+		return func(req glogger.RequestLoggingContext) bool {
+			remoteCtx, ok := req.(RemoteAddrLoggingContext)
+			return ok && remoteCtx.RemoteAddr() == addr
+		}
+	}
+	request := fake.Request{Method: "GET", URI: "/users/42", RemoteAddr: "192.0.2.1:1234"}
+
+	notRoute := func(templates ...string) RequestMatcher {
+		route := Route(templates...)
+		return func(req glogger.RequestLoggingContext) bool {
+			return !route(req)
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		options   []Option
+		shouldLog bool
+		routed    bool
+	}{
+		{
+			name:      "no rules",
+			shouldLog: true,
+			routed:    true,
+		},
+		{
+			name:    "included by route",
+			options: []Option{Include(Route("/users/{id}"))},
+		},
+		{
+			name:      "excluded by route",
+			options:   []Option{Exclude(Route("/users/{id}"))},
+			shouldLog: true,
+		},
+		{
+			name:      "wrapped route matcher",
+			options:   []Option{Include(notRoute("/-/healthz"))},
+			shouldLog: true,
+		},
+		{
+			name:    "excluded by another rule first",
+			options: []Option{Exclude(Method("GET"), Route("/users/{id}"))},
+			routed:  true,
+		},
+		{
+			name:    "custom matcher",
+			options: []Option{Exclude(fromPeer("192.0.2.1:1234"))},
+			routed:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := NewOptions(nil, tc.options...)
+			ctx := fake.NewContext(context.Background(), request, fake.Response{})
+			shouldLog, routed := options.ShouldLogBeforeRouting(ctx.Request())
+			require.Equal(t, tc.shouldLog, shouldLog)
+			require.Equal(t, tc.routed, routed)
+		})
+	}
+}
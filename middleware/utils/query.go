@@ -0,0 +1,102 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+	"github.com/mia-platform/glogger/v4/loggers/core"
+)
+
+// DefaultRedactionMask replaces the values of the sensitive query
+// parameters, headers and body fields.
+const DefaultRedactionMask = core.DefaultRedactionMask
+
+// DefaultSensitiveQueryParams are the query parameters whose values are
+// masked by default.
+var DefaultSensitiveQueryParams = []string{
+	"token", "access_token", "refresh_token", "id_token",
+	"apikey", "api_key", "key",
+	"password", "passwd", "pwd",
+	"secret", "client_secret",
+	"signature", "sig",
+}
+
+// QueryAllowList logs only the query parameters with the names, compared
+// case insensitively. All the parameters are logged by default.
+func QueryAllowList(names ...string) Option {
+	return func(o *Options) {
+		o.queryAllowList = lowerNames(names)
+	}
+}
+
+// SensitiveQueryParams sets the query parameters whose values are replaced
+// with DefaultRedactionMask, compared case insensitively. It replaces the
+// DefaultSensitiveQueryParams.
+func SensitiveQueryParams(names ...string) Option {
+	return func(o *Options) {
+		o.sensitiveQueryParams = lowerNames(names)
+	}
+}
+
+// DisableQueryLogging does not log the query parameters.
+func DisableQueryLogging() Option {
+	return func(o *Options) {
+		o.disableQuery = true
+	}
+}
+
+// url returns the url field: the path, without the query string, and the
+// query parameters allowed with the sensitive values masked.
+func (o *Options) url(req glogger.RequestLoggingContext) URL {
+	path, rawQuery, _ := strings.Cut(req.URI(), "?")
+	result := URL{Path: path}
+	if o.disableQuery || rawQuery == "" {
+		return result
+	}
+
+	// the parameters parsed before a malformed one are kept
+	params, _ := url.ParseQuery(rawQuery)
+	for name, values := range params {
+		lowerName := strings.ToLower(name)
+		if len(o.queryAllowList) > 0 && !o.queryAllowList[lowerName] {
+			continue
+		}
+		if o.sensitiveQueryParams[lowerName] {
+			masked := make([]string, len(values))
+			for i := range masked {
+				masked[i] = DefaultRedactionMask
+			}
+			values = masked
+		}
+		if result.Query == nil {
+			result.Query = map[string][]string{}
+		}
+		result.Query[name] = values
+	}
+	return result
+}
+
+func lowerNames(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
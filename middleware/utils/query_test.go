@@ -0,0 +1,100 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLogging(t *testing.T) {
+	testCases := []struct {
+		name     string
+		uri      string
+		options  []Option
+		expected URL
+	}{
+		{
+			name:     "without query",
+			uri:      "/api/items",
+			expected: URL{Path: "/api/items"},
+		},
+		{
+			name:     "empty query",
+			uri:      "/api/items?",
+			expected: URL{Path: "/api/items"},
+		},
+		{
+			name: "split query",
+			uri:  "/api/items?limit=10&tag=a&tag=b%20c",
+			expected: URL{Path: "/api/items", Query: map[string][]string{
+				"limit": {"10"},
+				"tag":   {"a", "b c"},
+			}},
+		},
+		{
+			name: "sensitive parameters masked",
+			uri:  "/api/items?limit=10&Token=secret&api_key=k1&api_key=k2",
+			expected: URL{Path: "/api/items", Query: map[string][]string{
+				"limit":   {"10"},
+				"Token":   {DefaultRedactionMask},
+				"api_key": {DefaultRedactionMask, DefaultRedactionMask},
+			}},
+		},
+		{
+			name:    "custom sensitive parameters",
+			uri:     "/api/items?token=abc&session=xyz",
+			options: []Option{SensitiveQueryParams("session")},
+			expected: URL{Path: "/api/items", Query: map[string][]string{
+				"token":   {"abc"},
+				"session": {DefaultRedactionMask},
+			}},
+		},
+		{
+			name:    "allow list",
+			uri:     "/api/items?limit=10&offset=20&debug=true&password=p",
+			options: []Option{QueryAllowList("LIMIT", "offset", "password")},
+			expected: URL{Path: "/api/items", Query: map[string][]string{
+				"limit":    {"10"},
+				"offset":   {"20"},
+				"password": {DefaultRedactionMask},
+			}},
+		},
+		{
+			name:     "malformed parameters dropped",
+			uri:      "/api/items?limit=10&bad=%zz",
+			expected: URL{Path: "/api/items", Query: map[string][]string{"limit": {"10"}}},
+		},
+		{
+			name:     "query logging disabled",
+			uri:      "/api/items?limit=10",
+			options:  []Option{DisableQueryLogging()},
+			expected: URL{Path: "/api/items"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := fake.NewContext(context.Background(), fake.Request{URI: tc.uri}, fake.Response{})
+			fields := NewOptions(nil, tc.options...).IncomingRequestFields(ctx)
+			require.Equal(t, tc.expected, fields[URLKey])
+		})
+	}
+}
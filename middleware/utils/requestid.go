@@ -0,0 +1,170 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mia-platform/glogger/v4"
+)
+
+const (
+	// DefaultRequestIDMaxLength is the maximum length of the request ids
+	// taken from the request headers.
+	DefaultRequestIDMaxLength = 128
+	// DefaultRequestIDCharset are the characters allowed in the request ids
+	// taken from the request headers, besides letters and digits.
+	DefaultRequestIDCharset = "-_.:/+=@"
+)
+
+// RequestIDGenerator generates the ids of the requests without one.
+type RequestIDGenerator func() (string, error)
+
+// UUIDv4 generates random UUIDs, e.g. 16c9c1f2-c001-40d3-bbfe-48857367e7b5.
+func UUIDv4() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// UUIDv7 generates time ordered UUIDs, e.g. 018f2f4c-7e2a-7c4e-9b1a-3f1e2d4c5b6a.
+func UUIDv7() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// crockfordBase32 is the alphabet of ULIDs.
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates ULIDs: 26 characters encoding the time in milliseconds and
+// 80 random bits, e.g. 01HXF6Z4P5J3M1Q8R7T2V9W0YB.
+func ULID() (string, error) {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	// 128 bits in 26 characters of 5 bits, the first one holding 3 bits only
+	high := binary.BigEndian.Uint64(id[:8])
+	low := binary.BigEndian.Uint64(id[8:])
+	var encoded [26]byte
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordBase32[low&0x1f]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(encoded[:]), nil
+}
+
+// RequestIDHeaders sets the headers holding the id of the incoming requests,
+// checked in order. Defaults to x-request-id.
+func RequestIDHeaders(names ...string) Option {
+	return func(o *Options) {
+		o.requestIDHeaders = names
+	}
+}
+
+// WithRequestIDGenerator sets the generator of the ids of the requests
+// without a valid one, e.g. UUIDv7 or ULID. Defaults to UUIDv4, also when
+// generator is nil.
+func WithRequestIDGenerator(generator RequestIDGenerator) Option {
+	if generator == nil {
+		generator = UUIDv4
+	}
+	return func(o *Options) {
+		o.generateRequestID = generator
+	}
+}
+
+// RequestIDValidation sets the maximum length and the characters allowed,
+// besides ASCII letters and digits, in the request ids taken from the
+// headers: other ids are replaced with a generated one. A maxLength of zero
+// or less removes the limit. Defaults to DefaultRequestIDMaxLength and
+// DefaultRequestIDCharset.
+func RequestIDValidation(maxLength int, charset string) Option {
+	return func(o *Options) {
+		o.requestIDMaxLength = maxLength
+		o.requestIDCharset = charset
+	}
+}
+
+// EchoRequestID sets the request id in the header of the response, e.g.
+// x-request-id.
+func EchoRequestID(header string) Option {
+	return func(o *Options) {
+		o.requestIDResponseHeader = header
+	}
+}
+
+// RequestID returns the id of the request, taken from the first header with
+// a valid one or generated.
+func (o *Options) RequestID(ctx glogger.LoggingContext) string {
+	if requestID, ok := o.headerRequestID(ctx); ok {
+		return requestID
+	}
+	return o.newRequestID()
+}
+
+func (o *Options) headerRequestID(ctx glogger.LoggingContext) (string, bool) {
+	for _, name := range o.requestIDHeaders {
+		if requestID := ctx.Request().GetHeader(name); requestID != "" && o.validRequestID(requestID) {
+			return requestID, true
+		}
+	}
+	return "", false
+}
+
+func (o *Options) newRequestID() string {
+	if requestID, err := o.generateRequestID(); err == nil {
+		return requestID
+	}
+	// the generators fail only when the system random source does
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// RequestIDResponseHeader returns the response header set to the request
+// id, or an empty string when the id is not echoed.
+func (o *Options) RequestIDResponseHeader() string {
+	return o.requestIDResponseHeader
+}
+
+func (o *Options) validRequestID(requestID string) bool {
+	if o.requestIDMaxLength > 0 && len(requestID) > o.requestIDMaxLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		c := requestID[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte(o.requestIDCharset, c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
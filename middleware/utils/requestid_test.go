@@ -0,0 +1,118 @@
+/*
+ * Copyright 2023 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	requestID := func(headers map[string]string, options ...Option) string {
+		ctx := fake.NewContext(context.Background(), fake.Request{Headers: headers}, fake.Response{})
+		return NewOptions(nil, options...).RequestID(ctx)
+	}
+
+	t.Run("taken from the default header", func(t *testing.T) {
+		require.Equal(t, "my-req-id", requestID(map[string]string{"x-request-id": "my-req-id"}))
+	})
+
+	t.Run("taken from the first header set", func(t *testing.T) {
+		headers := map[string]string{"x-correlation-id": "correlation", "x-amzn-request-id": "amazon"}
+		options := RequestIDHeaders("x-request-id", "x-correlation-id", "x-amzn-request-id")
+		require.Equal(t, "correlation", requestID(headers, options))
+	})
+
+	t.Run("invalid ids are replaced", func(t *testing.T) {
+		for _, invalid := range []string{
+			"my id",
+			"id\nfake log line",
+			`id"}`,
+			strings.Repeat("a", DefaultRequestIDMaxLength+1),
+		} {
+			generated := requestID(map[string]string{"x-request-id": invalid})
+			require.NotEqual(t, invalid, generated)
+			_, err := uuid.Parse(generated)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid ids fall back to the next header", func(t *testing.T) {
+		headers := map[string]string{"x-request-id": "my id", "x-correlation-id": "correlation"}
+		options := RequestIDHeaders("x-request-id", "x-correlation-id")
+		require.Equal(t, "correlation", requestID(headers, options))
+	})
+
+	t.Run("custom validation", func(t *testing.T) {
+		options := RequestIDValidation(4, "")
+		require.Equal(t, "abc1", requestID(map[string]string{"x-request-id": "abc1"}, options))
+		require.NotEqual(t, "ab-1", requestID(map[string]string{"x-request-id": "ab-1"}, options))
+		require.NotEqual(t, "abcd1", requestID(map[string]string{"x-request-id": "abcd1"}, options))
+	})
+
+	t.Run("generated with UUIDv7", func(t *testing.T) {
+		id, err := uuid.Parse(requestID(nil, WithRequestIDGenerator(UUIDv7)))
+		require.NoError(t, err)
+		require.Equal(t, uuid.Version(7), id.Version())
+	})
+
+	t.Run("generated with a custom function", func(t *testing.T) {
+		generator := func() (string, error) { return "custom", nil }
+		require.Equal(t, "custom", requestID(nil, WithRequestIDGenerator(generator)))
+	})
+
+	t.Run("nil generator falls back to UUIDv4", func(t *testing.T) {
+		id, err := uuid.Parse(requestID(nil, WithRequestIDGenerator(nil)))
+		require.NoError(t, err)
+		require.Equal(t, uuid.Version(4), id.Version())
+	})
+
+	t.Run("generator failure does not panic", func(t *testing.T) {
+		generator := func() (string, error) { return "", errors.New("no entropy") }
+		require.NotEmpty(t, requestID(nil, WithRequestIDGenerator(generator)))
+	})
+
+	t.Run("response header", func(t *testing.T) {
+		require.Empty(t, NewOptions(nil).RequestIDResponseHeader())
+		require.Equal(t, "x-request-id", NewOptions(nil, EchoRequestID("x-request-id")).RequestIDResponseHeader())
+	})
+}
+
+func TestULID(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id, err := ULID()
+	require.NoError(t, err)
+	require.Len(t, id, 26)
+
+	var timestamp int64
+	for _, c := range id[:10] {
+		timestamp = timestamp<<5 | int64(strings.IndexRune(crockfordBase32, c))
+	}
+	require.GreaterOrEqual(t, timestamp, before)
+	require.LessOrEqual(t, timestamp, time.Now().UnixMilli())
+
+	other, err := ULID()
+	require.NoError(t, err)
+	require.NotEqual(t, id, other)
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	// FlagSampled is the trace flag set when the caller may have recorded
+	// the trace.
+	FlagSampled byte = 0x01
+)
+
+// TraceContext is the W3C Trace Context of a request: TraceID and SpanID are
+// lowercase hex strings of 32 and 16 characters.
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	// ParentSpanID is the span id received in the traceparent header, empty
+	// when the trace started with the request.
+	ParentSpanID string
+	Flags        byte
+	// TraceState is the tracestate header, propagated as it is.
+	TraceState string
+}
+
+// IsValid reports whether the trace and span ids are set.
+func (tc TraceContext) IsValid() bool {
+	return validTraceID(tc.TraceID, 32) && validTraceID(tc.SpanID, 16)
+}
+
+// Traceparent returns the traceparent header for the calls made while
+// handling the request, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header. Versions after 00 are parsed
+// as 00, ignoring the additional fields, as the specification requires.
+func ParseTraceparent(header string) (TraceContext, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || (len(header) > 55 && (header[:2] == "00" || header[55] != '-')) {
+		return TraceContext{}, false
+	}
+	version, traceID, spanID, flags := header[0:2], header[3:35], header[36:52], header[53:55]
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return TraceContext{}, false
+	}
+	if !isLowerHex(version) || version == "ff" || !isLowerHex(flags) {
+		return TraceContext{}, false
+	}
+	if !validTraceID(traceID, 32) || !validTraceID(spanID, 16) {
+		return TraceContext{}, false
+	}
+	decoded, _ := hex.DecodeString(flags)
+	return TraceContext{TraceID: traceID, SpanID: spanID, Flags: decoded[0]}, true
+}
+
+// NewTraceContext returns the trace context of a request: a child of the
+// parent one when it has a valid trace id, or a new trace otherwise.
+func NewTraceContext(parent TraceContext) TraceContext {
+	if !validTraceID(parent.TraceID, 32) {
+		return TraceContext{TraceID: randomHex(16), SpanID: randomHex(8)}
+	}
+	tc := TraceContext{
+		TraceID:    parent.TraceID,
+		SpanID:     randomHex(8),
+		Flags:      parent.Flags,
+		TraceState: parent.TraceState,
+	}
+	if validTraceID(parent.SpanID, 16) {
+		tc.ParentSpanID = parent.SpanID
+	}
+	return tc
+}
+
+// WithTraceContext parses the traceparent and tracestate headers of the
+// requests, adding the trace.id and span.id fields to the request logger.
+// Requests without a valid traceparent start a new trace. Use
+// WithTraceExtractors for other header formats. The trace context
+// is saved in the request context: use InjectTraceContext to propagate it.
+func WithTraceContext() Option {
+	return func(o *Options) {
+		o.traceContext = true
+	}
+}
+
+// TraceIDAsRequestID uses the trace id as request id, for the requests
+// without one in the headers. It requires WithTraceContext.
+func TraceIDAsRequestID() Option {
+	return func(o *Options) {
+		o.traceIDAsRequestID = true
+	}
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx holding the trace context.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context saved by the middlewares.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of an
+// outgoing request from the trace context saved in ctx, if any.
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok || !tc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, tc.Traceparent())
+	if tc.TraceState != "" {
+		header.Set(TracestateHeader, tc.TraceState)
+	}
+}
+
+// requestTraceContext returns the trace context of the request, child of
+// the one read by the first extractor succeeding.
+func (o *Options) requestTraceContext(ctx glogger.LoggingContext) TraceContext {
+	for _, extract := range o.traceExtractors {
+		if parent, ok := extract(ctx.Request()); ok {
+			return NewTraceContext(parent)
+		}
+	}
+	return NewTraceContext(TraceContext{})
+}
+
+// validTraceID reports whether id is a lowercase hex string of the given
+// length and not all zeros.
+func validTraceID(id string, length int) bool {
+	return len(id) == length && isLowerHex(id) && strings.Trim(id, "0") != ""
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		// the ids must be unique, not secret
+		for i := range id {
+			id[i] = byte(mathrand.Intn(256))
+		}
+	}
+	if strings.Trim(string(id), "\x00") == "" {
+		id[size-1] = 1
+	}
+	return hex.EncodeToString(id)
+}
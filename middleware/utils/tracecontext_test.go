@@ -0,0 +1,157 @@
+/*
+ * Copyright 2023 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID      = "00f067aa0ba902b7"
+	traceparent = "00-" + traceID + "-" + spanID + "-01"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected bool
+	}{
+		{header: traceparent, expected: true},
+		{header: " " + traceparent + " ", expected: true},
+		{header: "01-" + traceID + "-" + spanID + "-01-future", expected: true},
+		{header: "01-" + traceID + "-" + spanID + "-01", expected: true},
+		{header: traceparent + "-extra", expected: false},
+		{header: "ff-" + traceID + "-" + spanID + "-01", expected: false},
+		{header: "00-" + traceID + "-" + spanID, expected: false},
+		{header: "00-00000000000000000000000000000000-" + spanID + "-01", expected: false},
+		{header: "00-" + traceID + "-0000000000000000-01", expected: false},
+		{header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", expected: false},
+		{header: "00_" + traceID + "_" + spanID + "_01", expected: false},
+		{header: "", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			parsed, ok := ParseTraceparent(tc.header)
+			require.Equal(t, tc.expected, ok)
+			if ok {
+				require.Equal(t, TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}, parsed)
+			}
+		})
+	}
+}
+
+func TestNewTraceContext(t *testing.T) {
+	t.Run("child of the parent", func(t *testing.T) {
+		parent := TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled, TraceState: "vendor=value"}
+		child := NewTraceContext(parent)
+		require.True(t, child.IsValid())
+		require.Equal(t, traceID, child.TraceID)
+		require.NotEqual(t, spanID, child.SpanID)
+		require.Equal(t, spanID, child.ParentSpanID)
+		require.Equal(t, FlagSampled, child.Flags)
+		require.Equal(t, "vendor=value", child.TraceState)
+
+		parsed, ok := ParseTraceparent(child.Traceparent())
+		require.True(t, ok)
+		require.Equal(t, child.SpanID, parsed.SpanID)
+	})
+
+	t.Run("new trace", func(t *testing.T) {
+		tc := NewTraceContext(TraceContext{})
+		require.True(t, tc.IsValid())
+		require.Empty(t, tc.ParentSpanID)
+		require.NotEqual(t, tc.TraceID, NewTraceContext(TraceContext{}).TraceID)
+	})
+}
+
+func TestInjectTraceContext(t *testing.T) {
+	header := http.Header{}
+	InjectTraceContext(context.Background(), header)
+	require.Empty(t, header)
+
+	tc := TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled, TraceState: "vendor=value"}
+	InjectTraceContext(ContextWithTraceContext(context.Background(), tc), header)
+	require.Equal(t, traceparent, header.Get(TraceparentHeader))
+	require.Equal(t, "vendor=value", header.Get(TracestateHeader))
+}
+
+func TestRequestInfo(t *testing.T) {
+	requestInfo := func(headers map[string]string, options ...Option) RequestInfo {
+		ctx := fake.NewContext(context.Background(), fake.Request{Headers: headers}, fake.Response{})
+		return NewOptions(nil, options...).RequestInfo(context.Background(), ctx)
+	}
+
+	t.Run("without trace context", func(t *testing.T) {
+		info := requestInfo(map[string]string{"x-request-id": "my-req-id", TraceparentHeader: traceparent})
+		require.Equal(t, RequestInfo{RequestID: "my-req-id"}, info)
+		require.Equal(t, map[string]any{RequestIDKey: "my-req-id"}, info.Fields())
+	})
+
+	t.Run("trace context from the headers", func(t *testing.T) {
+		info := requestInfo(map[string]string{
+			"x-request-id":    "my-req-id",
+			TraceparentHeader: traceparent,
+			TracestateHeader:  "vendor=value",
+		}, WithTraceContext())
+		require.Equal(t, "my-req-id", info.RequestID)
+		require.Equal(t, traceID, info.TraceContext.TraceID)
+		require.Equal(t, spanID, info.TraceContext.ParentSpanID)
+		require.Equal(t, "vendor=value", info.TraceContext.TraceState)
+		require.Equal(t, map[string]any{
+			RequestIDKey: "my-req-id",
+			TraceKey:     Trace{ID: traceID},
+			SpanKey:      Span{ID: info.TraceContext.SpanID},
+		}, info.Fields())
+	})
+
+	t.Run("new trace without a valid traceparent", func(t *testing.T) {
+		info := requestInfo(map[string]string{TraceparentHeader: "invalid"}, WithTraceContext())
+		require.True(t, info.TraceContext.IsValid())
+		require.Empty(t, info.TraceContext.ParentSpanID)
+		require.NotEqual(t, info.TraceContext.TraceID, info.RequestID)
+	})
+
+	t.Run("fields from the request context", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "tenant-1")
+		logCtx := fake.NewContext(ctx, fake.Request{Headers: map[string]string{"x-request-id": "my-req-id"}}, fake.Response{})
+		options := NewOptions(nil,
+			WithContextFields(func(ctx context.Context) map[string]any {
+				return map[string]any{"tenant": ctx.Value(key{})}
+			}),
+			WithContextFields(func(ctx context.Context) map[string]any { return nil }),
+		)
+
+		info := options.RequestInfo(ctx, logCtx)
+		require.Equal(t, map[string]any{RequestIDKey: "my-req-id", "tenant": "tenant-1"}, info.Fields())
+	})
+
+	t.Run("trace id as request id", func(t *testing.T) {
+		info := requestInfo(map[string]string{TraceparentHeader: traceparent}, WithTraceContext(), TraceIDAsRequestID())
+		require.Equal(t, traceID, info.RequestID)
+
+		info = requestInfo(map[string]string{"x-request-id": "my-req-id"}, WithTraceContext(), TraceIDAsRequestID())
+		require.Equal(t, "my-req-id", info.RequestID)
+	})
+}
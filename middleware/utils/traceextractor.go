@@ -0,0 +1,158 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mia-platform/glogger/v4"
+)
+
+// TraceExtractor reads the trace context of the caller from the headers of
+// a request. The TraceID and SpanID of the result are normalized to the W3C
+// format, lowercase hex of 32 and 16 characters; the SpanID is empty when
+// the format does not carry the caller span.
+type TraceExtractor func(req glogger.RequestLoggingContext) (TraceContext, bool)
+
+// WithTraceExtractors sets the formats of the trace headers, tried in order,
+// and enables the trace context like WithTraceContext. The default is
+// ExtractW3C only.
+func WithTraceExtractors(extractors ...TraceExtractor) Option {
+	return func(o *Options) {
+		o.traceContext = true
+		o.traceExtractors = extractors
+	}
+}
+
+// ExtractW3C reads the W3C traceparent and tracestate headers.
+func ExtractW3C(req glogger.RequestLoggingContext) (TraceContext, bool) {
+	tc, ok := ParseTraceparent(req.GetHeader(TraceparentHeader))
+	if ok {
+		tc.TraceState = req.GetHeader(TracestateHeader)
+	}
+	return tc, ok
+}
+
+// ExtractB3 reads the Zipkin B3 headers, in the single header format
+// (b3: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}) or in the multi
+// header one (X-B3-TraceId, X-B3-SpanId, X-B3-Sampled and X-B3-Flags).
+// 64 bit trace ids are left padded with zeros.
+func ExtractB3(req glogger.RequestLoggingContext) (TraceContext, bool) {
+	if header := req.GetHeader("b3"); header != "" {
+		parts := strings.Split(header, "-")
+		if len(parts) < 2 {
+			// only the sampling state, e.g. b3: 0
+			return TraceContext{}, false
+		}
+		sampled := len(parts) > 2 && (parts[2] == "1" || parts[2] == "d")
+		return newExtractedTraceContext(parts[0], parts[1], sampled)
+	}
+
+	sampled := req.GetHeader("x-b3-sampled")
+	return newExtractedTraceContext(
+		req.GetHeader("x-b3-traceid"),
+		req.GetHeader("x-b3-spanid"),
+		sampled == "1" || sampled == "true" || req.GetHeader("x-b3-flags") == "1",
+	)
+}
+
+// ExtractJaeger reads the Jaeger uber-trace-id header:
+// {trace-id}:{span-id}:{parent-span-id}:{flags}. Ids shorter than the W3C
+// ones are left padded with zeros.
+func ExtractJaeger(req glogger.RequestLoggingContext) (TraceContext, bool) {
+	header := req.GetHeader("uber-trace-id")
+	if unescaped, err := url.QueryUnescape(header); err == nil {
+		header = unescaped
+	}
+	parts := strings.Split(header, ":")
+	if len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return TraceContext{}, false
+	}
+	return newExtractedTraceContext(parts[0], parts[1], flags&1 == 1)
+}
+
+// ExtractCloudTrace reads the Google Cloud X-Cloud-Trace-Context header:
+// {TRACE_ID}/{SPAN_ID};o={OPTIONS}, with the span id in decimal.
+func ExtractCloudTrace(req glogger.RequestLoggingContext) (TraceContext, bool) {
+	header := req.GetHeader("x-cloud-trace-context")
+	traceID, rest, found := strings.Cut(header, "/")
+	if !found {
+		return newExtractedTraceContext(traceID, "", false)
+	}
+	spanID, options, _ := strings.Cut(rest, ";")
+	span, err := strconv.ParseUint(spanID, 10, 64)
+	if err != nil {
+		return TraceContext{}, false
+	}
+	return newExtractedTraceContext(traceID, strconv.FormatUint(span, 16), options == "o=1")
+}
+
+// ExtractAmazonTraceID reads the AWS X-Amzn-Trace-Id header set by the load
+// balancers and X-Ray: Root=1-{time}-{id};Parent={span};Sampled=1. The
+// trace id is the time and the id joined, as in the X-Ray W3C format.
+func ExtractAmazonTraceID(req glogger.RequestLoggingContext) (TraceContext, bool) {
+	var root, parent string
+	var sampled bool
+	for _, field := range strings.Split(req.GetHeader("x-amzn-trace-id"), ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "root":
+			root = value
+		case "parent":
+			parent = value
+		case "sampled":
+			sampled = value == "1"
+		}
+	}
+	parts := strings.Split(root, "-")
+	if len(parts) != 3 || parts[0] != "1" || len(parts[1]) != 8 || len(parts[2]) != 24 {
+		return TraceContext{}, false
+	}
+	return newExtractedTraceContext(parts[1]+parts[2], parent, sampled)
+}
+
+// newExtractedTraceContext normalizes the ids: a missing or invalid span id
+// is dropped, keeping the trace.
+func newExtractedTraceContext(traceID, spanID string, sampled bool) (TraceContext, bool) {
+	traceID = normalizeTraceID(traceID, 32)
+	if !validTraceID(traceID, 32) {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{TraceID: traceID}
+	if spanID = normalizeTraceID(spanID, 16); validTraceID(spanID, 16) {
+		tc.SpanID = spanID
+	}
+	if sampled {
+		tc.Flags = FlagSampled
+	}
+	return tc, true
+}
+
+// normalizeTraceID lowercases id and left pads it with zeros up to length.
+func normalizeTraceID(id string, length int) string {
+	id = strings.ToLower(id)
+	if id != "" && len(id) < length {
+		id = strings.Repeat("0", length-len(id)) + id
+	}
+	return id
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2024 Mia srl
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mia-platform/glogger/v4/middleware/utils/internal/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceExtractors(t *testing.T) {
+	testCases := []struct {
+		name      string
+		extractor TraceExtractor
+		headers   map[string]string
+		expected  TraceContext
+		ok        bool
+	}{
+		{
+			name:      "w3c",
+			extractor: ExtractW3C,
+			headers:   map[string]string{TraceparentHeader: traceparent, TracestateHeader: "vendor=value"},
+			expected:  TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled, TraceState: "vendor=value"},
+			ok:        true,
+		},
+		{
+			name:      "b3 single header",
+			extractor: ExtractB3,
+			headers:   map[string]string{"b3": traceID + "-" + spanID + "-1-05e3ac9a4f6e3b90"},
+			expected:  TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "b3 single header with 64 bit trace id",
+			extractor: ExtractB3,
+			headers:   map[string]string{"b3": "a3ce929d0e0e4736-" + spanID},
+			expected:  TraceContext{TraceID: "0000000000000000a3ce929d0e0e4736", SpanID: spanID},
+			ok:        true,
+		},
+		{
+			name:      "b3 single header with sampling state only",
+			extractor: ExtractB3,
+			headers:   map[string]string{"b3": "0"},
+		},
+		{
+			name:      "b3 multi header",
+			extractor: ExtractB3,
+			headers:   map[string]string{"x-b3-traceid": traceID, "x-b3-spanid": spanID, "x-b3-sampled": "1"},
+			expected:  TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "b3 multi header debug",
+			extractor: ExtractB3,
+			headers:   map[string]string{"x-b3-traceid": traceID, "x-b3-spanid": spanID, "x-b3-flags": "1"},
+			expected:  TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "b3 missing",
+			extractor: ExtractB3,
+		},
+		{
+			name:      "jaeger",
+			extractor: ExtractJaeger,
+			headers:   map[string]string{"uber-trace-id": traceID + ":" + spanID + ":0:1"},
+			expected:  TraceContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "jaeger url encoded with short ids",
+			extractor: ExtractJaeger,
+			headers:   map[string]string{"uber-trace-id": "a3ce929d0e0e4736%3Af067aa0ba902b7%3A0%3A0"},
+			expected:  TraceContext{TraceID: "0000000000000000a3ce929d0e0e4736", SpanID: spanID},
+			ok:        true,
+		},
+		{
+			name:      "jaeger invalid",
+			extractor: ExtractJaeger,
+			headers:   map[string]string{"uber-trace-id": traceID + ":" + spanID},
+		},
+		{
+			name:      "cloud trace",
+			extractor: ExtractCloudTrace,
+			headers:   map[string]string{"x-cloud-trace-context": traceID + "/2205310701640571284;o=1"},
+			expected:  TraceContext{TraceID: traceID, SpanID: "1e9ad6661ea75994", Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "cloud trace without span",
+			extractor: ExtractCloudTrace,
+			headers:   map[string]string{"x-cloud-trace-context": traceID},
+			expected:  TraceContext{TraceID: traceID},
+			ok:        true,
+		},
+		{
+			name:      "cloud trace invalid span",
+			extractor: ExtractCloudTrace,
+			headers:   map[string]string{"x-cloud-trace-context": traceID + "/abc"},
+		},
+		{
+			name:      "amazon",
+			extractor: ExtractAmazonTraceID,
+			headers:   map[string]string{"x-amzn-trace-id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"},
+			expected:  TraceContext{TraceID: "5759e988bd862e3fe1be46a994272793", SpanID: "53995c3f42cd8ad8", Flags: FlagSampled},
+			ok:        true,
+		},
+		{
+			name:      "amazon load balancer",
+			extractor: ExtractAmazonTraceID,
+			headers:   map[string]string{"x-amzn-trace-id": "Self=1-67891234-12456789abcdef012345678;Root=1-5759e988-bd862e3fe1be46a994272793"},
+			expected:  TraceContext{TraceID: "5759e988bd862e3fe1be46a994272793"},
+			ok:        true,
+		},
+		{
+			name:      "amazon invalid",
+			extractor: ExtractAmazonTraceID,
+			headers:   map[string]string{"x-amzn-trace-id": "Root=2-5759e988-bd862e3fe1be46a994272793"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := fake.NewContext(context.Background(), fake.Request{Headers: tc.headers}, fake.Response{})
+			extracted, ok := tc.extractor(ctx.Request())
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.expected, extracted)
+		})
+	}
+}
+
+func TestWithTraceExtractors(t *testing.T) {
+	requestInfo := func(headers map[string]string, options ...Option) RequestInfo {
+		ctx := fake.NewContext(context.Background(), fake.Request{Headers: headers}, fake.Response{})
+		return NewOptions(nil, options...).RequestInfo(context.Background(), ctx)
+	}
+
+	t.Run("first extractor succeeding", func(t *testing.T) {
+		headers := map[string]string{
+			"uber-trace-id":   "a3ce929d0e0e4736:" + spanID + ":0:1",
+			TraceparentHeader: traceparent,
+		}
+		info := requestInfo(headers, WithTraceExtractors(ExtractB3, ExtractJaeger, ExtractW3C))
+		require.Equal(t, "0000000000000000a3ce929d0e0e4736", info.TraceContext.TraceID)
+		require.Equal(t, spanID, info.TraceContext.ParentSpanID)
+		require.Equal(t, Trace{ID: "0000000000000000a3ce929d0e0e4736"}, info.Fields()[TraceKey])
+	})
+
+	t.Run("trace without the caller span", func(t *testing.T) {
+		headers := map[string]string{"x-amzn-trace-id": "Root=1-5759e988-bd862e3fe1be46a994272793"}
+		info := requestInfo(headers, WithTraceExtractors(ExtractAmazonTraceID))
+		require.Equal(t, "5759e988bd862e3fe1be46a994272793", info.TraceContext.TraceID)
+		require.Empty(t, info.TraceContext.ParentSpanID)
+		require.True(t, info.TraceContext.IsValid())
+	})
+
+	t.Run("w3c ignored when not listed", func(t *testing.T) {
+		info := requestInfo(map[string]string{TraceparentHeader: traceparent}, WithTraceExtractors(ExtractB3))
+		require.NotEqual(t, traceID, info.TraceContext.TraceID)
+	})
+}